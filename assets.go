@@ -0,0 +1,75 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"lanpaper/handlers"
+)
+
+// embeddedAssets holds the admin page and its static files, so a lone
+// binary can serve the UI. Files on disk take precedence, which keeps
+// customised copies working; static/images (uploads) is never embedded.
+//
+//go:embed admin.html
+//go:embed static/css static/fonts static/i18n static/icons static/js
+//go:embed static/favicon.svg static/logo.svg static/logo-dark.svg static/preview.png
+//go:embed static/manifest.json static/sw.js
+var embeddedAssets embed.FS
+
+// overlayFS opens names from disk first and from fallback when they don't
+// exist there.
+type overlayFS struct {
+	disk, fallback fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.disk.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.fallback.Open(name)
+	}
+	return f, err
+}
+
+// staticHandler serves /static/ (prefix already stripped) from ./static,
+// falling back to the embedded copy, with a day-long cache lifetime.
+// The app uses ?t=<timestamp> cache-busting on dynamic resources.
+// Content-keyed previews (HashedPreviews) never change under their name
+// and are cached as immutable.
+func staticHandler() http.Handler {
+	files := http.FileServerFS(staticFS())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "images/previews/") && handlers.IsKeyedPreview(path.Base(r.URL.Path)) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// faviconMaxAge is the cache lifetime of /favicon.ico. Browsers ask for it
+// on every page and kiosk reload; it changes only with a new release.
+const faviconMaxAge = 7 * 24 * 60 * 60 // seconds
+
+// faviconHandler serves /favicon.ico from static/icons/favicon.png, so the
+// request browsers make on their own doesn't fall through to link lookup.
+func faviconHandler() http.Handler {
+	fsys := staticFS()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(faviconMaxAge))
+		http.ServeFileFS(w, r, fsys, "icons/favicon.png")
+	})
+}
+
+// staticFS is ./static overlaid on the embedded copy.
+func staticFS() fs.FS {
+	embedded, _ := fs.Sub(embeddedAssets, "static")
+	return overlayFS{disk: os.DirFS("static"), fallback: embedded}
+}
@@ -2,9 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -22,23 +25,77 @@ type CompressionConfig struct {
 }
 
 type Config struct {
-	Port                 string            `json:"port"`
-	MaxUploadMB          int               `json:"maxUploadMB"`
-	MaxImages            int               `json:"maxImages"`
-	MaxConcurrentUploads int               `json:"maxConcurrentUploads"`
-	MaxWalkDepth         int               `json:"maxWalkDepth"`
-	ExternalImageDir     string            `json:"externalImageDir"`
-	AdminUser            string            `json:"adminUser"`
-	AdminPass            string            `json:"adminPass"`
-	DisableAuth          bool              `json:"disableAuth,omitempty"`
-	InsecureSkipVerify   bool              `json:"insecureSkipVerify,omitempty"`
-	ProxyHost            string            `json:"proxyHost,omitempty"`
-	ProxyPort            string            `json:"proxyPort,omitempty"`
-	ProxyType            string            `json:"proxyType,omitempty"`
-	ProxyUsername        string            `json:"proxyUsername,omitempty"`
-	ProxyPassword        string            `json:"proxyPassword,omitempty"`
-	Rate                 RateConfig        `json:"rate"`
-	Compression          CompressionConfig `json:"compression"`
+	Port                  string            `json:"port"`
+	MaxUploadMB           int               `json:"maxUploadMB"`
+	MultipartMemoryMB     int               `json:"multipartMemoryMB,omitempty"` // upload bytes held in RAM before spilling to a temp file
+	TempDir               string            `json:"tempDir,omitempty"`           // where uploads spill; the OS temp dir when empty
+	MaxMultipartParts     int               `json:"maxMultipartParts,omitempty"` // form parts accepted per upload request
+	MaxImages             int               `json:"maxImages"`
+	MaxConcurrentUploads  int               `json:"maxConcurrentUploads"`
+	MaxConcurrentDecodes  int               `json:"maxConcurrentDecodes,omitempty"` // 0 = same as MaxConcurrentUploads
+	DecodeMemoryMB        int               `json:"decodeMemoryMB,omitempty"`       // budget shared by concurrent decodes
+	DecodeTimeout         int               `json:"decodeTimeout,omitempty"`        // seconds before a decode is abandoned; 0 disables
+	ExifMaxEntries        int               `json:"exifMaxEntries,omitempty"`       // IFD entries read per file before its EXIF is ignored
+	ExifMaxBytes          int               `json:"exifMaxBytes,omitempty"`         // metadata bytes read per file before its EXIF is ignored
+	MaxWalkDepth          int               `json:"maxWalkDepth"`
+	ExternalWalkCacheTTL  int               `json:"externalWalkCacheTTL,omitempty"` // seconds a directory listing is reused; 0 disables
+	BackupCount           int               `json:"backupCount,omitempty"`          // timestamped copies of wallpapers.json to keep
+	ExternalImageDir      string            `json:"externalImageDir"`
+	DisableExternalImages bool              `json:"disableExternalImages,omitempty"` // hide the external image browser and refuse local-path uploads
+	AdminUser             string            `json:"adminUser"`
+	AdminPass             string            `json:"adminPass" redact:"true"`
+	SessionSecret         string            `json:"sessionSecret,omitempty" redact:"true"` // signs login cookies; random per process when empty
+	DisableAuth           bool              `json:"disableAuth,omitempty"`
+	InsecureSkipVerify    bool              `json:"insecureSkipVerify,omitempty"`
+	RequireHTTPSDownloads bool              `json:"requireHTTPSDownloads,omitempty"` // refuse plaintext http:// URL uploads
+	ProxyHost             string            `json:"proxyHost,omitempty"`
+	ProxyPort             string            `json:"proxyPort,omitempty"`
+	ProxyType             string            `json:"proxyType,omitempty"`
+	ProxyUsername         string            `json:"proxyUsername,omitempty"`
+	ProxyPassword         string            `json:"proxyPassword,omitempty" redact:"true"`
+	Rate                  RateConfig        `json:"rate"`
+	Compression           CompressionConfig `json:"compression"`
+	PreviewFormat         string            `json:"previewFormat,omitempty"` // "webp" or "jpeg"
+	JPEGChroma            string            `json:"jpegChroma,omitempty"`    // "420" or "444" chroma subsampling
+	JPEGProgressive       bool              `json:"jpegProgressive,omitempty"`
+	NormalizeToSRGB       bool              `json:"normalizeToSRGB,omitempty"` // convert images with an embedded ICC profile to sRGB
+	AutoCategorize        bool              `json:"autoCategorize,omitempty"`
+	DefaultCategory       string            `json:"defaultCategory,omitempty"`    // category of links created without one
+	InferCategory         bool              `json:"inferCategory,omitempty"`      // uploads to a default-category link become "image" or "video"
+	CategoryMaxPixels     map[string]int    `json:"categoryMaxPixels,omitempty"`  // per-category cap on width×height; unlisted categories only have MaxImageDimension
+	UseExifDate           bool              `json:"useExifDate,omitempty"`        // date new uploads by their EXIF capture time
+	PerceptualHash        bool              `json:"perceptualHash,omitempty"`     // hash uploads for /api/similar
+	VideoThumbnails       bool              `json:"videoThumbnails,omitempty"`    // requires ffmpeg on PATH
+	VideoThumbFallback    string            `json:"videoThumbFallback,omitempty"` // "placeholder", "none" or "fail"
+	PublicGallery         bool              `json:"publicGallery,omitempty"`      // serve GET /api/gallery without auth
+	NoIndex               bool              `json:"noIndex,omitempty"`            // ask crawlers not to index public images
+	ImageSizeHeaders      bool              `json:"imageSizeHeaders,omitempty"`   // send X-Image-Width/Height with public images
+	HotlinkProtection     bool              `json:"hotlinkProtection,omitempty"`  // refuse public images embedded by other sites
+	AllowedReferers       []string          `json:"allowedReferers,omitempty"`    // hosts allowed to embed public images under HotlinkProtection
+	RobotsTxt             string            `json:"robotsTxt,omitempty"`          // custom /robots.txt body
+	StrictTypeCheck       bool              `json:"strictTypeCheck,omitempty"`    // decoder format must match the content sniff
+	HashedStorage         bool              `json:"hashedStorage,omitempty"`      // name stored files by content hash, not link name
+	HashedPreviews        bool              `json:"hashedPreviews,omitempty"`     // name previews "{link}.{hash}.webp" so they can be cached as immutable
+	BasePath              string            `json:"basePath,omitempty"`           // URL prefix all routes are served under, e.g. "/wallpaper"
+	PublicBaseURL         string            `json:"publicBaseURL,omitempty"`      // external origin for absolute links, e.g. "https://walls.example.com"
+	PrettyAPI             bool              `json:"prettyAPI,omitempty"`          // indent every JSON response, as ?pretty=1 does
+	// Webhooks are URLs POSTed a JSON event whenever a link changes.
+	Webhooks []string `json:"webhooks,omitempty" redact:"true"`
+	// WebhookSecret signs webhook bodies (HMAC-SHA256) so receivers can
+	// check they came from this server.
+	WebhookSecret string `json:"webhookSecret,omitempty" redact:"true"`
+	// MQTTBroker is the broker (tcp://, ssl://, ws:// or wss://host:port)
+	// the same events are published to as JSON on MQTTTopic. Empty disables MQTT.
+	MQTTBroker   string `json:"mqttBroker,omitempty"`
+	MQTTTopic    string `json:"mqttTopic,omitempty"`
+	MQTTUsername string `json:"mqttUsername,omitempty"`
+	MQTTPassword string `json:"mqttPassword,omitempty" redact:"true"`
+	// AccessLog enables request logging: "true"/"stdout" or a file path. Empty disables it.
+	AccessLog       string `json:"accessLog,omitempty"`
+	AccessLogFormat string `json:"accessLogFormat,omitempty"` // "common" or "json"
+	// Strict makes main refuse to start when Problems is non-empty instead
+	// of running with the corrected values.
+	Strict bool `json:"strict,omitempty"`
 	// TrustedProxy is the IP or CIDR of a reverse proxy in front of Lanpaper.
 	// X-Real-IP / X-Forwarded-For are trusted only for requests from this address.
 	TrustedProxy string `json:"trustedProxy,omitempty"`
@@ -55,41 +112,36 @@ type parsedProxy struct {
 
 var cachedProxyPtr atomic.Pointer[parsedProxy]
 
+// problems collects every setting Load had to ignore or correct, so strict
+// mode can refuse to start instead of running with silent fallbacks.
+var problems []string
+
+// warnf logs a configuration warning and records it in problems.
+func warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", msg)
+	problems = append(problems, msg)
+}
+
+// Problems returns the configuration problems found by the last Load.
+func Problems() []string {
+	return append([]string(nil), problems...)
+}
+
+// File is the JSON config file Load reads; main's --config flag changes it.
+var File = "config.json"
+
 // Load loads configuration with priority: env vars > config.json > defaults
 func Load() {
+	problems = nil
+
 	// Step 1: Load defaults
-	Current = Config{
-		Port:                 "8080",
-		MaxUploadMB:          DefaultMaxUploadMB,
-		MaxImages:            0,
-		MaxConcurrentUploads: DefaultMaxConcurrentUploads,
-		MaxWalkDepth:         DefaultMaxWalkDepth,
-		ExternalImageDir:     "external/images",
-		AdminUser:            "",
-		AdminPass:            "",
-		DisableAuth:          false,
-		InsecureSkipVerify:   false,
-		ProxyHost:            "",
-		ProxyPort:            "",
-		ProxyType:            "http",
-		ProxyUsername:        "",
-		ProxyPassword:        "",
-		TrustedProxy:         "",
-		Rate: RateConfig{
-			PublicPerMin: DefaultPublicRatePerMin,
-			UploadPerMin: DefaultUploadRatePerMin,
-			Burst:        DefaultRateBurst,
-		},
-		Compression: CompressionConfig{
-			Quality: DefaultCompressionQuality,
-			Scale:   DefaultCompressionScale,
-		},
-	}
+	Current = Defaults()
 
 	// Step 2: Override with config.json (if exists)
-	if data, err := os.ReadFile("config.json"); err == nil {
-		if err := json.Unmarshal(data, &Current); err != nil {
-			log.Printf("Warning: failed to parse config.json: %v", err)
+	if data, err := os.ReadFile(File); err == nil {
+		if err := json.Unmarshal(migrateLegacyKeys(data), &Current); err != nil {
+			warnf("failed to parse %s: %v", File, err)
 		}
 	}
 
@@ -100,21 +152,95 @@ func Load() {
 	if v := os.Getenv("MAX_UPLOAD_MB"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.MaxUploadMB = n
+		} else {
+			warnf("invalid MAX_UPLOAD_MB %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("MAX_IMAGES"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.MaxImages = n
+		} else {
+			warnf("invalid MAX_IMAGES %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("MAX_CONCURRENT_UPLOADS"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.MaxConcurrentUploads = n
+		} else {
+			warnf("invalid MAX_CONCURRENT_UPLOADS %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("MULTIPART_MEMORY_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.MultipartMemoryMB = n
+		} else {
+			warnf("invalid MULTIPART_MEMORY_MB %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("TEMP_DIR"); v != "" {
+		Current.TempDir = v
+	}
+	if v := os.Getenv("MAX_MULTIPART_PARTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.MaxMultipartParts = n
+		} else {
+			warnf("invalid MAX_MULTIPART_PARTS %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("MAX_CONCURRENT_DECODES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.MaxConcurrentDecodes = n
+		} else {
+			warnf("invalid MAX_CONCURRENT_DECODES %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("DECODE_MEMORY_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.DecodeMemoryMB = n
+		} else {
+			warnf("invalid DECODE_MEMORY_MB %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("DECODE_TIMEOUT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.DecodeTimeout = n
+		} else {
+			warnf("invalid DECODE_TIMEOUT %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("EXIF_MAX_ENTRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.ExifMaxEntries = n
+		} else {
+			warnf("invalid EXIF_MAX_ENTRIES %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("EXIF_MAX_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.ExifMaxBytes = n
+		} else {
+			warnf("invalid EXIF_MAX_BYTES %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("BACKUP_COUNT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.BackupCount = n
+		} else {
+			warnf("invalid BACKUP_COUNT %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("MAX_WALK_DEPTH"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.MaxWalkDepth = n
+		} else {
+			warnf("invalid MAX_WALK_DEPTH %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("EXTERNAL_WALK_CACHE_TTL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			Current.ExternalWalkCacheTTL = n
+		} else {
+			warnf("invalid EXTERNAL_WALK_CACHE_TTL %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("EXTERNAL_IMAGE_DIR"); v != "" {
@@ -126,14 +252,35 @@ func Load() {
 	if v := os.Getenv("ADMIN_PASS"); v != "" {
 		Current.AdminPass = v
 	}
+	if v := os.Getenv("SESSION_SECRET"); v != "" {
+		Current.SessionSecret = v
+	}
 	if v := os.Getenv("DISABLE_AUTH"); v != "" {
 		if b, err := strconv.ParseBool(v); err == nil {
 			Current.DisableAuth = b
+		} else {
+			warnf("invalid DISABLE_AUTH %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("DISABLE_EXTERNAL_IMAGES"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.DisableExternalImages = b
+		} else {
+			warnf("invalid DISABLE_EXTERNAL_IMAGES %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("INSECURE_SKIP_VERIFY"); v != "" {
 		if b, err := strconv.ParseBool(v); err == nil {
 			Current.InsecureSkipVerify = b
+		} else {
+			warnf("invalid INSECURE_SKIP_VERIFY %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("REQUIRE_HTTPS_DOWNLOADS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.RequireHTTPSDownloads = b
+		} else {
+			warnf("invalid REQUIRE_HTTPS_DOWNLOADS %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("PROXY_HOST"); v != "" {
@@ -165,16 +312,22 @@ func Load() {
 	if v := os.Getenv("RATE_PUBLIC_PER_MIN"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.Rate.PublicPerMin = n
+		} else {
+			warnf("invalid RATE_PUBLIC_PER_MIN %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("RATE_UPLOAD_PER_MIN"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.Rate.UploadPerMin = n
+		} else {
+			warnf("invalid RATE_UPLOAD_PER_MIN %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("RATE_BURST"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.Rate.Burst = n
+		} else {
+			warnf("invalid RATE_BURST %q, ignoring", v)
 		}
 	}
 
@@ -182,13 +335,202 @@ func Load() {
 	if v := os.Getenv("COMPRESSION_QUALITY"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.Compression.Quality = n
+		} else {
+			warnf("invalid COMPRESSION_QUALITY %q, ignoring", v)
 		}
 	}
 	if v := os.Getenv("COMPRESSION_SCALE"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			Current.Compression.Scale = n
+		} else {
+			warnf("invalid COMPRESSION_SCALE %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("PREVIEW_FORMAT"); v != "" {
+		Current.PreviewFormat = v
+	}
+	if v := os.Getenv("JPEG_CHROMA"); v != "" {
+		Current.JPEGChroma = v
+	}
+	if v := os.Getenv("JPEG_PROGRESSIVE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.JPEGProgressive = b
+		} else {
+			warnf("invalid JPEG_PROGRESSIVE %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("NORMALIZE_TO_SRGB"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.NormalizeToSRGB = b
+		} else {
+			warnf("invalid NORMALIZE_TO_SRGB %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("AUTO_CATEGORIZE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.AutoCategorize = b
+		} else {
+			warnf("invalid AUTO_CATEGORIZE %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("DEFAULT_CATEGORY"); v != "" {
+		Current.DefaultCategory = v
+	}
+	if v := os.Getenv("INFER_CATEGORY"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.InferCategory = b
+		} else {
+			warnf("invalid INFER_CATEGORY %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("CATEGORY_MAX_PIXELS"); v != "" {
+		// "mobile=2073600,other=1000000"
+		limits := map[string]int{}
+		for _, pair := range strings.Split(v, ",") {
+			cat, n, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			px, err := strconv.Atoi(strings.TrimSpace(n))
+			if !ok || err != nil {
+				warnf("invalid CATEGORY_MAX_PIXELS entry %q, ignoring", pair)
+				continue
+			}
+			limits[strings.TrimSpace(cat)] = px
+		}
+		Current.CategoryMaxPixels = limits
+	}
+	if v := os.Getenv("PERCEPTUAL_HASH"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.PerceptualHash = b
+		} else {
+			warnf("invalid PERCEPTUAL_HASH %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("USE_EXIF_DATE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.UseExifDate = b
+		} else {
+			warnf("invalid USE_EXIF_DATE %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("VIDEO_THUMBNAILS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.VideoThumbnails = b
+		} else {
+			warnf("invalid VIDEO_THUMBNAILS %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("VIDEO_THUMB_FALLBACK"); v != "" {
+		Current.VideoThumbFallback = v
+	}
+	if v := os.Getenv("NO_INDEX"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.NoIndex = b
+		} else {
+			warnf("invalid NO_INDEX %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("IMAGE_SIZE_HEADERS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.ImageSizeHeaders = b
+		} else {
+			warnf("invalid IMAGE_SIZE_HEADERS %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("HOTLINK_PROTECTION"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.HotlinkProtection = b
+		} else {
+			warnf("invalid HOTLINK_PROTECTION %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("ALLOWED_REFERERS"); v != "" {
+		Current.AllowedReferers = nil
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				Current.AllowedReferers = append(Current.AllowedReferers, h)
+			}
+		}
+	}
+	if v := os.Getenv("PRETTY_API"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.PrettyAPI = b
+		} else {
+			warnf("invalid PRETTY_API %q, ignoring", v)
 		}
 	}
+	if v := os.Getenv("ROBOTS_TXT"); v != "" {
+		// Env values are single-line; accept \n for line breaks.
+		Current.RobotsTxt = strings.ReplaceAll(v, `\n`, "\n")
+	}
+	if v := os.Getenv("PUBLIC_GALLERY"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.PublicGallery = b
+		} else {
+			warnf("invalid PUBLIC_GALLERY %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("STRICT_TYPE_CHECK"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.StrictTypeCheck = b
+		} else {
+			warnf("invalid STRICT_TYPE_CHECK %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("HASHED_STORAGE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.HashedStorage = b
+		} else {
+			warnf("invalid HASHED_STORAGE %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("HASHED_PREVIEWS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.HashedPreviews = b
+		} else {
+			warnf("invalid HASHED_PREVIEWS %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("BASE_PATH"); v != "" {
+		Current.BasePath = v
+	}
+	if v := os.Getenv("PUBLIC_BASE_URL"); v != "" {
+		Current.PublicBaseURL = v
+	}
+	if v := os.Getenv("WEBHOOKS"); v != "" {
+		Current.Webhooks = nil
+		for _, u := range strings.Split(v, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				Current.Webhooks = append(Current.Webhooks, u)
+			}
+		}
+	}
+	if v := os.Getenv("WEBHOOK_SECRET"); v != "" {
+		Current.WebhookSecret = v
+	}
+	if v := os.Getenv("MQTT_BROKER"); v != "" {
+		Current.MQTTBroker = v
+	}
+	if v := os.Getenv("MQTT_TOPIC"); v != "" {
+		Current.MQTTTopic = v
+	}
+	if v := os.Getenv("MQTT_USERNAME"); v != "" {
+		Current.MQTTUsername = v
+	}
+	if v := os.Getenv("MQTT_PASSWORD"); v != "" {
+		Current.MQTTPassword = v
+	}
+	if v := os.Getenv("CONFIG_STRICT"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			Current.Strict = b
+		} else {
+			warnf("invalid CONFIG_STRICT %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("ACCESS_LOG"); v != "" {
+		Current.AccessLog = v
+	}
+	if v := os.Getenv("ACCESS_LOG_FORMAT"); v != "" {
+		Current.AccessLogFormat = v
+	}
 
 	validate()
 
@@ -238,19 +580,49 @@ func IsTrustedProxy(remoteAddr string) bool {
 func validate() {
 	portStr := strings.TrimPrefix(Current.Port, ":")
 	if n, err := strconv.Atoi(portStr); err != nil || n < 1 || n > 65535 {
-		log.Printf("Warning: invalid port %q, using 8080", Current.Port)
+		warnf("invalid port %q, using 8080", Current.Port)
 		Current.Port = "8080"
 	}
 
 	if Current.MaxUploadMB < MinUploadMB {
-		log.Printf("Warning: MaxUploadMB %d is below minimum %d, using %d", Current.MaxUploadMB, MinUploadMB, DefaultMaxUploadMB)
+		warnf("MaxUploadMB %d is below minimum %d, using %d", Current.MaxUploadMB, MinUploadMB, DefaultMaxUploadMB)
 		Current.MaxUploadMB = DefaultMaxUploadMB
 	}
 	if Current.MaxConcurrentUploads <= 0 {
 		Current.MaxConcurrentUploads = DefaultMaxConcurrentUploads
 	}
+	if Current.MultipartMemoryMB <= 0 {
+		Current.MultipartMemoryMB = DefaultMultipartMemoryMB
+	}
+	if Current.MaxMultipartParts <= 0 {
+		Current.MaxMultipartParts = DefaultMaxMultipartParts
+	}
+	if Current.MaxConcurrentDecodes <= 0 {
+		Current.MaxConcurrentDecodes = Current.MaxConcurrentUploads
+	}
+	if Current.DecodeMemoryMB <= 0 {
+		Current.DecodeMemoryMB = DefaultDecodeMemoryMB
+	}
+	if Current.DecodeTimeout < 0 {
+		warnf("DecodeTimeout %d is negative, using 0", Current.DecodeTimeout)
+		Current.DecodeTimeout = 0
+	}
+	if Current.ExifMaxEntries <= 0 {
+		Current.ExifMaxEntries = DefaultExifMaxEntries
+	}
+	if Current.ExifMaxBytes <= 0 {
+		Current.ExifMaxBytes = DefaultExifMaxBytes
+	}
+	if Current.BackupCount < 0 {
+		warnf("BackupCount %d is negative, using 0", Current.BackupCount)
+		Current.BackupCount = 0
+	}
+	if Current.ExternalWalkCacheTTL < 0 {
+		warnf("ExternalWalkCacheTTL %d is negative, using 0", Current.ExternalWalkCacheTTL)
+		Current.ExternalWalkCacheTTL = 0
+	}
 	if Current.MaxWalkDepth <= 0 || Current.MaxWalkDepth > 10 {
-		log.Printf("Warning: MaxWalkDepth %d out of range (1-10), using %d", Current.MaxWalkDepth, DefaultMaxWalkDepth)
+		warnf("MaxWalkDepth %d out of range (1-10), using %d", Current.MaxWalkDepth, DefaultMaxWalkDepth)
 		Current.MaxWalkDepth = DefaultMaxWalkDepth
 	}
 
@@ -265,26 +637,130 @@ func validate() {
 	}
 
 	if Current.Compression.Quality < 1 || Current.Compression.Quality > 100 {
-		log.Printf("Warning: COMPRESSION_QUALITY %d out of range (1-100), using %d", Current.Compression.Quality, DefaultCompressionQuality)
+		warnf("COMPRESSION_QUALITY %d out of range (1-100), using %d", Current.Compression.Quality, DefaultCompressionQuality)
 		Current.Compression.Quality = DefaultCompressionQuality
 	}
 	if Current.Compression.Scale < 1 || Current.Compression.Scale > 100 {
-		log.Printf("Warning: COMPRESSION_SCALE %d out of range (1-100), using %d", Current.Compression.Scale, DefaultCompressionScale)
+		warnf("COMPRESSION_SCALE %d out of range (1-100), using %d", Current.Compression.Scale, DefaultCompressionScale)
 		Current.Compression.Scale = DefaultCompressionScale
 	}
 
+	switch strings.ToLower(Current.PreviewFormat) {
+	case "webp", "":
+		Current.PreviewFormat = DefaultPreviewFormat
+	case "jpeg", "jpg":
+		Current.PreviewFormat = "jpeg"
+	default:
+		warnf("invalid PREVIEW_FORMAT %q (webp|jpeg), using %s", Current.PreviewFormat, DefaultPreviewFormat)
+		Current.PreviewFormat = DefaultPreviewFormat
+	}
+
+	switch strings.ReplaceAll(Current.JPEGChroma, ":", "") {
+	case "":
+		Current.JPEGChroma = DefaultJPEGChroma
+	case "420", "444":
+		Current.JPEGChroma = strings.ReplaceAll(Current.JPEGChroma, ":", "")
+	default:
+		warnf("invalid JPEG_CHROMA %q (420|444), using %s", Current.JPEGChroma, DefaultJPEGChroma)
+		Current.JPEGChroma = DefaultJPEGChroma
+	}
+
+	switch strings.ToLower(Current.VideoThumbFallback) {
+	case "":
+		Current.VideoThumbFallback = DefaultVideoThumbFallback
+	case "placeholder", "none", "fail":
+		Current.VideoThumbFallback = strings.ToLower(Current.VideoThumbFallback)
+	default:
+		warnf("invalid VIDEO_THUMB_FALLBACK %q (placeholder|none|fail), using %s", Current.VideoThumbFallback, DefaultVideoThumbFallback)
+		Current.VideoThumbFallback = DefaultVideoThumbFallback
+	}
+
+	if Current.DefaultCategory == "" {
+		Current.DefaultCategory = DefaultCategory
+	} else if !ValidCategories[Current.DefaultCategory] {
+		warnf("invalid DEFAULT_CATEGORY %q, using %s", Current.DefaultCategory, DefaultCategory)
+		Current.DefaultCategory = DefaultCategory
+	}
+	for cat, px := range Current.CategoryMaxPixels {
+		if !ValidCategories[cat] || px <= 0 {
+			warnf("invalid CATEGORY_MAX_PIXELS entry %s=%d, ignoring", cat, px)
+			delete(Current.CategoryMaxPixels, cat)
+		}
+	}
+
+	switch Current.AccessLogFormat {
+	case "common", "json":
+	case "":
+		Current.AccessLogFormat = "common"
+	default:
+		warnf("invalid ACCESS_LOG_FORMAT %q (common|json), using common", Current.AccessLogFormat)
+		Current.AccessLogFormat = "common"
+	}
+
+	if bp := strings.TrimRight(Current.BasePath, "/"); bp != "" {
+		if !strings.HasPrefix(bp, "/") {
+			bp = "/" + bp
+		}
+		if path.Clean(bp) != bp || strings.ContainsAny(bp, "?#% ") {
+			warnf("invalid BASE_PATH %q, serving at the root", Current.BasePath)
+			bp = ""
+		}
+		Current.BasePath = bp
+	} else {
+		Current.BasePath = ""
+	}
+
+	if Current.PublicBaseURL != "" {
+		u, err := url.Parse(Current.PublicBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			warnf("invalid PUBLIC_BASE_URL %q (http(s)://host[:port]), deriving it from requests", Current.PublicBaseURL)
+			Current.PublicBaseURL = ""
+		} else {
+			Current.PublicBaseURL = strings.TrimRight(Current.PublicBaseURL, "/")
+		}
+	}
+
+	hooks := Current.Webhooks[:0]
+	for i, h := range Current.Webhooks {
+		u, err := url.Parse(h)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			// The URL may carry a token, so only the index is logged.
+			warnf("invalid webhook URL #%d (must be http(s)://host/...), ignoring", i+1)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	Current.Webhooks = hooks
+
+	if Current.MQTTBroker != "" {
+		u, err := url.Parse(Current.MQTTBroker)
+		switch {
+		case err != nil || u.Host == "":
+			warnf("invalid MQTT_BROKER %q (tcp://host:port), disabling MQTT", Current.MQTTBroker)
+			Current.MQTTBroker = ""
+		case u.Scheme != "tcp" && u.Scheme != "ssl" && u.Scheme != "ws" && u.Scheme != "wss":
+			warnf("invalid MQTT_BROKER scheme %q (tcp, ssl, ws or wss), disabling MQTT", u.Scheme)
+			Current.MQTTBroker = ""
+		}
+		// Wildcards are only valid in subscriptions.
+		if Current.MQTTTopic == "" || strings.ContainsAny(Current.MQTTTopic, "+#\x00") {
+			warnf("invalid MQTT_TOPIC %q (no wildcards), using %q", Current.MQTTTopic, DefaultMQTTTopic)
+			Current.MQTTTopic = DefaultMQTTTopic
+		}
+	}
+
 	if Current.ProxyHost != "" {
 		switch Current.ProxyType {
 		case "http", "https", "socks5":
 		default:
-			log.Printf("Warning: invalid proxy type %q, using http", Current.ProxyType)
+			warnf("invalid proxy type %q, using http", Current.ProxyType)
 			Current.ProxyType = "http"
 		}
 	}
 
 	ip, cidr, err := parseTrustedProxyValue(Current.TrustedProxy)
 	if err != nil {
-		log.Printf("Warning: invalid TRUSTED_PROXY %q — ignoring (must be IP or CIDR)", Current.TrustedProxy)
+		warnf("invalid TRUSTED_PROXY %q — ignoring (must be IP or CIDR)", Current.TrustedProxy)
 		Current.TrustedProxy = ""
 		cachedProxyPtr.Store(&parsedProxy{})
 	} else {
@@ -295,3 +771,13 @@ func validate() {
 		Current.DisableAuth = true
 	}
 }
+
+// URLPath prefixes an absolute URL path with BasePath. Stored URLs are kept
+// prefix-free so the data stays valid if BasePath changes; responses go
+// through URLPath. Empty paths stay empty.
+func URLPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	return Current.BasePath + p
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -196,6 +199,35 @@ func TestValidateProxyConfig(t *testing.T) {
 	}
 }
 
+func TestValidatePublicBaseURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"https://walls.example.com", "https://walls.example.com"},
+		{"http://10.0.0.5:8080/", "http://10.0.0.5:8080"},
+		{"https://example.com/proxied/", "https://example.com/proxied"},
+		{"walls.example.com", ""},
+		{"ftp://walls.example.com", ""},
+		{"https://", ""},
+		{"https://walls.example.com/?x=1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			Current = Defaults()
+			Current.PublicBaseURL = tt.in
+			problems = nil
+			validate()
+			if Current.PublicBaseURL != tt.want {
+				t.Errorf("PublicBaseURL = %q, want %q", Current.PublicBaseURL, tt.want)
+			}
+			if warned := len(Problems()) > 0; warned != (tt.in != "" && tt.want == "") {
+				t.Errorf("problems = %v", Problems())
+			}
+		})
+	}
+}
+
 func TestAutoDisableAuth(t *testing.T) {
 	origUser := os.Getenv("ADMIN_USER")
 	origPass := os.Getenv("ADMIN_PASS")
@@ -243,3 +275,238 @@ func TestAutoDisableAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadLegacyKeys(t *testing.T) {
+	t.Chdir(t.TempDir())
+	legacy := `{
+		"port": "9090",
+		"rate": {"public_per_min": 30, "upload_per_min": 5, "burst": 4},
+		"proxy_host": "proxy.lan",
+		"proxy_port": "3128",
+		"proxy_type": "socks5",
+		"proxy_user": "bob",
+		"proxy_pass": "secret"
+	}`
+	if err := os.WriteFile("config.json", []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Load()
+
+	if Current.Port != "9090" {
+		t.Errorf("Port = %q, want 9090", Current.Port)
+	}
+	if want := (RateConfig{PublicPerMin: 30, UploadPerMin: 5, Burst: 4}); Current.Rate != want {
+		t.Errorf("Rate = %+v, want %+v", Current.Rate, want)
+	}
+	if Current.ProxyHost != "proxy.lan" || Current.ProxyPort != "3128" || Current.ProxyType != "socks5" {
+		t.Errorf("proxy = %s:%s (%s), want proxy.lan:3128 (socks5)", Current.ProxyHost, Current.ProxyPort, Current.ProxyType)
+	}
+	if Current.ProxyUsername != "bob" || Current.ProxyPassword != "secret" {
+		t.Errorf("proxy credentials = %q/%q, want bob/secret", Current.ProxyUsername, Current.ProxyPassword)
+	}
+}
+
+func TestLoadLegacyKeysNewKeyWins(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mixed := `{"rate": {"publicPerMin": 60, "public_per_min": 30}, "proxyHost": "new.lan", "proxy_host": "old.lan"}`
+	if err := os.WriteFile("config.json", []byte(mixed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Load()
+
+	if Current.Rate.PublicPerMin != 60 {
+		t.Errorf("Rate.PublicPerMin = %d, want 60", Current.Rate.PublicPerMin)
+	}
+	if Current.ProxyHost != "new.lan" {
+		t.Errorf("ProxyHost = %q, want new.lan", Current.ProxyHost)
+	}
+}
+
+func TestLoadRecordsProblems(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("PORT", "99999")
+	t.Setenv("MAX_UPLOAD_MB", "lots")
+	t.Setenv("PREVIEW_FORMAT", "gif")
+	Load()
+
+	if got := Problems(); len(got) != 3 {
+		t.Errorf("Problems() = %q, want 3 entries", got)
+	}
+	// Lenient mode still corrects the values.
+	if Current.Port != "8080" || Current.MaxUploadMB != DefaultMaxUploadMB {
+		t.Errorf("invalid settings not corrected: port %q, maxUploadMB %d", Current.Port, Current.MaxUploadMB)
+	}
+
+	os.Unsetenv("PORT")
+	os.Unsetenv("MAX_UPLOAD_MB")
+	os.Unsetenv("PREVIEW_FORMAT")
+	Load()
+	if got := Problems(); len(got) != 0 {
+		t.Errorf("Problems() after clean Load = %q, want none", got)
+	}
+}
+
+func TestEffectiveRedactsSecrets(t *testing.T) {
+	Current = Config{
+		Port:          "8080",
+		AdminUser:     "admin",
+		AdminPass:     "hunter2",
+		ProxyHost:     "proxy.lan",
+		ProxyPassword: "s3cret",
+		WebhookSecret: "wh-key",
+	}
+	eff := Effective()
+
+	body, err := json.Marshal(eff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "s3cret", "wh-key"} {
+		if strings.Contains(string(body), secret) {
+			t.Errorf("effective config leaks %q: %s", secret, body)
+		}
+	}
+	if eff["adminPass"] != redactedValue || eff["proxyPassword"] != redactedValue {
+		t.Errorf("secrets not marked redacted: adminPass=%v proxyPassword=%v", eff["adminPass"], eff["proxyPassword"])
+	}
+	if eff["adminUser"] != "admin" || eff["port"] != "8080" {
+		t.Errorf("non-secret fields altered: %v", eff)
+	}
+	// omitempty fields are still listed.
+	if _, ok := eff["disableAuth"]; !ok {
+		t.Error("zero-valued disableAuth missing from effective config")
+	}
+	if _, ok := eff["rate"].(map[string]any); !ok {
+		t.Errorf("nested rate config = %T, want map", eff["rate"])
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("WEBHOOKS", "https://ha.example/api/webhook/t0ken, ftp://bad.example ,http://b.example/hook")
+	Load()
+
+	want := []string{"https://ha.example/api/webhook/t0ken", "http://b.example/hook"}
+	if !slices.Equal(Current.Webhooks, want) {
+		t.Errorf("Webhooks = %q, want %q", Current.Webhooks, want)
+	}
+	if len(Problems()) != 1 || strings.Contains(Problems()[0], "bad.example") {
+		t.Errorf("problems = %q, want one that doesn't echo the URL", Problems())
+	}
+
+	body, err := json.Marshal(Effective())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "t0ken") || !strings.Contains(string(body), `"webhooks":["[REDACTED]","[REDACTED]"]`) {
+		t.Errorf("effective webhooks not redacted: %s", body)
+	}
+}
+
+// TestEnvOverridesConfigJSON pins the documented precedence: env vars are
+// applied after config.json is merged, so they win field by field while
+// JSON values for unset env vars are kept.
+func TestEnvOverridesConfigJSON(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := `{"port": "9000", "maxUploadMB": 20, "compression": {"quality": 70, "scale": 50}, "rate": {"burst": 3}}`
+	if err := os.WriteFile("config.json", []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("COMPRESSION_QUALITY", "95")
+	t.Setenv("PORT", "9100")
+
+	Load()
+
+	if Current.Compression.Quality != 95 {
+		t.Errorf("Compression.Quality = %d, want env value 95", Current.Compression.Quality)
+	}
+	if Current.Port != "9100" {
+		t.Errorf("Port = %q, want env value 9100", Current.Port)
+	}
+	if Current.Compression.Scale != 50 || Current.MaxUploadMB != 20 || Current.Rate.Burst != 3 {
+		t.Errorf("config.json values lost: scale=%d maxUploadMB=%d burst=%d",
+			Current.Compression.Scale, Current.MaxUploadMB, Current.Rate.Burst)
+	}
+	// Defaults survive for keys neither source sets.
+	if Current.Rate.PublicPerMin != DefaultPublicRatePerMin {
+		t.Errorf("Rate.PublicPerMin = %d, want default %d", Current.Rate.PublicPerMin, DefaultPublicRatePerMin)
+	}
+}
+
+// TestEnvPrecedenceAllFields checks every env-backed setting beats the same
+// setting in config.json, so the precedence is uniform across fields.
+func TestEnvPrecedenceAllFields(t *testing.T) {
+	tests := []struct {
+		env, value string
+		json       string
+		got        func() any
+		want       any
+	}{
+		{"PORT", "9100", `{"port": "9000"}`, func() any { return Current.Port }, "9100"},
+		{"MAX_UPLOAD_MB", "30", `{"maxUploadMB": 20}`, func() any { return Current.MaxUploadMB }, 30},
+		{"MAX_IMAGES", "7", `{"maxImages": 3}`, func() any { return Current.MaxImages }, 7},
+		{"MAX_CONCURRENT_UPLOADS", "4", `{"maxConcurrentUploads": 1}`, func() any { return Current.MaxConcurrentUploads }, 4},
+		{"MAX_WALK_DEPTH", "5", `{"maxWalkDepth": 2}`, func() any { return Current.MaxWalkDepth }, 5},
+		{"EXTERNAL_IMAGE_DIR", "/env/dir", `{"externalImageDir": "/json/dir"}`, func() any { return Current.ExternalImageDir }, "/env/dir"},
+		{"ADMIN_USER", "envuser", `{"adminUser": "jsonuser"}`, func() any { return Current.AdminUser }, "envuser"},
+		{"DISABLE_AUTH", "false", `{"disableAuth": true, "adminUser": "a", "adminPass": "b"}`, func() any { return Current.DisableAuth }, false},
+		{"PROXY_HOST", "env.proxy", `{"proxyHost": "json.proxy"}`, func() any { return Current.ProxyHost }, "env.proxy"},
+		{"PROXY_TYPE", "socks5", `{"proxyHost": "p", "proxyType": "https"}`, func() any { return Current.ProxyType }, "socks5"},
+		{"RATE_PUBLIC_PER_MIN", "90", `{"rate": {"publicPerMin": 60}}`, func() any { return Current.Rate.PublicPerMin }, 90},
+		{"RATE_BURST", "4", `{"rate": {"burst": 2}}`, func() any { return Current.Rate.Burst }, 4},
+		{"COMPRESSION_SCALE", "80", `{"compression": {"scale": 40}}`, func() any { return Current.Compression.Scale }, 80},
+		{"PREVIEW_FORMAT", "jpeg", `{"previewFormat": "webp"}`, func() any { return Current.PreviewFormat }, "jpeg"},
+		{"TRUSTED_PROXY", "10.0.0.1", `{"trustedProxy": "10.0.0.2"}`, func() any { return Current.TrustedProxy }, "10.0.0.1"},
+		{"DEFAULT_CATEGORY", "work", `{"defaultCategory": "tech"}`, func() any { return Current.DefaultCategory }, "work"},
+		{"CATEGORY_MAX_PIXELS", "mobile=2000000,bogus=5", `{"categoryMaxPixels": {"mobile": 500}}`, func() any { return len(Current.CategoryMaxPixels) * Current.CategoryMaxPixels["mobile"] }, 2000000},
+		{"PUBLIC_BASE_URL", "https://env.example/", `{"publicBaseURL": "https://json.example"}`, func() any { return Current.PublicBaseURL }, "https://env.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile("config.json", []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(tt.env, tt.value)
+			Load()
+			if got := tt.got(); got != tt.want {
+				t.Errorf("%s: got %v, want env value %v", tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDefault(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := WriteDefault("config.json", false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// omitempty fields must still be listed so the template is complete.
+	for _, key := range []string{`"disableAuth"`, `"basePath"`, `"videoThumbFallback"`, `"quality"`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("template missing %s", key)
+		}
+	}
+
+	Load()
+	if got := Problems(); len(got) != 0 {
+		t.Errorf("loading the template reported problems: %q", got)
+	}
+	if Current.MaxUploadMB != DefaultMaxUploadMB || Current.Compression.Quality != DefaultCompressionQuality {
+		t.Errorf("template did not load as defaults: %+v", Current)
+	}
+
+	if err := WriteDefault("config.json", false); err == nil {
+		t.Error("overwrote existing file without force")
+	}
+	if err := WriteDefault("config.json", true); err != nil {
+		t.Errorf("force: %v", err)
+	}
+}
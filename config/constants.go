@@ -7,12 +7,23 @@ const (
 	DefaultCompressionQuality = 85
 	GIFColors                 = 256
 	DefaultCompressionScale   = 100
+	DefaultPreviewFormat      = "webp"
+	DefaultJPEGChroma         = "420"
+	DefaultVideoThumbFallback = "placeholder"
+	DefaultMQTTTopic          = "lanpaper/events"
+	DefaultCategory           = "other" // for links created without one
 )
 
 const (
 	MinUploadMB                 = 1
 	DefaultMaxUploadMB          = 50
 	DefaultMaxConcurrentUploads = 2
+	DefaultMultipartMemoryMB    = 8    // per upload; the rest spills to a temp file
+	DefaultMaxMultipartParts    = 16   // the upload form has five fields
+	DefaultDecodeMemoryMB       = 1024 // fits one MaxImageDimension² RGBA decode
+	DefaultDecodeTimeout        = 60   // seconds one image decode may take
+	DefaultExifMaxEntries       = 512
+	DefaultExifMaxBytes         = 64 << 10 // one JPEG APP1 segment
 )
 
 const (
@@ -27,18 +38,22 @@ const (
 	DefaultPublicRatePerMin  = 120
 	DefaultUploadRatePerMin  = 20
 	DefaultRateBurst         = 10
-	RateLimitCleanerInterval = 120 // seconds
+	RateLimitCleanerInterval = 120    // seconds
+	IdempotencyTTL           = 600    // seconds an Idempotency-Key result is replayed
+	SessionMaxAge            = 604800 // seconds (7 days) a login session cookie stays valid
 )
 
 const (
-	DefaultMaxWalkDepth = 3
-	FileCopyBufferSize  = 1024 * 1024 // 1 MB
+	DefaultMaxWalkDepth         = 3
+	DefaultExternalWalkCacheTTL = 30          // seconds
+	FileCopyBufferSize          = 1024 * 1024 // 1 MB
 )
 
 // ValidCategories is the canonical set of user-assignable category names.
 // Add new categories here — handler validation picks them up automatically.
 var ValidCategories = map[string]bool{
 	"tech": true, "life": true, "work": true, "other": true,
+	"desktop": true, "mobile": true,
 }
 
 // AllowedMediaExts is the single source of truth for supported file extensions.
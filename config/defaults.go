@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// Defaults returns the configuration Load starts from before config.json
+// and environment variables are applied.
+func Defaults() Config {
+	return Config{
+		Port:                 "8080",
+		MaxUploadMB:          DefaultMaxUploadMB,
+		MaxImages:            0,
+		MaxConcurrentUploads: DefaultMaxConcurrentUploads,
+		MaxWalkDepth:         DefaultMaxWalkDepth,
+		ExternalWalkCacheTTL: DefaultExternalWalkCacheTTL,
+		ExternalImageDir:     "external/images",
+		AdminUser:            "",
+		AdminPass:            "",
+		DisableAuth:          false,
+		InsecureSkipVerify:   false,
+		ProxyHost:            "",
+		ProxyPort:            "",
+		ProxyType:            "http",
+		ProxyUsername:        "",
+		ProxyPassword:        "",
+		TrustedProxy:         "",
+		Rate: RateConfig{
+			PublicPerMin: DefaultPublicRatePerMin,
+			UploadPerMin: DefaultUploadRatePerMin,
+			Burst:        DefaultRateBurst,
+		},
+		Compression: CompressionConfig{
+			Quality: DefaultCompressionQuality,
+			Scale:   DefaultCompressionScale,
+		},
+		PreviewFormat:      DefaultPreviewFormat,
+		JPEGChroma:         DefaultJPEGChroma,
+		DecodeMemoryMB:     DefaultDecodeMemoryMB,
+		DecodeTimeout:      DefaultDecodeTimeout,
+		ExifMaxEntries:     DefaultExifMaxEntries,
+		ExifMaxBytes:       DefaultExifMaxBytes,
+		VideoThumbFallback: DefaultVideoThumbFallback,
+		DefaultCategory:    DefaultCategory,
+		MQTTTopic:          DefaultMQTTTopic,
+		AccessLogFormat:    "common",
+	}
+}
+
+// WriteDefault writes a config.json template to path containing every
+// setting at its default value, so operators can see which keys exist.
+// An existing file is only replaced when force is set.
+func WriteDefault(path string, force bool) error {
+	// Marshal via the Effective map so omitempty fields are included too.
+	data, err := json.MarshalIndent(effectiveStruct(reflect.ValueOf(Defaults())), "", "  ")
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	// 0600: the template has slots for adminPass and proxyPassword.
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+		}
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// redactedValue replaces secrets in Effective.
+const redactedValue = "[REDACTED]"
+
+// Effective returns the resolved configuration keyed by JSON name, for
+// logging and the admin /api/config/effective endpoint. Unlike marshalling
+// Current directly it includes zero values hidden by omitempty. Fields
+// tagged `redact:"true"` are replaced with a marker when set, so new secret
+// fields only need the tag to stay out of logs.
+func Effective() map[string]any {
+	return effectiveStruct(reflect.ValueOf(Current))
+}
+
+func effectiveStruct(v reflect.Value) map[string]any {
+	out := make(map[string]any, v.NumField())
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fv := v.Field(i)
+		switch {
+		case f.Tag.Get("redact") == "true" && fv.Kind() == reflect.Slice:
+			// Keep the count visible, hide the values.
+			list := make([]string, fv.Len())
+			for j := range list {
+				list[j] = redactedValue
+			}
+			out[name] = list
+		case f.Tag.Get("redact") == "true":
+			if !fv.IsZero() {
+				out[name] = redactedValue
+			} else {
+				out[name] = ""
+			}
+		case fv.Kind() == reflect.Struct:
+			out[name] = effectiveStruct(fv)
+		default:
+			out[name] = fv.Interface()
+		}
+	}
+	return out
+}
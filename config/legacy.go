@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Deprecated snake_case config.json keys from the pre-refactor config format,
+// mapped to their current camelCase names.
+var (
+	legacyTopLevelKeys = map[string]string{
+		"proxy_host":           "proxyHost",
+		"proxy_port":           "proxyPort",
+		"proxy_type":           "proxyType",
+		"proxy_username":       "proxyUsername",
+		"proxy_user":           "proxyUsername",
+		"proxy_password":       "proxyPassword",
+		"proxy_pass":           "proxyPassword",
+		"insecure_skip_verify": "insecureSkipVerify",
+	}
+	legacyRateKeys = map[string]string{
+		"public_per_min": "publicPerMin",
+		"upload_per_min": "uploadPerMin",
+	}
+)
+
+// migrateLegacyKeys rewrites deprecated keys in a config.json document to
+// their current names, logging a deprecation warning for each one found.
+// When both spellings are present the current key wins. Documents that are
+// not JSON objects are returned unchanged so the caller reports the error.
+func migrateLegacyKeys(data []byte) []byte {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data
+	}
+	changed := renameKeys(doc, legacyTopLevelKeys, "")
+
+	if raw, ok := doc["rate"]; ok {
+		var rate map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &rate); err == nil && renameKeys(rate, legacyRateKeys, "rate.") {
+			if b, err := json.Marshal(rate); err == nil {
+				doc["rate"] = b
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return data
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+func renameKeys(doc map[string]json.RawMessage, keys map[string]string, prefix string) bool {
+	changed := false
+	for oldKey, newKey := range keys {
+		v, ok := doc[oldKey]
+		if !ok {
+			continue
+		}
+		delete(doc, oldKey)
+		changed = true
+		if _, exists := doc[newKey]; exists {
+			log.Printf("Warning: config.json key %q is deprecated and ignored in favour of %q", prefix+oldKey, prefix+newKey)
+			continue
+		}
+		log.Printf("Warning: config.json key %q is deprecated, use %q", prefix+oldKey, prefix+newKey)
+		doc[newKey] = v
+	}
+	return changed
+}
@@ -4,6 +4,13 @@ go 1.25.3
 
 require (
 	github.com/chai2010/webp v1.4.0
+	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/joho/godotenv v1.5.1
 	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 )
+
+require (
+	github.com/gorilla/websocket v1.5.3 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+)
@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/json"
+	"errors"
+	"html/template"
+	"io/fs"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,6 +18,7 @@ import (
 	"time"
 
 	"lanpaper/config"
+	"lanpaper/middleware"
 	"lanpaper/storage"
 	"lanpaper/utils"
 )
@@ -21,8 +28,48 @@ const (
 	MaxPageSize     = 200
 )
 
+// adminPage is the data admin.html is rendered with.
+type adminPage struct {
+	Base      string // BasePath, prefixed to /static/ links and read by app.js
+	Nonce     string // the request's CSP nonce, for <script>/<style> tags
+	CSRFToken string // sent back by app.js in X-CSRF-Token
+}
+
+// Assets holds copies of admin.html and static/ built into the binary,
+// used when the files are missing from disk. main sets it; nil disables
+// the fallback.
+var Assets fs.FS
+
+// Admin serves the admin panel, rendering admin.html as an html/template
+// so its script tags carry the CSP nonce WithSecurity put in the request
+// context. The file is read on every request so edits show up without a
+// restart; without one on disk the embedded copy is used.
 func Admin(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "admin.html")
+	tmpl, err := template.ParseFiles("admin.html")
+	if errors.Is(err, fs.ErrNotExist) && Assets != nil {
+		tmpl, err = template.ParseFS(Assets, "admin.html")
+	}
+	if err != nil {
+		log.Printf("Error reading admin.html: %v", err)
+		http.Error(w, "Admin page unavailable", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, adminPage{
+		Base:      config.Current.BasePath,
+		Nonce:     middleware.NonceFromRequest(r),
+		CSRFToken: middleware.CSRFToken(r),
+	})
+	if err != nil {
+		log.Printf("Error rendering admin.html: %v", err)
+		http.Error(w, "Admin page unavailable", http.StatusInternalServerError)
+		return
+	}
+	// The nonce and CSRF token are per request; a cached copy would carry
+	// stale ones.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
 }
 
 type WallpaperResponse struct {
@@ -32,12 +79,23 @@ type WallpaperResponse struct {
 	HasImage  bool   `json:"hasImage"`
 	ImageURL  string `json:"imageUrl"`
 	Preview   string `json:"preview,omitempty"`
+	Poster    string `json:"poster,omitempty"`
+	MediumURL string `json:"mediumUrl,omitempty"` // ~800px rendition for detail views
 	MIMEType  string `json:"mimeType"`
 	SizeBytes int64  `json:"sizeBytes"`
 	ModTime   int64  `json:"modTime"`
 	CreatedAt int64  `json:"createdAt"`
 	Pinned    bool   `json:"pinned"`
 	PinnedAt  int64  `json:"pinnedAt,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	// DurationSeconds is a video's length; 0 for images and videos
+	// uploaded without ffprobe.
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	// Variants maps device class to the variant's image URL.
+	Variants map[string]string `json:"variants,omitempty"`
+	// Album lists the URLs of images appended after the main one.
+	Album []string `json:"album,omitempty"`
 }
 
 type PaginatedResponse struct {
@@ -50,8 +108,7 @@ type PaginatedResponse struct {
 
 // Wallpapers handles GET /api/wallpapers.
 func Wallpapers(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !allowMethod(w, r, http.MethodGet) {
 		return
 	}
 
@@ -77,6 +134,34 @@ func Wallpapers(w http.ResponseWriter, r *http.Request) {
 		}
 		wallpapers = out
 	}
+	if mime := strings.ToLower(q.Get("mime")); mime != "" {
+		out := wallpapers[:0]
+		for _, wp := range wallpapers {
+			if matchesMIME(wp, mime) {
+				out = append(out, wp)
+			}
+		}
+		wallpapers = out
+	}
+	minSize, err := sizeParam(q.Get("minSize"), 0)
+	if err != nil {
+		http.Error(w, "Invalid minSize", http.StatusBadRequest)
+		return
+	}
+	maxSize, err := sizeParam(q.Get("maxSize"), math.MaxInt64)
+	if err != nil {
+		http.Error(w, "Invalid maxSize", http.StatusBadRequest)
+		return
+	}
+	if minSize > 0 || maxSize < math.MaxInt64 {
+		out := wallpapers[:0]
+		for _, wp := range wallpapers {
+			if wp.SizeBytes >= minSize && wp.SizeBytes <= maxSize {
+				out = append(out, wp)
+			}
+		}
+		wallpapers = out
+	}
 	if sf := q.Get("sort"); sf != "" {
 		sortWallpapers(wallpapers, sf, q.Get("order") != "asc")
 	}
@@ -93,16 +178,16 @@ func Wallpapers(w http.ResponseWriter, r *http.Request) {
 		total := len(wallpapers)
 		totalPages := max(1, (total+pageSize-1)/pageSize)
 		start, end := pageWindow(page, pageSize, total)
-		if err := json.NewEncoder(w).Encode(PaginatedResponse{
+		if err := writeJSON(w, PaginatedResponse{
 			Data: toResponses(wallpapers[start:end]), Total: total,
 			Page: page, PageSize: pageSize, TotalPages: totalPages,
-		}); err != nil {
+		}, prettyJSON(r)); err != nil {
 			log.Printf("Error encoding paginated response: %v", err)
 		}
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(toResponses(wallpapers)); err != nil {
+	if err := writeJSON(w, toResponses(wallpapers), prettyJSON(r)); err != nil {
 		log.Printf("Error encoding wallpapers response: %v", err)
 	}
 }
@@ -137,54 +222,100 @@ func toResponses(wps []*storage.Wallpaper) []WallpaperResponse {
 	return out
 }
 
-// sortWallpapers sorts by the requested field while always keeping pinned
-// entries at the top, consistent with the default storage ordering.
+// sortWallpapers sorts by the requested field (created, updated, size or
+// name) while always keeping pinned entries at the top, consistent with the
+// default storage ordering. Unknown fields sort by created.
 func sortWallpapers(wps []*storage.Wallpaper, field string, desc bool) {
 	sort.SliceStable(wps, func(i, j int) bool {
+		a, b := wps[i], wps[j]
 		// Pinned entries always sort first regardless of the requested field.
-		if wps[i].IsPinned != wps[j].IsPinned {
-			return wps[i].IsPinned
-		}
-		var vi, vj int64
-		if field == "updated" {
-			vi, vj = wps[i].ModTime, wps[j].ModTime
-		} else {
-			vi, vj = wps[i].CreatedAt, wps[j].CreatedAt
+		if a.IsPinned != b.IsPinned {
+			return a.IsPinned
+		}
+		var c int
+		switch field {
+		case "updated":
+			c = cmp.Or(cmp.Compare(a.ModTime, b.ModTime), cmp.Compare(a.UploadSeq, b.UploadSeq))
+		case "size":
+			c = cmp.Compare(a.SizeBytes, b.SizeBytes)
+		case "name":
+			c = strings.Compare(strings.ToLower(a.LinkName), strings.ToLower(b.LinkName))
+		default:
+			c = cmp.Compare(a.CreatedAt, b.CreatedAt)
+		}
+		if c == 0 {
+			return a.ID < b.ID
 		}
 		if desc {
-			return vi > vj
+			return c > 0
 		}
-		return vi < vj
+		return c < 0
 	})
 }
 
+// sizeParam parses a byte-count query parameter, returning def when empty.
+func sizeParam(v string, def int64) (int64, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err == nil && n < 0 {
+		err = errors.New("negative size")
+	}
+	return n, err
+}
+
+// matchesMIME reports whether wp's stored type is mime: a file extension
+// such as "png" or "mp4", or "image"/"video" for the whole class.
+func matchesMIME(wp *storage.Wallpaper, mime string) bool {
+	if !wp.HasImage {
+		return false
+	}
+	switch mime {
+	case "video":
+		return isVideo(wp.MIMEType)
+	case "image":
+		return !isVideo(wp.MIMEType)
+	case "jpeg":
+		mime = "jpg"
+	}
+	return wp.MIMEType == mime
+}
+
 func inferCategory(wp *storage.Wallpaper) string {
 	if wp.Category != "" {
 		return wp.Category
 	}
-	if wp.MIMEType == "mp4" || wp.MIMEType == "webm" {
+	if isVideo(wp.MIMEType) {
 		return "video"
 	}
 	if wp.HasImage {
 		return "image"
 	}
-	return "other"
+	return defaultCategory()
 }
 
 func toResponse(wp *storage.Wallpaper) WallpaperResponse {
 	return WallpaperResponse{
-		ID:        wp.ID,
-		LinkName:  wp.LinkName,
-		Category:  inferCategory(wp),
-		HasImage:  wp.HasImage,
-		ImageURL:  wp.ImageURL,
-		Preview:   wp.Preview,
-		MIMEType:  wp.MIMEType,
-		SizeBytes: wp.SizeBytes,
-		ModTime:   wp.ModTime,
-		CreatedAt: wp.CreatedAt,
-		Pinned:    wp.IsPinned,
-		PinnedAt:  wp.PinnedAt,
+		ID:              wp.ID,
+		LinkName:        wp.LinkName,
+		Category:        inferCategory(wp),
+		HasImage:        wp.HasImage,
+		ImageURL:        config.URLPath(wp.ImageURL),
+		Preview:         config.URLPath(wp.Preview),
+		Poster:          config.URLPath(wp.Poster),
+		MediumURL:       mediumURL(wp),
+		MIMEType:        wp.MIMEType,
+		SizeBytes:       wp.SizeBytes,
+		ModTime:         wp.ModTime,
+		CreatedAt:       wp.CreatedAt,
+		Pinned:          wp.IsPinned,
+		PinnedAt:        wp.PinnedAt,
+		Width:           wp.Width,
+		Height:          wp.Height,
+		DurationSeconds: wp.DurationSeconds,
+		Variants:        variantURLs(wp),
+		Album:           albumURLs(wp),
 	}
 }
 
@@ -192,14 +323,20 @@ var validCategories = config.ValidCategories
 
 func isValidCategory(cat string) bool { return validCategories[cat] }
 
-// removeFiles deletes image and optional preview files, ignoring not-found errors.
-func removeFiles(imagePath, previewPath string) {
-	if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
-		log.Printf("Error removing image %s: %v", imagePath, err)
-	}
-	if previewPath != "" {
-		if err := os.Remove(previewPath); err != nil && !os.IsNotExist(err) {
-			log.Printf("Error removing preview %s: %v", previewPath, err)
+// defaultCategory is the category of links created or reset without one.
+func defaultCategory() string {
+	return cmp.Or(config.Current.DefaultCategory, config.DefaultCategory)
+}
+
+// removeFiles deletes an entry's files (image, preview, poster), skipping
+// empty paths and ignoring not-found errors.
+func removeFiles(paths ...string) {
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing %s: %v", p, err)
 		}
 	}
 }
@@ -229,7 +366,7 @@ func Link(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
-		if !isValidLinkName(req.LinkName) {
+		if !isValidNewLinkName(req.LinkName) {
 			http.Error(w, "Invalid link name", http.StatusBadRequest)
 			return
 		}
@@ -241,10 +378,7 @@ func Link(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Link exists", http.StatusConflict)
 			return
 		}
-		cat := req.Category
-		if cat == "" {
-			cat = "other"
-		}
+		cat := cmp.Or(req.Category, defaultCategory())
 		newWp := &storage.Wallpaper{
 			ID:        req.LinkName,
 			LinkName:  req.LinkName,
@@ -256,9 +390,10 @@ func Link(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error saving after link creation: %v", err)
 		}
 		log.Printf("Created link: %s (category: %s)", req.LinkName, cat)
+		notify(eventCreate, req.LinkName)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		if err := json.NewEncoder(w).Encode(toResponse(newWp)); err != nil {
+		if err := writeJSON(w, toResponse(newWp), prettyJSON(r)); err != nil {
 			log.Printf("Error encoding link creation response: %v", err)
 		}
 
@@ -281,7 +416,7 @@ func Link(w http.ResponseWriter, r *http.Request) {
 		// --- Rename ---
 		if req.NewLinkName != nil {
 			newName := *req.NewLinkName
-			if !isValidLinkName(newName) {
+			if !isValidNewLinkName(newName) {
 				http.Error(w, "Invalid new link name", http.StatusBadRequest)
 				return
 			}
@@ -292,7 +427,7 @@ func Link(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 				w.Header().Set("Content-Type", "application/json")
-				_ = json.NewEncoder(w).Encode(toResponse(wp))
+				_ = writeJSON(w, toResponse(wp), prettyJSON(r))
 				return
 			}
 			if _, exists := storage.Global.Get(newName); exists {
@@ -305,7 +440,9 @@ func Link(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Link not found", http.StatusNotFound)
 				return
 			}
-			if wpOld.HasImage && wpOld.MIMEType != "" {
+			// Hashed files aren't named after the link, so only
+			// link-named files need to move.
+			if wpOld.HasImage && wpOld.MIMEType != "" && wpOld.Hash == "" {
 				oldImg := filepath.Join("static", "images", linkName+"."+wpOld.MIMEType)
 				newImg := filepath.Join("static", "images", newName+"."+wpOld.MIMEType)
 				if err := os.Rename(oldImg, newImg); err != nil && !os.IsNotExist(err) {
@@ -313,13 +450,41 @@ func Link(w http.ResponseWriter, r *http.Request) {
 					http.Error(w, "Failed to rename image file", http.StatusInternalServerError)
 					return
 				}
-				if wpOld.MIMEType != "mp4" && wpOld.MIMEType != "webm" {
-					oldPrev := filepath.Join("static", "images", "previews", linkName+".webp")
-					newPrev := filepath.Join("static", "images", "previews", newName+".webp")
+				if wpOld.PreviewPath != "" {
+					oldPrev := wpOld.PreviewPath
+					// Keep the extension and any content key, swap the link name.
+					newPrev := filepath.Join(filepath.Dir(oldPrev), newName+strings.TrimPrefix(filepath.Base(oldPrev), linkName))
 					if err := os.Rename(oldPrev, newPrev); err != nil && !os.IsNotExist(err) {
 						log.Printf("Warning: could not rename preview %s -> %s: %v", oldPrev, newPrev, err)
 					}
 				}
+				if wpOld.PosterPath != "" {
+					newPoster, _ := posterPathFor(newName)
+					if err := os.Rename(wpOld.PosterPath, newPoster); err != nil && !os.IsNotExist(err) {
+						log.Printf("Warning: could not rename poster %s -> %s: %v", wpOld.PosterPath, newPoster, err)
+					}
+				}
+			}
+			for name, v := range wpOld.Variants {
+				newBase := variantFileBase(newName, name) + "." + v.MIMEType
+				newPath := filepath.Join("static", "images", newBase)
+				if err := os.Rename(v.ImagePath, newPath); err != nil && !os.IsNotExist(err) {
+					log.Printf("Warning: could not rename %s variant %s -> %s: %v", name, v.ImagePath, newPath, err)
+					continue
+				}
+				v.ImagePath = newPath
+				v.ImageURL = "/static/images/" + newBase
+			}
+			for _, v := range wpOld.Album {
+				// Keep the "@n.ext" suffix, swap the link name.
+				newBase := newName + strings.TrimPrefix(filepath.Base(v.ImagePath), linkName)
+				newPath := filepath.Join("static", "images", newBase)
+				if err := os.Rename(v.ImagePath, newPath); err != nil && !os.IsNotExist(err) {
+					log.Printf("Warning: could not rename album image %s -> %s: %v", v.ImagePath, newPath, err)
+					continue
+				}
+				v.ImagePath = newPath
+				v.ImageURL = "/static/images/" + newBase
 			}
 
 			wp, ok := storage.Global.Rename(linkName, newName)
@@ -327,25 +492,31 @@ func Link(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Rename failed", http.StatusInternalServerError)
 				return
 			}
+			// Resized copies live under the old name and would be orphaned.
+			removeResized(linkName)
 
 			// Update URLs and runtime paths to reflect the new name.
 			// All URLs must start with a leading slash for correct browser resolution.
-			if wp.HasImage && wp.MIMEType != "" {
+			if wp.HasImage && wp.MIMEType != "" && wp.Hash == "" {
 				wp.ImageURL = "/static/images/" + newName + "." + wp.MIMEType
 				wp.ImagePath = filepath.Join("static", "images", newName+"."+wp.MIMEType)
-				if wp.MIMEType != "mp4" && wp.MIMEType != "webm" {
-					wp.Preview = "/static/images/previews/" + newName + ".webp"
-					wp.PreviewPath = filepath.Join("static", "images", "previews", newName+".webp")
+				if wp.PreviewPath != "" {
+					prevName := newName + strings.TrimPrefix(filepath.Base(wp.PreviewPath), linkName)
+					wp.Preview = "/static/images/previews/" + prevName
+					wp.PreviewPath = filepath.Join("static", "images", "previews", prevName)
+				}
+				if wp.PosterPath != "" {
+					wp.PosterPath, wp.Poster = posterPathFor(newName)
 				}
-				storage.Global.Set(newName, wp)
 			}
+			storage.Global.Set(newName, wp)
 
 			if err := storage.Global.Save(); err != nil {
 				log.Printf("Error saving after rename: %v", err)
 			}
 			log.Printf("Renamed link: %s -> %s", linkName, newName)
 			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(toResponse(wp))
+			_ = writeJSON(w, toResponse(wp), prettyJSON(r))
 			return
 		}
 
@@ -358,7 +529,7 @@ func Link(w http.ResponseWriter, r *http.Request) {
 		if req.Category != nil {
 			switch {
 			case *req.Category == "":
-				wp.Category = "other"
+				wp.Category = defaultCategory()
 			case !isValidCategory(*req.Category):
 				http.Error(w, "Invalid category", http.StatusBadRequest)
 				return
@@ -372,7 +543,7 @@ func Link(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Printf("Patched link: %s (category: %s)", linkName, wp.Category)
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(toResponse(wp)); err != nil {
+		if err := writeJSON(w, toResponse(wp), prettyJSON(r)); err != nil {
 			log.Printf("Error encoding patch response: %v", err)
 		}
 
@@ -388,23 +559,29 @@ func Link(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if wp.HasImage {
-			removeFiles(wp.ImagePath, wp.PreviewPath)
+			removeUnshared(linkName, wp.ImagePath, wp.PreviewPath, wp.PosterPath)
+			removeResized(linkName)
+		}
+		if paths := append(variantPaths(wp), albumPaths(wp)...); len(paths) > 0 {
+			removeFiles(paths...)
 		}
 		storage.Global.Delete(linkName)
 		if err := storage.Global.Save(); err != nil {
 			log.Printf("Error saving after link deletion: %v", err)
 		}
+		notify(eventDelete, linkName)
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		// Answers OPTIONS with 204, anything else with 405.
+		allowMethod(w, r, http.MethodPost, http.MethodPatch, http.MethodDelete)
 	}
 }
 
 // TogglePin handles POST /api/link/{name}/pin to toggle pin status.
 func TogglePin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodPost)
 		return
 	}
 
@@ -442,32 +619,171 @@ func TogglePin(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Link %s: %s", linkName, action)
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(toResponse(wp)); err != nil {
+	if err := writeJSON(w, toResponse(wp), prettyJSON(r)); err != nil {
 		log.Printf("Error encoding pin toggle response: %v", err)
 	}
 }
 
+// Touch handles POST /api/link/{name}/touch: bumps the link's ModTime (and
+// the file's mtime) to now so it moves to the top of the default listing
+// without a re-upload.
+func Touch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/link/")
+	path = strings.TrimSuffix(path, "/touch")
+	linkName := strings.Trim(path, "/")
+
+	if linkName == "" || !isValidLinkName(linkName) {
+		http.Error(w, "Invalid link name", http.StatusBadRequest)
+		return
+	}
+
+	old, exists := storage.Global.Get(linkName)
+	if !exists || !old.HasImage {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(old.ImagePath, now, now); err != nil {
+		log.Printf("Warning: failed to touch %s: %v", old.ImagePath, err)
+	}
+	wp := old.Clone()
+	wp.ModTime, wp.UploadSeq = now.Unix(), nextUploadSeq()
+
+	storage.Global.Set(linkName, wp)
+	if err := storage.Global.Save(); err != nil {
+		log.Printf("Error saving after touch: %v", err)
+	}
+	log.Printf("Link %s: touched", linkName)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, toResponse(wp), prettyJSON(r)); err != nil {
+		log.Printf("Error encoding touch response: %v", err)
+	}
+}
+
+// ReloadResult is the JSON response for /api/reload.
+type ReloadResult struct {
+	Count int `json:"count"`
+}
+
+// Reload handles POST /api/reload: re-reads data/wallpapers.json so external
+// edits or a restored backup take effect without a restart. If the file can't
+// be parsed (e.g. an editor is still writing it) the in-memory store is left
+// untouched and 503 is returned so the caller can retry.
+func Reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if err := storage.Global.Load(); err != nil {
+		log.Printf("Error reloading wallpapers: %v", err)
+		http.Error(w, "Failed to reload wallpapers; data file unreadable or incomplete", http.StatusServiceUnavailable)
+		return
+	}
+
+	count := len(storage.Global.GetAll())
+	log.Printf("Reloaded %d wallpapers from disk", count)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, ReloadResult{Count: count}, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding reload response: %v", err)
+	}
+}
+
+// ExternalImagesPage is the paginated response of /api/external-images.
+type ExternalImagesPage struct {
+	Data       []string `json:"data"`
+	Total      int      `json:"total"`
+	Page       int      `json:"page"`
+	PageSize   int      `json:"pageSize"`
+	TotalPages int      `json:"totalPages"`
+}
+
+// ExternalImages handles GET /api/external-images. Without ?page it returns
+// every path as a plain array; with ?page/?page_size it returns one page in
+// the same shape as paginated /api/wallpapers.
 func ExternalImages(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if config.Current.DisableExternalImages {
+		http.NotFound(w, r)
 		return
 	}
 
-	root := utils.ExternalBaseDir()
-	absRoot, _, err := utils.ValidateAndResolvePath(root, ".")
+	q := r.URL.Query()
+	files, err := cachedExternalImages(q.Get("refresh") == "1")
 	if err != nil {
 		jsonEmpty(w)
 		return
 	}
+
+	if pageStr := q.Get("page"); pageStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			http.Error(w, "Invalid page number", http.StatusBadRequest)
+			return
+		}
+		pageSize := clampPageSize(q.Get("page_size"))
+		total := len(files)
+		start, end := pageWindow(page, pageSize, total)
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeJSON(w, ExternalImagesPage{
+			Data: files[start:end], Total: total,
+			Page: page, PageSize: pageSize, TotalPages: max(1, (total+pageSize-1)/pageSize),
+		}, prettyJSON(r)); err != nil {
+			log.Printf("Error encoding external images page: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, files, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding external images response: %v", err)
+	}
+}
+
+// listExternalImages walks the external image directory and returns the
+// slash-separated relative paths of supported media files, sorted so that
+// pagination is stable across requests.
+func listExternalImages() ([]string, error) {
+	files := []string{}
+	err := walkExternalImages(func(rel string) error {
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// walkExternalImages calls fn with the slash-separated relative path of each
+// supported media file under the external image directory, in walk order.
+// Hidden directories, directories deeper than MaxWalkDepth and symlinks
+// leading outside the root are skipped. An error from fn stops the walk and
+// is returned.
+func walkExternalImages(fn func(rel string) error) error {
+	root := utils.ExternalBaseDir()
+	absRoot, _, err := utils.ValidateAndResolvePath(root, ".")
+	if err != nil {
+		return err
+	}
 	realRoot, err := filepath.EvalSymlinks(absRoot)
 	if err != nil {
-		jsonEmpty(w)
-		return
+		return err
 	}
 
 	maxDepth := config.Current.MaxWalkDepth
-	var files []string
-	_ = filepath.WalkDir(absRoot, func(path string, d os.DirEntry, err error) error {
+	return filepath.WalkDir(absRoot, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
@@ -492,22 +808,21 @@ func ExternalImages(w http.ResponseWriter, r *http.Request) {
 		}
 		if config.AllowedMediaExts[strings.ToLower(filepath.Ext(d.Name()))] {
 			if relPath, relErr := filepath.Rel(absRoot, path); relErr == nil {
-				files = append(files, filepath.ToSlash(relPath))
+				return fn(filepath.ToSlash(relPath))
 			}
 		}
 		return nil
 	})
-
-	if files == nil {
-		files = []string{}
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(files); err != nil {
-		log.Printf("Error encoding external images response: %v", err)
-	}
 }
 
 func ExternalImagePreview(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	if config.Current.DisableExternalImages {
+		http.NotFound(w, r)
+		return
+	}
 	pathParam := r.URL.Query().Get("path")
 	if pathParam == "" {
 		http.NotFound(w, r)
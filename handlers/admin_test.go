@@ -0,0 +1,482 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+	"lanpaper/middleware"
+	"lanpaper/storage"
+)
+
+func TestExternalImagesPagination(t *testing.T) {
+	dir := t.TempDir()
+	var want []string
+	for i := range 120 {
+		sub := fmt.Sprintf("dir%d", i%3)
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("img%03d.jpg", i)
+		if err := os.WriteFile(filepath.Join(dir, sub, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, sub+"/"+name)
+	}
+	// Unsupported files are not listed.
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(want)
+	config.Current = config.Config{ExternalImageDir: dir, MaxWalkDepth: 3}
+
+	var got []string
+	for page := 1; ; page++ {
+		rec := httptest.NewRecorder()
+		ExternalImages(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/external-images?page=%d&page_size=50", page), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("page %d: status %d", page, rec.Code)
+		}
+		var resp ExternalImagesPage
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("page %d: decode: %v", page, err)
+		}
+		if resp.Total != 120 || resp.TotalPages != 3 || resp.PageSize != 50 {
+			t.Fatalf("page %d: total=%d totalPages=%d pageSize=%d, want 120/3/50", page, resp.Total, resp.TotalPages, resp.PageSize)
+		}
+		if len(resp.Data) == 0 {
+			break
+		}
+		got = append(got, resp.Data...)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("paged through %d files, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("file %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	ExternalImages(rec, httptest.NewRequest(http.MethodGet, "/api/external-images?page=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("page=0: status %d, want 400", rec.Code)
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Global.Delete("a")
+		storage.Global.Delete("b")
+	})
+
+	write := func(body string) {
+		if err := os.WriteFile(filepath.Join("data", "wallpapers.json"), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reload := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		Reload(rec, httptest.NewRequest(http.MethodPost, "/api/reload", nil))
+		return rec
+	}
+
+	write(`{"a":{"linkName":"a"},"b":{"linkName":"b"}}`)
+	rec := reload()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var res ReloadResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 2 {
+		t.Errorf("count = %d, want 2", res.Count)
+	}
+
+	// A half-written file must not wipe the loaded state.
+	write(`{"a":{"linkName":"a"},"b":{"link`)
+	if rec := reload(); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("truncated file: status = %d, want 503", rec.Code)
+	}
+	if _, ok := storage.Global.Get("b"); !ok {
+		t.Error("store lost entries after failed reload")
+	}
+}
+
+func TestTouchSortsFirst(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	imgPath := filepath.Join(t.TempDir(), "old.jpg")
+	if err := os.WriteFile(imgPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage.Global.Set("touch-old", &storage.Wallpaper{
+		ID: "touch-old", LinkName: "touch-old", HasImage: true, MIMEType: "jpg",
+		ImagePath: imgPath, ModTime: 100,
+	})
+	storage.Global.Set("touch-new", &storage.Wallpaper{
+		ID: "touch-new", LinkName: "touch-new", HasImage: true, MIMEType: "jpg", ModTime: 200,
+	})
+	storage.Global.Set("touch-empty", &storage.Wallpaper{ID: "touch-empty", LinkName: "touch-empty"})
+	t.Cleanup(func() {
+		for _, id := range []string{"touch-old", "touch-new", "touch-empty"} {
+			storage.Global.Delete(id)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	Touch(rec, httptest.NewRequest(http.MethodPost, "/api/link/touch-old/touch", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if all := storage.Global.GetAll(); len(all) == 0 || all[0].ID != "touch-old" {
+		t.Errorf("touched link does not sort first")
+	}
+	if fi, err := os.Stat(imgPath); err != nil || time.Since(fi.ModTime()) > time.Minute {
+		t.Errorf("file mtime not bumped: %v", err)
+	}
+
+	for _, name := range []string{"touch-empty", "missing"} {
+		rec := httptest.NewRecorder()
+		Touch(rec, httptest.NewRequest(http.MethodPost, "/api/link/"+name+"/touch", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", name, rec.Code)
+		}
+	}
+}
+
+func TestBasePathURLs(t *testing.T) {
+	config.Current = config.Config{BasePath: "/wallpaper"}
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	wp := &storage.Wallpaper{
+		ID: "bp", LinkName: "bp", HasImage: true, MIMEType: "jpg",
+		ImageURL: "/static/images/bp.jpg", Preview: "/static/images/previews/bp.webp",
+	}
+	resp := toResponse(wp)
+	if resp.ImageURL != "/wallpaper/static/images/bp.jpg" || resp.Preview != "/wallpaper/static/images/previews/bp.webp" {
+		t.Errorf("response URLs not prefixed: %q, %q", resp.ImageURL, resp.Preview)
+	}
+	if resp.Poster != "" {
+		t.Errorf("empty poster became %q", resp.Poster)
+	}
+	if wp.ImageURL != "/static/images/bp.jpg" {
+		t.Errorf("stored URL modified: %q", wp.ImageURL)
+	}
+
+	rec := httptest.NewRecorder()
+	Public(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if loc := rec.Header().Get("Location"); loc != "/wallpaper/admin" {
+		t.Errorf("root redirect = %q, want /wallpaper/admin", loc)
+	}
+}
+
+func TestStoredPreview(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("p.webp", []byte("RIFF0000WEBP"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, wp := range []*storage.Wallpaper{
+		{ID: "sp-img", LinkName: "sp-img", HasImage: true, MIMEType: "png", PreviewPath: "p.webp"},
+		{ID: "sp-vid", LinkName: "sp-vid", HasImage: true, MIMEType: "mp4", PreviewPath: "p.webp"},
+		{ID: "sp-empty", LinkName: "sp-empty"},
+	} {
+		storage.Global.Set(wp.ID, wp)
+		t.Cleanup(func() { storage.Global.Delete(wp.ID) })
+	}
+
+	tests := []struct {
+		link string
+		want int
+	}{
+		{"sp-img", http.StatusOK},
+		{"sp-vid", http.StatusNotFound},
+		{"sp-empty", http.StatusNotFound},
+		{"missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		StoredPreview(rec, httptest.NewRequest(http.MethodGet, "/api/preview/"+tt.link, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.link, rec.Code, tt.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	StoredPreview(rec, httptest.NewRequest(http.MethodGet, "/api/preview/sp-img", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "image/webp" {
+		t.Errorf("Content-Type = %q, want image/webp", ct)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc == "" {
+		t.Error("missing Cache-Control")
+	}
+}
+
+func TestSortWallpapers(t *testing.T) {
+	tests := []struct {
+		field string
+		desc  bool
+		want  string
+	}{
+		{"size", true, "pin,big,mid,small"},
+		{"size", false, "pin,small,mid,big"},
+		{"name", false, "pin,big,mid,small"},
+		{"name", true, "pin,small,mid,big"},
+		{"created", true, "pin,mid,small,big"},
+		{"updated", false, "pin,mid,big,small"},
+	}
+	for _, tt := range tests {
+		wps := []*storage.Wallpaper{
+			{ID: "small", LinkName: "Small", SizeBytes: 10, CreatedAt: 2, ModTime: 9},
+			{ID: "pin", LinkName: "pin", SizeBytes: 1, IsPinned: true},
+			{ID: "big", LinkName: "big", SizeBytes: 1000, CreatedAt: 1, ModTime: 5},
+			{ID: "mid", LinkName: "Mid", SizeBytes: 100, CreatedAt: 3, ModTime: 1},
+		}
+		sortWallpapers(wps, tt.field, tt.desc)
+		var got []string
+		for _, wp := range wps {
+			got = append(got, wp.ID)
+		}
+		if s := strings.Join(got, ","); s != tt.want {
+			t.Errorf("sort=%s desc=%v: got %s, want %s", tt.field, tt.desc, s, tt.want)
+		}
+	}
+}
+
+func TestExternalImagesCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.Current = config.Config{ExternalImageDir: dir, MaxWalkDepth: 3, ExternalWalkCacheTTL: 60}
+
+	list := func(query string) []string {
+		rec := httptest.NewRecorder()
+		ExternalImages(rec, httptest.NewRequest(http.MethodGet, "/api/external-images"+query, nil))
+		var files []string
+		if err := json.NewDecoder(rec.Body).Decode(&files); err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	if got := list("?refresh=1"); len(got) != 1 {
+		t.Fatalf("first listing = %v, want [a.jpg]", got)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(""); len(got) != 1 {
+		t.Errorf("listing within the TTL = %v, want the cached [a.jpg]", got)
+	}
+	if got := list("?refresh=1"); len(got) != 2 {
+		t.Errorf("refreshed listing = %v, want both files", got)
+	}
+
+	config.Current.ExternalWalkCacheTTL = 0
+	if err := os.WriteFile(filepath.Join(dir, "c.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(""); len(got) != 3 {
+		t.Errorf("uncached listing = %v, want all three files", got)
+	}
+}
+
+func TestExternalImagesDisabled(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.Current = config.Config{ExternalImageDir: dir, MaxWalkDepth: 3, MaxUploadMB: 10, DisableExternalImages: true}
+	t.Cleanup(func() { config.Current = config.Config{} })
+	InitUploadSemaphore(1)
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"list", ExternalImages, "/api/external-images"},
+		{"stream", ExternalImagesStream, "/api/external-images/stream"},
+		{"preview", ExternalImagePreview, "/api/external-image-preview?path=a.jpg"},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", tt.name, rec.Code)
+		}
+	}
+
+	storage.Global.Set("ext", &storage.Wallpaper{ID: "ext", LinkName: "ext"})
+	t.Cleanup(func() { storage.Global.Delete("ext") })
+	rec := httptest.NewRecorder()
+	Upload(rec, multipartRequest([]string{"linkName", "ext", "url", "a.jpg"}, nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("local-path upload: status %d, want 403", rec.Code)
+	}
+}
+
+func TestDefaultCategory(t *testing.T) {
+	setupUploadDir(t)
+	config.Current.DefaultCategory = "work"
+	config.Current.InferCategory = true
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"dc-new", "dc-chosen"} {
+		rec := httptest.NewRecorder()
+		Link(rec, httptest.NewRequest(http.MethodPost, "/api/link", strings.NewReader(`{"linkName":"`+name+`"}`)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: status %d", name, rec.Code)
+		}
+		t.Cleanup(func() { storage.Global.Delete(name) })
+		if wp, _ := storage.Global.Get(name); wp.Category != "work" {
+			t.Errorf("%s created with category %q, want the default", name, wp.Category)
+		}
+	}
+	wp, _ := storage.Global.Get("dc-chosen")
+	wp.Category = "tech"
+	storage.Global.Set("dc-chosen", wp)
+
+	for name, want := range map[string]string{"dc-new": "image", "dc-chosen": "tech"} {
+		if rec := uploadFile(name, "a.png", img.Bytes()); rec.Code != http.StatusOK {
+			t.Fatalf("upload %s: status %d %s", name, rec.Code, rec.Body)
+		}
+		if wp, _ := storage.Global.Get(name); wp.Category != want {
+			t.Errorf("%s after upload: category %q, want %q", name, wp.Category, want)
+		}
+	}
+}
+
+func TestNewReservedLinkName(t *testing.T) {
+	setupUploadDir(t)
+	link := func(method, target, body string) int {
+		rec := httptest.NewRecorder()
+		Link(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := link(http.MethodPost, "/api/link", `{"linkName":"Gallery"}`); code != http.StatusBadRequest {
+		t.Errorf("create gallery: status %d, want 400", code)
+	}
+	storage.Global.Set("nr-other", &storage.Wallpaper{ID: "nr-other", LinkName: "nr-other"})
+	t.Cleanup(func() { storage.Global.Delete("nr-other") })
+	if code := link(http.MethodPatch, "/api/link/nr-other", `{"newLinkName":"gallery"}`); code != http.StatusBadRequest {
+		t.Errorf("rename to gallery: status %d, want 400", code)
+	}
+
+	// A link from before the name was reserved can still be managed.
+	storage.Global.Set("gallery", &storage.Wallpaper{ID: "gallery", LinkName: "gallery"})
+	t.Cleanup(func() { storage.Global.Delete("gallery") })
+	if code := link(http.MethodPatch, "/api/link/gallery", `{"category":"tech"}`); code != http.StatusOK {
+		t.Errorf("update gallery: status %d, want 200", code)
+	}
+	if code := link(http.MethodDelete, "/api/link/gallery", ""); code != http.StatusOK && code != http.StatusNoContent {
+		t.Errorf("delete gallery: status %d", code)
+	}
+	if _, ok := storage.Global.Get("gallery"); ok {
+		t.Error("gallery link not deleted")
+	}
+}
+
+func TestWallpapersFilters(t *testing.T) {
+	for _, wp := range []*storage.Wallpaper{
+		{ID: "f-bigvid", LinkName: "f-bigvid", HasImage: true, MIMEType: "mp4", SizeBytes: 60 << 20},
+		{ID: "f-smallvid", LinkName: "f-smallvid", HasImage: true, MIMEType: "webm", SizeBytes: 1 << 20},
+		{ID: "f-png", LinkName: "f-png", HasImage: true, MIMEType: "png", SizeBytes: 70 << 20},
+		{ID: "f-empty", LinkName: "f-empty"},
+	} {
+		storage.Global.Set(wp.ID, wp)
+		t.Cleanup(func() { storage.Global.Delete(wp.ID) })
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"mime=video&minSize=52428800", "f-bigvid"},
+		{"mime=mp4", "f-bigvid"},
+		{"mime=image", "f-png"},
+		{"maxSize=2097152&has_image=true", "f-smallvid"},
+		{"minSize=1048576&maxSize=1048576", "f-smallvid"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		Wallpapers(rec, httptest.NewRequest(http.MethodGet, "/api/wallpapers?"+tt.query, nil))
+		var resp []WallpaperResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var got []string
+		for _, wp := range resp {
+			if strings.HasPrefix(wp.ID, "f-") {
+				got = append(got, wp.ID)
+			}
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	for _, q := range []string{"minSize=-1", "maxSize=lots"} {
+		rec := httptest.NewRecorder()
+		Wallpapers(rec, httptest.NewRequest(http.MethodGet, "/api/wallpapers?"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", q, rec.Code)
+		}
+	}
+}
+
+func TestAdminPage(t *testing.T) {
+	t.Chdir("..") // admin.html lives at the module root
+	config.Current = config.Config{BasePath: "/walls"}
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	rec := httptest.NewRecorder()
+	middleware.WithSecurity(Admin)(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	m := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+	if m == nil {
+		t.Fatalf("no nonce in CSP %q", rec.Header().Get("Content-Security-Policy"))
+	}
+	body := html.UnescapeString(rec.Body.String())
+	for _, want := range []string{
+		`<script nonce="` + m[1] + `" src="/walls/static/js/app.js"`,
+		`<link rel="stylesheet" href="/walls/static/css/style.css">`,
+		`<meta name="lanpaper-base" content="/walls">`,
+		`<meta name="lanpaper-csrf" content="`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %s", want)
+		}
+	}
+	if strings.Contains(body, "{{") {
+		t.Error("page has unrendered template actions")
+	}
+}
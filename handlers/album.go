@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+// albumRotateSeconds is how long Public keeps serving one album image
+// before moving to the next. It matches the public Cache-Control max-age,
+// so a cached response never outlives its turn by much.
+const albumRotateSeconds = 60
+
+// albumFileBase returns the file name stem of album image n (1-based; the
+// main image is the album's 0th). Like variants it uses '@', which link
+// names can't contain, so "desk@2" never collides with a link "desk-2".
+func albumFileBase(linkName string, n int) string {
+	return fmt.Sprintf("%s@%d", linkName, n)
+}
+
+// albumPaths returns the on-disk paths of wp's appended album images.
+func albumPaths(wp *storage.Wallpaper) []string {
+	var paths []string
+	for _, v := range wp.Album {
+		if v != nil && v.ImagePath != "" {
+			paths = append(paths, v.ImagePath)
+		}
+	}
+	return paths
+}
+
+// albumURLs returns the public URLs of wp's appended album images under
+// BasePath, in album order.
+func albumURLs(wp *storage.Wallpaper) []string {
+	if len(wp.Album) == 0 {
+		return nil
+	}
+	out := make([]string, len(wp.Album))
+	for i, v := range wp.Album {
+		out[i] = config.URLPath(v.ImageURL)
+	}
+	return out
+}
+
+// albumIndex picks the image Public serves for wp: ?i=N selects one (0 is
+// the main image), otherwise albums rotate every albumRotateSeconds. ok is
+// false when ?i is malformed or out of range.
+func albumIndex(r *http.Request, wp *storage.Wallpaper) (i int, ok bool) {
+	if s := r.URL.Query().Get("i"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > len(wp.Album) {
+			return 0, false
+		}
+		return n, true
+	}
+	if len(wp.Album) == 0 {
+		return 0, true
+	}
+	return int(time.Now().Unix()/albumRotateSeconds) % (len(wp.Album) + 1), true
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"lanpaper/storage"
+)
+
+func TestAlbum(t *testing.T) {
+	setupUploadDir(t)
+	storage.Global.Set("alb", &storage.Wallpaper{ID: "alb", LinkName: "alb"})
+	t.Cleanup(func() { storage.Global.Delete("alb") })
+
+	// Distinct widths tell the album images apart.
+	pngOf := func(w int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, 4))); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	appendFile := func(data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("linkName", "alb")
+		fw, _ := mw.CreateFormFile("file", "a.png")
+		_, _ = fw.Write(data)
+		_ = mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/upload?append=true", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		Upload(rec, req)
+		return rec
+	}
+
+	if rec := appendFile(pngOf(10)); rec.Code != http.StatusBadRequest {
+		t.Errorf("append to an empty link: status = %d, want 400", rec.Code)
+	}
+	if rec := uploadFile("alb", "a.png", pngOf(10)); rec.Code != http.StatusOK {
+		t.Fatalf("main upload: status = %d: %s", rec.Code, rec.Body)
+	}
+	for _, w := range []int{20, 30} {
+		if rec := appendFile(pngOf(w)); rec.Code != http.StatusOK {
+			t.Fatalf("append: status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	wp, _ := storage.Global.Get("alb")
+	if len(wp.Album) != 2 || wp.Album[1].ImagePath != filepath.Join("static", "images", "alb@2.png") {
+		t.Fatalf("album = %+v, want alb@1.png and alb@2.png", wp.Album)
+	}
+	if want, _ := previewPathFor("alb"); wp.PreviewPath != want {
+		t.Errorf("preview = %q, want the main image's %q", wp.PreviewPath, want)
+	}
+
+	for i, wantWidth := range []int{10, 20, 30} {
+		rec := httptest.NewRecorder()
+		Public(rec, httptest.NewRequest(http.MethodGet, "/alb?i="+strconv.Itoa(i), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("?i=%d: status = %d", i, rec.Code)
+		}
+		cfg, err := png.DecodeConfig(rec.Body)
+		if err != nil || cfg.Width != wantWidth {
+			t.Errorf("?i=%d: width = %d (%v), want %d", i, cfg.Width, err, wantWidth)
+		}
+	}
+	for _, q := range []string{"3", "-1", "x"} {
+		rec := httptest.NewRecorder()
+		Public(rec, httptest.NewRequest(http.MethodGet, "/alb?i="+q, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("?i=%s: status = %d, want 404", q, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	Public(rec, httptest.NewRequest(http.MethodGet, "/alb", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("rotating album: status = %d, want 200", rec.Code)
+	}
+
+	// A plain upload replaces the whole album.
+	if rec := uploadFile("alb", "a.png", pngOf(40)); rec.Code != http.StatusOK {
+		t.Fatalf("replace: status = %d: %s", rec.Code, rec.Body)
+	}
+	if wp, _ := storage.Global.Get("alb"); len(wp.Album) != 0 {
+		t.Errorf("album after replace = %d images, want 0", len(wp.Album))
+	}
+	if _, err := os.Stat(filepath.Join("static", "images", "alb@1.png")); !os.IsNotExist(err) {
+		t.Errorf("replaced album image left on disk: %v", err)
+	}
+}
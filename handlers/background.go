@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+)
+
+// background tracks goroutines handlers start that outlive their request,
+// such as pruning after an upload. srv.Shutdown only waits for handlers, so
+// main waits on these separately to avoid killing them mid-write.
+// It is a counter rather than a sync.WaitGroup because WaitBackground can
+// give up on a timeout, and a WaitGroup left in Wait must not see an Add.
+var background struct {
+	mu      sync.Mutex
+	running int
+	idle    chan struct{} // closed when running drops to zero
+}
+
+// goBackground runs fn in a goroutine tracked by WaitBackground.
+func goBackground(fn func()) {
+	b := &background
+	b.mu.Lock()
+	if b.running == 0 {
+		b.idle = make(chan struct{})
+	}
+	b.running++
+	b.mu.Unlock()
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			if b.running--; b.running == 0 {
+				close(b.idle)
+			}
+			b.mu.Unlock()
+		}()
+		fn()
+	}()
+}
+
+// WaitBackground blocks until all background tasks have finished or ctx is
+// done, whichever comes first.
+func WaitBackground(ctx context.Context) error {
+	b := &background
+	b.mu.Lock()
+	if b.running == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	idle := b.idle
+	b.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
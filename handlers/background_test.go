@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"lanpaper/storage"
+)
+
+func TestWaitBackgroundWaitsForPrune(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	storage.Global.Set("bg-old", &storage.Wallpaper{ID: "bg-old", LinkName: "bg-old", HasImage: true, MIMEType: "jpg", ModTime: 1})
+	storage.Global.Set("bg-new", &storage.Wallpaper{ID: "bg-new", LinkName: "bg-new", HasImage: true, MIMEType: "jpg", ModTime: 1 << 40})
+	t.Cleanup(func() {
+		storage.Global.Delete("bg-old")
+		storage.Global.Delete("bg-new")
+	})
+
+	release := make(chan struct{})
+	goBackground(func() {
+		<-release
+		storage.PruneOldImages(1)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := WaitBackground(ctx); err == nil {
+		t.Fatal("WaitBackground returned while the prune was still in flight")
+	}
+
+	close(release)
+	if err := WaitBackground(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if wp, _ := storage.Global.Get("bg-old"); wp.HasImage {
+		t.Error("WaitBackground returned before the prune finished")
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"slices"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+// CategoryCount is one entry of the /api/categories response.
+type CategoryCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Categories handles GET /api/categories: every category with the number of
+// links in it, sorted by name, so a UI can build its filter without
+// fetching all wallpapers. All assignable categories are listed, even when
+// empty, as are "image" and "video" once INFER_CATEGORY is on or a link
+// uses them. Links without a category count towards the default one.
+func Categories(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	counts := make(map[string]int, len(validCategories)+2)
+	for cat := range validCategories {
+		counts[cat] = 0
+	}
+	if config.Current.InferCategory {
+		counts["image"], counts["video"] = 0, 0
+	}
+	for _, wp := range storage.Global.GetAllCopy() {
+		if wp != nil {
+			counts[cmp.Or(wp.Category, defaultCategory())]++
+		}
+	}
+
+	list := make([]CategoryCount, 0, len(counts))
+	for name, n := range counts {
+		list = append(list, CategoryCount{Name: name, Count: n})
+	}
+	slices.SortFunc(list, func(a, b CategoryCount) int { return cmp.Compare(a.Name, b.Name) })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, list, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding categories response: %v", err)
+	}
+}
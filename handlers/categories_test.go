@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+func TestCategories(t *testing.T) {
+	config.Current = config.Config{InferCategory: true}
+	t.Cleanup(func() { config.Current = config.Config{} })
+	for name, cat := range map[string]string{"ct-a": "desktop", "ct-b": "desktop", "ct-c": "video", "ct-d": ""} {
+		storage.Global.Set(name, &storage.Wallpaper{ID: name, LinkName: name, Category: cat})
+		t.Cleanup(func() { storage.Global.Delete(name) })
+	}
+
+	rec := httptest.NewRecorder()
+	Categories(rec, httptest.NewRequest(http.MethodGet, "/api/categories", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var list []CategoryCount
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for i, c := range list {
+		got[c.Name] = c.Count
+		if i > 0 && list[i-1].Name >= c.Name {
+			t.Errorf("not sorted by name: %v", list)
+		}
+	}
+	want := map[string]int{"desktop": 2, "video": 1, "other": 1, "image": 0, "mobile": 0, "tech": 0}
+	for name, n := range want {
+		if c, ok := got[name]; !ok || c != n {
+			t.Errorf("%s = %d (listed %v), want %d", name, c, ok, n)
+		}
+	}
+
+	config.Current.InferCategory = false
+	rec = httptest.NewRecorder()
+	Categories(rec, httptest.NewRequest(http.MethodGet, "/api/categories", nil))
+	list = nil
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range list {
+		if c.Name == "image" {
+			t.Errorf("image listed without INFER_CATEGORY or links using it: %v", list)
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
+	"net/http"
 	"regexp"
+	"slices"
 	"strings"
+
+	"lanpaper/config"
+	"lanpaper/utils"
 )
 
 // reservedNames cannot be used as link names — they clash with existing routes.
@@ -21,3 +27,85 @@ func isValidLinkName(name string) bool {
 		!reservedNames[strings.ToLower(name)] &&
 		linkNameRe.MatchString(name)
 }
+
+// newReservedNames can't be given to new links but stay valid for
+// existing ones: /gallery shadows a link of that name only while
+// PublicGallery is on, and links created before it existed must remain
+// reachable and manageable.
+var newReservedNames = map[string]bool{"gallery": true}
+
+// isValidNewLinkName is isValidLinkName for names being created or renamed to.
+func isValidNewLinkName(name string) bool {
+	return isValidLinkName(name) && !newReservedNames[strings.ToLower(name)]
+}
+
+// prettyJSON reports whether the JSON response to r should be indented for
+// reading: PrettyAPI is on, or the request asks with ?pretty=1.
+func prettyJSON(r *http.Request) bool {
+	return config.Current.PrettyAPI || r.URL.Query().Get("pretty") == "1"
+}
+
+// writeJSON encodes v to w, indented by two spaces when pretty. Headers and
+// the status code are left to the caller.
+func writeJSON(w http.ResponseWriter, v any, pretty bool) error {
+	enc := json.NewEncoder(w)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+// methodNotAllowed replies 405 with an Allow header listing the methods
+// the endpoint accepts, as RFC 9110 requires.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// allowMethod reports whether r uses one of methods. If not, it has already
+// answered: 204 for OPTIONS and 405 for anything else, both with an Allow
+// header listing methods plus OPTIONS.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if slices.Contains(methods, r.Method) {
+		return true
+	}
+	allowed := append(slices.Clip(methods), http.MethodOptions)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	methodNotAllowed(w, allowed...)
+	return false
+}
+
+// absoluteURL returns the URL clients reach path p at, BasePath included,
+// for features that hand out links outside the browser (QR codes and the
+// like).
+func absoluteURL(r *http.Request, p string) string {
+	return publicBaseURL(r) + config.URLPath(p)
+}
+
+// publicBaseURL is the scheme and host clients reach the server at:
+// PublicBaseURL when configured, else the request's own, with
+// X-Forwarded-Proto and X-Forwarded-Host honoured only from the
+// TrustedProxy. BasePath is not included.
+func publicBaseURL(r *http.Request) string {
+	if config.Current.PublicBaseURL != "" {
+		return config.Current.PublicBaseURL
+	}
+	scheme, host := utils.RequestScheme(r), r.Host
+	if config.IsTrustedProxy(r.RemoteAddr) {
+		if h := firstHeaderValue(r, "X-Forwarded-Host"); h != "" && !strings.ContainsAny(h, "/\\@ ") {
+			host = h
+		}
+	}
+	return scheme + "://" + host
+}
+
+// firstHeaderValue returns the first comma-separated value of header key,
+// the one set by the proxy closest to the client.
+func firstHeaderValue(r *http.Request, key string) string {
+	v, _, _ := strings.Cut(r.Header.Get(key), ",")
+	return strings.ToLower(strings.TrimSpace(v))
+}
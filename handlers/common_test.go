@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+func TestAbsoluteURL(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("TRUSTED_PROXY", "10.0.0.1")
+	config.Load()
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	tests := []struct {
+		name   string
+		base   string
+		remote string
+		tls    bool
+		header map[string]string
+		want   string
+	}{
+		{"request host", "", "192.0.2.1:1234", false, nil, "http://lan:8080"},
+		{"tls", "", "192.0.2.1:1234", true, nil, "https://lan:8080"},
+		{"trusted proxy", "", "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "walls.example.com, inner"}, "https://walls.example.com"},
+		{"untrusted proxy", "", "192.0.2.1:1234", false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}, "http://lan:8080"},
+		{"configured", "https://walls.example.com", "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-Host": "other"}, "https://walls.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current.PublicBaseURL = tt.base
+			r := httptest.NewRequest(http.MethodGet, "http://lan:8080/api/link/x/qr", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if got := publicBaseURL(r); got != tt.want {
+				t.Errorf("publicBaseURL = %q, want %q", got, tt.want)
+			}
+			config.Current.BasePath = "/walls"
+			defer func() { config.Current.BasePath = "" }()
+			if got := absoluteURL(r, "/desk"); got != tt.want+"/walls/desk" {
+				t.Errorf("absoluteURL = %q, want %q", got, tt.want+"/walls/desk")
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowedSetsAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		allow   string
+	}{
+		{"Wallpapers", Wallpapers, http.MethodPost, "/api/wallpapers", "GET, OPTIONS"},
+		{"Link", Link, http.MethodGet, "/api/link/x", "POST, PATCH, DELETE, OPTIONS"},
+		{"Upload", Upload, http.MethodGet, "/api/upload", "POST, OPTIONS"},
+		{"RegeneratePreviews", RegeneratePreviews, http.MethodGet, "/api/regenerate-previews", "POST"},
+		{"GetCompressionConfig", GetCompressionConfig, http.MethodPut, "/api/compression-config", "GET"},
+		{"ExternalImages", ExternalImages, http.MethodPost, "/api/external-images", "GET, OPTIONS"},
+		{"ExternalImagesStream", ExternalImagesStream, http.MethodPost, "/api/external-images/stream", "GET, OPTIONS"},
+		{"TogglePin", TogglePin, http.MethodGet, "/api/link/x/pin", "POST"},
+		{"Touch", Touch, http.MethodGet, "/api/link/x/touch", "POST"},
+		{"LinkQR", LinkQR, http.MethodPost, "/api/link/x/qr", "GET, HEAD"},
+		{"Reload", Reload, http.MethodGet, "/api/reload", "POST"},
+		{"Recategorize", Recategorize, http.MethodGet, "/api/recategorize", "POST"},
+		{"Similar", Similar, http.MethodPost, "/api/similar/x", "GET"},
+		{"EffectiveConfig", EffectiveConfig, http.MethodPost, "/api/config/effective", "GET"},
+		{"StoredPreview", StoredPreview, http.MethodPost, "/api/preview/x", "GET, HEAD"},
+		{"Robots", Robots, http.MethodPost, "/robots.txt", "GET, HEAD"},
+		{"public metadata", Public, http.MethodPost, "/x.json", "GET, HEAD"},
+		{"resize", Public, http.MethodPost, "/x/resize?w=10", "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("status = %d, want 405", rec.Code)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestOptionsListsMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		allow   string
+	}{
+		{"Wallpapers", Wallpapers, "/api/wallpapers", "GET, OPTIONS"},
+		{"Link", Link, "/api/link/x", "POST, PATCH, DELETE, OPTIONS"},
+		{"Upload", Upload, "/api/upload", "POST, OPTIONS"},
+		{"ExternalImages", ExternalImages, "/api/external-images", "GET, OPTIONS"},
+		{"ExternalImagesStream", ExternalImagesStream, "/api/external-images/stream", "GET, OPTIONS"},
+		{"ExternalImagePreview", ExternalImagePreview, "/api/external-image-preview?path=a.jpg", "GET, HEAD, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodOptions, tt.target, nil))
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want 204", rec.Code)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	t.Cleanup(func() { config.Current = config.Config{} })
+	storage.Global.Set("pretty", &storage.Wallpaper{ID: "pretty", LinkName: "pretty"})
+	t.Cleanup(func() { storage.Global.Delete("pretty") })
+
+	tests := []struct {
+		name   string
+		config bool
+		query  string
+		pretty bool
+	}{
+		{"default", false, "", false},
+		{"query", false, "?pretty=1", true},
+		{"config", true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current = config.Config{PrettyAPI: tt.config}
+			rec := httptest.NewRecorder()
+			Wallpapers(rec, httptest.NewRequest(http.MethodGet, "/api/wallpapers"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			if got := strings.Contains(rec.Body.String(), "\n  {"); got != tt.pretty {
+				t.Errorf("indented = %v, want %v:\n%s", got, tt.pretty, rec.Body)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 
@@ -19,15 +18,15 @@ type CompressionConfigResponse struct {
 // at runtime via config reload without a server restart.
 func GetCompressionConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodGet)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-cache")
-	if err := json.NewEncoder(w).Encode(CompressionConfigResponse{
+	if err := writeJSON(w, CompressionConfigResponse{
 		Quality: config.Current.Compression.Quality,
 		Scale:   config.Current.Compression.Scale,
-	}); err != nil {
+	}, prettyJSON(r)); err != nil {
 		log.Printf("Error encoding compression config response: %v", err)
 	}
 }
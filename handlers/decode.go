@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"lanpaper/config"
+)
+
+// decodeSem bounds the number of full image decodes running at once across
+// all handlers (uploads, preview regeneration, ...). uploadSem only limits
+// HTTP-level upload concurrency, so batch endpoints could otherwise push the
+// number of simultaneous decodes past what a small device can hold in memory.
+var decodeSem chan struct{}
+
+func InitDecodeSemaphore(n int) {
+	if n <= 0 {
+		n = 2
+	}
+	decodeSem = make(chan struct{}, n)
+}
+
+// decodeBytesPerPixel is the estimated cost of one decoded pixel: decoders
+// produce at most 4 bytes per pixel (RGBA/YCbCr+alpha).
+const decodeBytesPerPixel = 4
+
+// memBudget is a weighted semaphore over bytes. decodeSem caps the number
+// of decodes, but two 16384×16384 images still need gigabytes between them;
+// the budget makes admission proportional to the decoded size instead.
+type memBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	total int64
+	used  int64
+}
+
+var decodeBudget *memBudget
+
+// InitDecodeBudget sets the number of bytes concurrent decodes may hold.
+// A non-positive value disables the budget.
+func InitDecodeBudget(n int64) {
+	if n <= 0 {
+		decodeBudget = nil
+		return
+	}
+	b := &memBudget{total: n}
+	b.cond = sync.NewCond(&b.mu)
+	decodeBudget = b
+}
+
+// acquire blocks until n bytes are free. Requests larger than the whole
+// budget can never be admitted and are rejected immediately.
+func (b *memBudget) acquire(n int64) error {
+	if n > b.total {
+		return fmt.Errorf("image needs ~%d MB to decode, over the %d MB decode budget", n>>20, b.total>>20)
+	}
+	b.mu.Lock()
+	for b.used+n > b.total {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *memBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// decodeImage is image.Decode guarded by decodeBudget and decodeSem. The
+// header is read first to size the decode; callers then block until both
+// enough budget and a slot are free. With neither initialised it decodes
+// unbounded. A decode running longer than config.Current.DecodeTimeout is
+// abandoned with errDecodeTimeout.
+func decodeImage(r io.Reader) (image.Image, string, error) {
+	var need int64
+	if decodeBudget != nil {
+		// Keep the header bytes DecodeConfig consumes so the full decode
+		// can replay them.
+		var head bytes.Buffer
+		cfg, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+		if err != nil {
+			return nil, "", err
+		}
+		r = io.MultiReader(&head, r)
+		need = int64(cfg.Width) * int64(cfg.Height) * decodeBytesPerPixel
+		if err := decodeBudget.acquire(need); err != nil {
+			return nil, "", err
+		}
+	}
+	if decodeSem != nil {
+		decodeSem <- struct{}{}
+	}
+	// Released by whoever finishes the decode: an abandoned one keeps its
+	// memory until it actually stops, so its slot stays taken until then.
+	release := func() {
+		if decodeSem != nil {
+			<-decodeSem
+		}
+		if decodeBudget != nil {
+			decodeBudget.release(need)
+		}
+	}
+
+	timeout := time.Duration(config.Current.DecodeTimeout) * time.Second
+	if timeout <= 0 {
+		defer release()
+		return image.Decode(r)
+	}
+
+	type result struct {
+		img    image.Image
+		format string
+		err    error
+	}
+	cr := &cancelReader{r: r}
+	done := make(chan result, 1)
+	go func() {
+		defer release()
+		img, format, err := image.Decode(cr)
+		done <- result{img, format, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.img, res.format, res.err
+	case <-timer.C:
+		cr.cancel()
+		return nil, "", errDecodeTimeout
+	}
+}
+
+var errDecodeTimeout = errors.New("image took too long to decode")
+
+// cancelReader fails every Read after cancel. image.Decode can't be
+// interrupted, but decoders read as they go, so an abandoned decode stops
+// at its next read instead of running to the end in the background.
+type cancelReader struct {
+	r        io.Reader
+	canceled atomic.Bool
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	if c.canceled.Load() {
+		return 0, errDecodeTimeout
+	}
+	return c.r.Read(p)
+}
+
+func (c *cancelReader) cancel() { c.canceled.Store(true) }
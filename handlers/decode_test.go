@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+// slowDecoder is a fake image format whose Decode sleeps while recording the
+// peak number of decodes running at once.
+var (
+	slowInFlight atomic.Int32
+	slowPeak     atomic.Int32
+)
+
+const slowMagic = "SLOWTEST"
+
+func init() {
+	image.RegisterFormat("slowtest", slowMagic, decodeSlow, decodeSlowConfig)
+}
+
+func decodeSlow(r io.Reader) (image.Image, error) {
+	n := slowInFlight.Add(1)
+	defer slowInFlight.Add(-1)
+	for {
+		p := slowPeak.Load()
+		if n <= p || slowPeak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(0, 0, color.White)
+	return img, nil
+}
+
+func decodeSlowConfig(r io.Reader) (image.Config, error) {
+	return image.Config{ColorModel: color.RGBAModel, Width: 8, Height: 8}, nil
+}
+
+// slowFile returns contents the slowtest format recognises, padded so the
+// magic-byte helpers see enough data.
+func slowFile() []byte {
+	return append([]byte(slowMagic), bytes.Repeat([]byte{0}, 64)...)
+}
+
+func TestDecodeSemaphoreCapsRegenerateAndUpload(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, d := range []string{"data", "external", "static/images/previews"} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config.Current = config.Config{
+		MaxUploadMB:      10,
+		ExternalImageDir: "external",
+		PreviewFormat:    "webp",
+		Compression:      config.CompressionConfig{Quality: 85, Scale: 100},
+	}
+	const limit = 2
+	InitDecodeSemaphore(limit)
+	InitUploadSemaphore(8)
+	t.Cleanup(func() { decodeSem = nil })
+	slowPeak.Store(0)
+
+	for i := range 6 {
+		name := "regen" + string(rune('a'+i))
+		path := filepath.Join("static", "images", name+".slowtest")
+		if err := os.WriteFile(path, slowFile(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		storage.Global.Set(name, &storage.Wallpaper{
+			ID: name, LinkName: name, HasImage: true, MIMEType: "slowtest", ImagePath: path,
+		})
+		t.Cleanup(func() { storage.Global.Delete(name) })
+	}
+	if err := os.WriteFile(filepath.Join("external", "up.slowtest"), slowFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage.Global.Set("uplink", &storage.Wallpaper{ID: "uplink", LinkName: "uplink"})
+	t.Cleanup(func() { storage.Global.Delete("uplink") })
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodPost, "/api/regenerate-previews", nil)
+		RegeneratePreviews(httptest.NewRecorder(), req)
+	}()
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			_ = mw.WriteField("linkName", "uplink")
+			_ = mw.WriteField("url", "up.slowtest")
+			_ = mw.Close()
+			req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			Upload(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	if peak := slowPeak.Load(); peak == 0 {
+		t.Fatal("no decodes ran")
+	} else if peak > limit {
+		t.Errorf("peak concurrent decodes = %d, want <= %d", peak, limit)
+	}
+}
+
+func TestDecodeBudget(t *testing.T) {
+	t.Cleanup(func() { decodeBudget = nil })
+	const perImage = 8 * 8 * decodeBytesPerPixel
+
+	InitDecodeBudget(perImage - 1)
+	if _, _, err := decodeImage(bytes.NewReader(slowFile())); err == nil {
+		t.Fatal("decode over the whole budget succeeded, want error")
+	}
+
+	InitDecodeBudget(2 * perImage)
+	slowPeak.Store(0)
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := decodeImage(bytes.NewReader(slowFile())); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak := slowPeak.Load(); peak > 2 {
+		t.Errorf("peak concurrent decodes = %d, want <= 2", peak)
+	}
+	if used := decodeBudget.used; used != 0 {
+		t.Errorf("budget used after decodes = %d, want 0", used)
+	}
+}
+
+// stallMagic is a fake format whose decoder reads slowly, a block at a
+// time, until its reader fails, recording the error it stopped on.
+const stallMagic = "STALLTST"
+
+var stallStopped = make(chan error, 1)
+
+func init() {
+	image.RegisterFormat("stalltest", stallMagic, decodeStall, decodeSlowConfig)
+}
+
+func decodeStall(r io.Reader) (image.Image, error) {
+	b := make([]byte, 8192) // past image.Decode's bufio buffer
+	for {
+		if _, err := r.Read(b); err != nil {
+			stallStopped <- err
+			return nil, err
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDecodeTimeout(t *testing.T) {
+	config.Current = config.Config{DecodeTimeout: 1}
+	t.Cleanup(func() { config.Current = config.Config{} })
+	InitDecodeSemaphore(1)
+	t.Cleanup(func() { decodeSem = nil })
+
+	// Far more bytes than the decoder gets through in a second.
+	data := append([]byte(stallMagic), make([]byte, 8<<20)...)
+	start := time.Now()
+	_, _, err := decodeImage(bytes.NewReader(data))
+	if err != errDecodeTimeout {
+		t.Fatalf("err = %v, want errDecodeTimeout", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("decodeImage returned after %v", d)
+	}
+
+	// The abandoned decoder stops at its next read and frees its slot.
+	select {
+	case err := <-stallStopped:
+		if err != errDecodeTimeout {
+			t.Errorf("decoder stopped on %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("abandoned decoder kept running")
+	}
+	select {
+	case decodeSem <- struct{}{}:
+		<-decodeSem
+	case <-time.After(time.Second):
+		t.Error("decode slot not released")
+	}
+
+	config.Current.DecodeTimeout = 0
+	if _, _, err := decodeImage(bytes.NewReader(slowFile())); err != nil {
+		t.Errorf("decode without timeout: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"lanpaper/config"
+)
+
+// EffectiveConfig handles GET /api/config/effective: the fully resolved
+// configuration (defaults, config.json and env applied) with secrets
+// redacted, for operators checking which setting actually took effect.
+func EffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeJSON(w, config.Effective(), prettyJSON(r)); err != nil {
+		log.Printf("Error encoding effective config response: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"lanpaper/config"
+)
+
+// externalWalk caches listExternalImages. Walking a large network mount on
+// every gallery open is slow, so results are reused for
+// config.Current.ExternalWalkCacheTTL seconds. mu is held for the walk
+// itself, so concurrent requests wait for one walk instead of each starting
+// their own.
+var externalWalk struct {
+	mu    sync.Mutex
+	key   string // ExternalImageDir and MaxWalkDepth the result is for
+	files []string
+	at    time.Time
+}
+
+// cachedExternalImages returns the external image listing, walking the
+// directory again only when the cached result has expired, the settings it
+// was built from changed, or refresh is set. Callers must not modify the
+// returned slice.
+func cachedExternalImages(refresh bool) ([]string, error) {
+	ttl := time.Duration(config.Current.ExternalWalkCacheTTL) * time.Second
+	key := fmt.Sprintf("%s\x00%d", config.Current.ExternalImageDir, config.Current.MaxWalkDepth)
+
+	c := &externalWalk
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !refresh && ttl > 0 && c.files != nil && c.key == key && time.Since(c.at) < ttl {
+		return c.files, nil
+	}
+	files, err := listExternalImages()
+	if err != nil {
+		c.files = nil
+		return nil, err
+	}
+	c.files, c.key, c.at = files, key, time.Now()
+	return files, nil
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"lanpaper/config"
+)
+
+const (
+	// streamFlushEvery and streamFlushInterval bound how long a found path
+	// waits in the response buffer before it reaches the client.
+	streamFlushEvery    = 100
+	streamFlushInterval = 250 * time.Millisecond
+)
+
+// ExternalImagesStream handles GET /api/external-images/stream: the same
+// files as /api/external-images, sent as Server-Sent Events while the
+// directory is walked instead of after. Each file is one "data:" event
+// carrying its relative path, in walk order; a final "done" event carries
+// the count. Closing the connection stops the walk.
+func ExternalImagesStream(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if config.Current.DisableExternalImages {
+		http.NotFound(w, r)
+		return
+	}
+	rc := http.NewResponseController(w)
+	// A large walk can outlast the server's WriteTimeout.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // keep nginx from holding events back
+
+	ctx := r.Context()
+	count, pending := 0, 0
+	lastFlush := time.Now()
+	flush := func() error {
+		pending, lastFlush = 0, time.Now()
+		return rc.Flush()
+	}
+	err := walkExternalImages(func(rel string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// An SSE data line can't hold a line break; such names can't be
+		// selected for upload either.
+		if strings.ContainsAny(rel, "\r\n") {
+			return nil
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", rel); err != nil {
+			return err
+		}
+		count++
+		pending++
+		if pending >= streamFlushEvery || time.Since(lastFlush) >= streamFlushInterval {
+			return flush()
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return // client went away
+	}
+	if err != nil && count > 0 {
+		log.Printf("External image stream stopped: %v", err)
+		return
+	}
+	// Like /api/external-images, a missing directory is an empty listing.
+	fmt.Fprintf(w, "event: done\ndata: %d\n\n", count)
+	_ = flush()
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"lanpaper/config"
+)
+
+func TestExternalImagesStream(t *testing.T) {
+	dir := t.TempDir()
+	var want []string
+	for i := range 5 {
+		name := fmt.Sprintf("a/img%d.png", i)
+		if err := os.MkdirAll(filepath.Join(dir, "a"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+	// Filtered as in the JSON listing: hidden, too deep, unsupported.
+	for _, name := range []string{".hidden/x.png", "a/b/c/d/deep.png", "notes.txt"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Current = config.Config{ExternalImageDir: dir, MaxWalkDepth: 3}
+
+	rec := httptest.NewRecorder()
+	ExternalImagesStream(rec, httptest.NewRequest(http.MethodGet, "/api/external-images/stream", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !rec.Flushed {
+		t.Error("stream was never flushed")
+	}
+	var got []string
+	var done string
+	for _, ev := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		if d, ok := strings.CutPrefix(ev, "event: done\ndata: "); ok {
+			done = d
+		} else if p, ok := strings.CutPrefix(ev, "data: "); ok {
+			got = append(got, p)
+		} else {
+			t.Errorf("unexpected event %q", ev)
+		}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+	if done != "5" {
+		t.Errorf("done = %q, want 5", done)
+	}
+
+	// A client that has gone away gets nothing more.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec = httptest.NewRecorder()
+	ExternalImagesStream(rec, httptest.NewRequest(http.MethodGet, "/api/external-images/stream", nil).WithContext(ctx))
+	if rec.Body.Len() != 0 {
+		t.Errorf("cancelled stream wrote %q", rec.Body)
+	}
+}
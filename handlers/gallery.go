@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"lanpaper/config"
+	"lanpaper/middleware"
+	"lanpaper/storage"
+)
+
+// galleryMaxAge is how long clients and proxies may cache a gallery page.
+const galleryMaxAge = 60 // seconds
+
+// GalleryItem is the public view of a link: only what an embedding page
+// needs, none of the admin metadata.
+type GalleryItem struct {
+	LinkName string `json:"linkName"`
+	ImageURL string `json:"imageUrl"`
+	Preview  string `json:"preview,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+}
+
+// GalleryPage is the response of /api/gallery.
+type GalleryPage struct {
+	Data       []GalleryItem `json:"data"`
+	Total      int           `json:"total"`
+	Page       int           `json:"page"`
+	PageSize   int           `json:"pageSize"`
+	TotalPages int           `json:"totalPages"`
+}
+
+// Gallery handles GET /api/gallery: a public, paginated listing of links
+// that have an image, optionally filtered by ?category. It is disabled
+// unless config.Current.PublicGallery is set.
+func Gallery(w http.ResponseWriter, r *http.Request) {
+	if !config.Current.PublicGallery {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q := r.URL.Query()
+	cat := q.Get("category")
+	if cat != "" && !isValidCategory(strings.ToLower(cat)) {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	page := 1
+	if pageStr := q.Get("page"); pageStr != "" {
+		n, err := strconv.Atoi(pageStr)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid page number", http.StatusBadRequest)
+			return
+		}
+		page = n
+	}
+	pageSize := clampPageSize(q.Get("page_size"))
+
+	items := galleryItems(cat)
+	total := len(items)
+	start, end := pageWindow(page, pageSize, total)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(galleryMaxAge))
+	if err := writeJSON(w, GalleryPage{
+		Data: append([]GalleryItem{}, items[start:end]...), Total: total,
+		Page: page, PageSize: pageSize, TotalPages: max(1, (total+pageSize-1)/pageSize),
+	}, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding gallery response: %v", err)
+	}
+}
+
+// galleryItems lists the links with an image, in category cat when it is
+// not empty.
+func galleryItems(cat string) []GalleryItem {
+	var items []GalleryItem
+	for _, wp := range storage.Global.GetAll() {
+		if !wp.HasImage || (cat != "" && !strings.EqualFold(wp.Category, cat)) {
+			continue
+		}
+		items = append(items, GalleryItem{
+			LinkName: wp.LinkName,
+			ImageURL: config.URLPath("/" + wp.LinkName),
+			Preview:  config.URLPath(wp.Preview),
+			Width:    wp.Width,
+			Height:   wp.Height,
+		})
+	}
+	return items
+}
+
+// galleryPageTmpl renders GalleryHTML. Styles are inline, under the CSP
+// nonce, so the page needs nothing from /static.
+var galleryPageTmpl = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Category}}{{.Category}} · {{end}}Lanpaper</title>
+<style nonce="{{.Nonce}}">
+body { margin: 0; padding: 1rem; font-family: system-ui, sans-serif; background: #111; color: #eee; }
+nav a { color: #999; margin-right: .75rem; text-decoration: none; }
+nav a.active { color: #fff; font-weight: 600; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(220px, 1fr)); gap: 1rem; margin-top: 1rem; }
+.grid a { color: inherit; text-decoration: none; }
+.grid img, .grid .none { display: block; width: 100%; aspect-ratio: 16 / 10; object-fit: cover; border-radius: 6px; background: #222; }
+.grid span { display: block; margin-top: .25rem; font-size: .9rem; }
+</style>
+</head>
+<body>
+<nav>
+<a href="{{.Base}}/gallery" class="{{if not .Category}}active{{end}}">All</a>
+{{- range .Categories}}
+<a href="{{$.Base}}/gallery?category={{.}}" class="{{if eq . $.Category}}active{{end}}">{{.}}</a>
+{{- end}}
+</nav>
+{{if .Items}}<div class="grid">
+{{- range .Items}}
+<a href="{{.ImageURL}}">{{if .Preview}}<img src="{{.Preview}}" alt="{{.LinkName}}" loading="lazy">{{else}}<div class="none"></div>{{end}}<span>{{.LinkName}}</span></a>
+{{- end}}
+</div>{{else}}<p>No wallpapers here yet.</p>{{end}}
+</body>
+</html>
+`))
+
+// galleryPage is the data galleryPageTmpl renders.
+type galleryPage struct {
+	Base, Nonce, Category string
+	Categories            []string
+	Items                 []GalleryItem
+}
+
+// GalleryHTML handles GET /gallery: a read-only HTML index of the links
+// with an image, optionally for one ?category, linking each preview to
+// its public URL. Like /api/gallery it is only served with PublicGallery.
+func GalleryHTML(w http.ResponseWriter, r *http.Request) {
+	if !config.Current.PublicGallery {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	cat := strings.ToLower(r.URL.Query().Get("category"))
+	if cat != "" && !isValidCategory(cat) {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := galleryPageTmpl.Execute(&buf, galleryPage{
+		Base:       config.Current.BasePath,
+		Nonce:      middleware.NonceFromRequest(r),
+		Category:   cat,
+		Categories: slices.Sorted(maps.Keys(validCategories)),
+		Items:      galleryItems(cat),
+	})
+	if err != nil {
+		log.Printf("Error rendering gallery page: %v", err)
+		http.Error(w, "Gallery unavailable", http.StatusInternalServerError)
+		return
+	}
+	// The nonce is per request, so the page can't be shared from a cache.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
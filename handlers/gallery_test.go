@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/middleware"
+	"lanpaper/storage"
+)
+
+func TestGallery(t *testing.T) {
+	for _, wp := range []*storage.Wallpaper{
+		{ID: "g-desk", LinkName: "g-desk", Category: "desktop", HasImage: true, Width: 1920, Height: 1080},
+		{ID: "g-phone", LinkName: "g-phone", Category: "mobile", HasImage: true},
+		{ID: "g-empty", LinkName: "g-empty", Category: "desktop"},
+	} {
+		storage.Global.Set(wp.ID, wp)
+		t.Cleanup(func() { storage.Global.Delete(wp.ID) })
+	}
+
+	config.Current = config.Config{}
+	rec := httptest.NewRecorder()
+	Gallery(rec, httptest.NewRequest(http.MethodGet, "/api/gallery", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("disabled gallery: status = %d, want 404", rec.Code)
+	}
+
+	config.Current = config.Config{PublicGallery: true}
+	rec = httptest.NewRecorder()
+	Gallery(rec, httptest.NewRequest(http.MethodGet, "/api/gallery?category=desktop", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc == "" {
+		t.Error("missing Cache-Control")
+	}
+	var page GalleryPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Data) != 1 {
+		t.Fatalf("got %d items (total %d), want only g-desk", len(page.Data), page.Total)
+	}
+	if got := page.Data[0]; got.LinkName != "g-desk" || got.Width != 1920 || got.Height != 1080 {
+		t.Errorf("item = %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	Gallery(rec, httptest.NewRequest(http.MethodGet, "/api/gallery?category=nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid category: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestGalleryHTML(t *testing.T) {
+	for _, wp := range []*storage.Wallpaper{
+		{ID: "gh-desk", LinkName: "gh-desk", Category: "desktop", HasImage: true, Preview: "/static/images/previews/gh-desk.webp"},
+		{ID: "gh-phone", LinkName: "gh-phone", Category: "mobile", HasImage: true},
+		{ID: "gh-empty", LinkName: "gh-empty", Category: "desktop"},
+	} {
+		storage.Global.Set(wp.ID, wp)
+		t.Cleanup(func() { storage.Global.Delete(wp.ID) })
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		middleware.WithSecurity(GalleryHTML)(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	config.Current = config.Config{}
+	if rec := get("/gallery"); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled gallery: status = %d, want 404", rec.Code)
+	}
+
+	config.Current = config.Config{PublicGallery: true, BasePath: "/wp", Rate: config.RateConfig{PublicPerMin: 1000, Burst: 1000}}
+	t.Cleanup(func() { config.Current = config.Config{} })
+	rec := get("/gallery?category=desktop")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`href="/wp/gh-desk"`, `src="/wp/static/images/previews/gh-desk.webp"`,
+		`href="/wp/gallery?category=mobile"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %s", want)
+		}
+	}
+	if strings.Contains(body, "gh-phone") || strings.Contains(body, "gh-empty") {
+		t.Error("page lists links outside the category or without an image")
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	m := regexp.MustCompile(`<style nonce="([^"]+)">`).FindStringSubmatch(body)
+	if m == nil || !strings.Contains(rec.Header().Get("Content-Security-Policy"), "'nonce-"+html.UnescapeString(m[1])+"'") {
+		t.Errorf("style nonce %q doesn't match the CSP header %q", m, rec.Header().Get("Content-Security-Policy"))
+	}
+
+	if rec := get("/gallery"); !strings.Contains(rec.Body.String(), "gh-phone") {
+		t.Error("unfiltered page lacks gh-phone")
+	}
+	if rec := get("/gallery?category=nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid category: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+// fileHash returns the hex SHA-256 of the file at p.
+func fileHash(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rehome renames p, a file whose name starts with linkName, to the same
+// name with hash in its place: "desk.png" becomes "<hash>.png" and
+// "desk.poster.jpg" "<hash>.poster.jpg". An existing file of that name
+// already has the same content and is replaced. Empty paths are returned
+// unchanged.
+func rehome(p, linkName, hash string) (string, error) {
+	if p == "" {
+		return "", nil
+	}
+	dst := filepath.Join(filepath.Dir(p), hash+strings.TrimPrefix(filepath.Base(p), linkName))
+	if err := os.Rename(p, dst); err != nil {
+		return p, err
+	}
+	return dst, nil
+}
+
+// staticURL returns the URL a file under static/ is served at.
+func staticURL(p string) string {
+	if p == "" {
+		return ""
+	}
+	return "/" + filepath.ToSlash(p)
+}
+
+// removeUnshared is removeFiles for linkName's own files: paths another
+// link still uses (hashed storage shares identical images) are kept.
+func removeUnshared(linkName string, paths ...string) {
+	for _, p := range paths {
+		if !storage.Global.SharedPath(p, linkName) {
+			removeFiles(p)
+		}
+	}
+}
+
+// previewKeyLen is the number of hex digits of the content hash in a
+// HashedPreviews name.
+const previewKeyLen = 12
+
+// keyedPreviewRe matches preview names carrying a content hash.
+var keyedPreviewRe = regexp.MustCompile(`\.[0-9a-f]{12}\.(webp|jpg)$`)
+
+// IsKeyedPreview reports whether name is a preview named by keyPreview.
+func IsKeyedPreview(name string) bool { return keyedPreviewRe.MatchString(name) }
+
+// keyPreview renames the preview at p to "{base}.{hash}.{ext}" after its
+// content when HashedPreviews is on, so the URL changes whenever the
+// preview does and it can be cached as immutable. It returns the path and
+// URL to store; if the rename fails the preview keeps its plain name.
+func keyPreview(p string) (path, url string) {
+	if !config.Current.HashedPreviews || p == "" {
+		return p, staticURL(p)
+	}
+	hash, err := fileHash(p)
+	if err != nil {
+		log.Printf("Warning: hashing preview %s: %v", p, err)
+		return p, staticURL(p)
+	}
+	ext := filepath.Ext(p)
+	keyed := strings.TrimSuffix(p, ext) + "." + hash[:previewKeyLen] + ext
+	if err := os.Rename(p, keyed); err != nil {
+		log.Printf("Warning: renaming preview %s: %v", p, err)
+		return p, staticURL(p)
+	}
+	return keyed, staticURL(keyed)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"lanpaper/config"
+)
+
+// hotlinked reports whether r embeds a public image in another site's page
+// and HotlinkProtection should refuse it. Same-origin requests, direct
+// navigations and requests without a Referer (displays, curl, privacy
+// settings) are always allowed; cross-site ones only from AllowedReferers.
+func hotlinked(r *http.Request) bool {
+	if !config.Current.HotlinkProtection {
+		return false
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	}
+	if r.Header.Get("Sec-Fetch-Dest") == "document" {
+		return false
+	}
+	ref := r.Header.Get("Referer")
+	if ref == "" {
+		return false
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Hostname() == "" {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, own := range []string{publicBaseURL(r), "//" + r.Host} {
+		if u, err := url.Parse(own); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return false
+		}
+	}
+	for _, allowed := range config.Current.AllowedReferers {
+		if refererMatches(host, allowed) {
+			return false
+		}
+	}
+	return true
+}
+
+// refererMatches reports whether host is allowed by an AllowedReferers
+// entry: a host name, optionally given as a URL, that also covers its
+// subdomains.
+func refererMatches(host, allowed string) bool {
+	allowed = strings.ToLower(strings.TrimSpace(allowed))
+	if strings.Contains(allowed, "://") {
+		u, err := url.Parse(allowed)
+		if err != nil {
+			return false
+		}
+		allowed = u.Hostname()
+	}
+	return allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed))
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"lanpaper/config"
+)
+
+const mqttTimeout = 10 * time.Second
+
+// mqttConn is the shared broker connection. It is opened by the first event
+// after startup and rebuilt when the broker setting changes; once connected,
+// the client reconnects on its own after a dropped connection.
+var mqttConn struct {
+	sync.Mutex
+	broker string
+	client mqtt.Client
+}
+
+// mqttClient returns a connected client for the configured broker, dialing
+// it if there is none yet. A failed dial leaves nothing cached, so the next
+// event tries again.
+func mqttClient() (mqtt.Client, error) {
+	c := config.Current
+	mqttConn.Lock()
+	defer mqttConn.Unlock()
+	if mqttConn.client != nil && mqttConn.broker == c.MQTTBroker {
+		return mqttConn.client, nil
+	}
+	if mqttConn.client != nil {
+		mqttConn.client.Disconnect(250)
+		mqttConn.client = nil
+	}
+	// Brokers drop the older of two sessions with the same ID, so each
+	// process picks its own.
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.MQTTBroker).
+		SetClientID("lanpaper-" + hex.EncodeToString(id)).
+		SetUsername(c.MQTTUsername).
+		SetPassword(c.MQTTPassword).
+		SetConnectTimeout(mqttTimeout).
+		SetAutoReconnect(true)
+	client := mqtt.NewClient(opts)
+	tok := client.Connect()
+	if !tok.WaitTimeout(mqttTimeout) {
+		client.Disconnect(0)
+		return nil, errors.New("connect timed out")
+	}
+	if err := tok.Error(); err != nil {
+		return nil, err
+	}
+	mqttConn.broker, mqttConn.client = c.MQTTBroker, client
+	return client, nil
+}
+
+// publishMQTT sends body to MQTTTopic at QoS 1. Failures are logged.
+func publishMQTT(event, linkName string, body []byte) {
+	client, err := mqttClient()
+	if err == nil {
+		tok := client.Publish(config.Current.MQTTTopic, 1, false, body)
+		if !tok.WaitTimeout(mqttTimeout) {
+			err = errors.New("publish timed out")
+		} else {
+			err = tok.Error()
+		}
+	}
+	if err != nil {
+		log.Printf("MQTT: %s %s not published: %v", event, linkName, err)
+	}
+}
+
+// CloseMQTT disconnects from the broker, giving in-flight publishes a
+// moment to finish. It is a no-op when MQTT was never used.
+func CloseMQTT() {
+	mqttConn.Lock()
+	defer mqttConn.Unlock()
+	if mqttConn.client != nil {
+		mqttConn.client.Disconnect(250)
+		mqttConn.client = nil
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+)
+
+// mqttMessage is what fakeBroker saw: the CONNECT username and one PUBLISH.
+type mqttMessage struct {
+	username string
+	topic    string
+	payload  []byte
+}
+
+// fakeBroker speaks just enough MQTT 3.1.1 for the client: it accepts
+// CONNECT, acknowledges QoS 1 PUBLISH and answers PINGREQ. While down is
+// set, connections are closed without a reply.
+func fakeBroker(t *testing.T, down *atomic.Bool) (string, <-chan mqttMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan mqttMessage, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if down.Load() {
+				conn.Close()
+				continue
+			}
+			go serveMQTT(conn, got)
+		}
+	}()
+	return "tcp://" + ln.Addr().String(), got
+}
+
+func serveMQTT(conn net.Conn, got chan<- mqttMessage) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var username string
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		n, err := binary.ReadUvarint(r) // MQTT's remaining length is the same varint
+		if err != nil {
+			return
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		str := func(b []byte) (string, []byte) {
+			l := binary.BigEndian.Uint16(b)
+			return string(b[2 : 2+l]), b[2+l:]
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			_, rest := str(body) // protocol name
+			flags := rest[1]
+			_, rest = str(rest[4:]) // client ID
+			if flags&0x80 != 0 {
+				username, _ = str(rest)
+			}
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			topic, rest := str(body)
+			if header&0x06 != 0 {
+				conn.Write([]byte{0x40, 2, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			got <- mqttMessage{username, topic, rest}
+		case 12: // PINGREQ
+			conn.Write([]byte{0xD0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func TestNotifyPublishesMQTT(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	broker, got := fakeBroker(t, &down)
+	config.Current = config.Config{
+		MQTTBroker:   broker,
+		MQTTTopic:    "signage/wallpapers",
+		MQTTUsername: "screen",
+		MQTTPassword: "secret",
+	}
+	t.Cleanup(CloseMQTT)
+
+	// The first event finds the broker refusing connections; it is lost,
+	// but the next one dials again.
+	notify(eventUpload, "lobby")
+	if err := WaitBackground(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(false)
+	notify(eventDelete, "lobby")
+
+	select {
+	case m := <-got:
+		if m.topic != "signage/wallpapers" || m.username != "screen" {
+			t.Errorf("published to %q as %q", m.topic, m.username)
+		}
+		var ev WebhookEvent
+		if err := json.Unmarshal(m.payload, &ev); err != nil {
+			t.Fatalf("payload %q: %v", m.payload, err)
+		}
+		if ev.Event != eventDelete || ev.LinkName != "lobby" {
+			t.Errorf("event = %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message published")
+	}
+	// Let the PUBACK arrive before the cleanup disconnects.
+	if err := WaitBackground(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+}
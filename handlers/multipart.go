@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+
+	"lanpaper/config"
+)
+
+// maxFormValueBytes caps each non-file field of an upload form.
+const maxFormValueBytes = 64 << 10
+
+var (
+	// errTooManyParts rejects upload bodies with more than MaxMultipartParts
+	// parts.
+	errTooManyParts = errors.New("multipart: too many parts")
+	// errValueTooLarge rejects form fields over maxFormValueBytes.
+	errValueTooLarge = errors.New("multipart: form value too large")
+)
+
+// uploadForm is an upload request as read by readUploadForm: its text
+// fields, query parameters included, and the first "file" part.
+type uploadForm struct {
+	values   url.Values
+	file     multipart.File // nil when no "file" part was sent
+	filename string
+	size     int64
+	tmp      *os.File // the file's backing temp file once it outgrew memory
+}
+
+// memFile is an in-memory "file" part.
+type memFile struct{ *bytes.Reader }
+
+func (memFile) Close() error { return nil }
+
+// readUploadForm reads r's multipart body part by part, unlike
+// ParseMultipartForm, so bodies with more than maxParts parts are refused
+// before they cost more than a part header each. The file is kept in
+// memory up to memBytes and spills to a temp file in TempDir beyond that;
+// text fields are limited to maxFormValueBytes. Close the form to remove
+// the temp file.
+func readUploadForm(r *http.Request, memBytes int64, maxParts int) (*uploadForm, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	form := &uploadForm{values: url.Values{}}
+	for parts := 1; ; parts++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			// Like r.Form: body values first, then the query string's.
+			for k, vs := range r.URL.Query() {
+				form.values[k] = append(form.values[k], vs...)
+			}
+			return form, nil
+		}
+		if err != nil {
+			form.Close()
+			return nil, err
+		}
+		if parts > maxParts {
+			form.Close()
+			return nil, errTooManyParts
+		}
+		name := p.FormName()
+		switch {
+		case p.FileName() == "":
+			var b bytes.Buffer
+			n, err := io.Copy(&b, io.LimitReader(p, maxFormValueBytes+1))
+			if err == nil && n > maxFormValueBytes {
+				err = errValueTooLarge
+			}
+			if err != nil {
+				form.Close()
+				return nil, err
+			}
+			form.values.Add(name, b.String())
+		case name == "file" && form.file == nil:
+			if err := form.readFile(p, memBytes); err != nil {
+				form.Close()
+				return nil, err
+			}
+		default:
+			// Surplus files still count against MaxBytesReader.
+			if _, err := io.Copy(io.Discard, p); err != nil {
+				form.Close()
+				return nil, err
+			}
+		}
+	}
+}
+
+// readFile stores part p as the form's file.
+func (f *uploadForm) readFile(p *multipart.Part, memBytes int64) error {
+	f.filename = p.FileName()
+	var b bytes.Buffer
+	n, err := io.Copy(&b, io.LimitReader(p, memBytes+1))
+	if err != nil {
+		return err
+	}
+	if n <= memBytes {
+		f.file, f.size = memFile{bytes.NewReader(b.Bytes())}, n
+		return nil
+	}
+	if f.tmp, err = os.CreateTemp(config.Current.TempDir, "multipart-"); err != nil {
+		return fmt.Errorf("multipart: buffer file: %w", err)
+	}
+	n, err = io.Copy(f.tmp, io.MultiReader(&b, p))
+	if err != nil {
+		return err
+	}
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.file, f.size = f.tmp, n
+	return nil
+}
+
+// Value returns the first value of the text field key, or "".
+func (f *uploadForm) Value(key string) string { return f.values.Get(key) }
+
+// Close removes the temp file backing a large upload, if any.
+func (f *uploadForm) Close() {
+	if f.tmp != nil {
+		_ = f.tmp.Close()
+		_ = os.Remove(f.tmp.Name())
+	}
+}
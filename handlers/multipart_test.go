@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lanpaper/config"
+)
+
+// multipartRequest builds an upload request from fields (name, value
+// pairs) followed by an optional "file" part.
+func multipartRequest(fields []string, file []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i := 0; i+1 < len(fields); i += 2 {
+		_ = mw.WriteField(fields[i], fields[i+1])
+	}
+	if file != nil {
+		fw, _ := mw.CreateFormFile("file", "f.bin")
+		_, _ = fw.Write(file)
+	}
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestReadUploadForm(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Current = config.Config{TempDir: tempDir}
+	t.Cleanup(func() { config.Current = config.Config{} })
+	file := bytes.Repeat([]byte("x"), 1000)
+
+	tests := []struct {
+		name     string
+		fields   []string
+		memBytes int64
+		maxParts int
+		err      error
+		spilled  bool
+	}{
+		{"in memory", []string{"linkName", "a"}, 4096, 4, nil, false},
+		{"spilled", []string{"linkName", "a"}, 100, 4, nil, true},
+		{"parts at limit", []string{"a", "1", "b", "2", "c", "3"}, 4096, 4, nil, false},
+		{"too many parts", []string{"a", "1", "b", "2", "c", "3", "d", "4"}, 4096, 4, errTooManyParts, false},
+		{"value too large", []string{"linkName", strings.Repeat("v", maxFormValueBytes+1)}, 4096, 4, errValueTooLarge, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form, err := readUploadForm(multipartRequest(tt.fields, file), tt.memBytes, tt.maxParts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := form.Value(tt.fields[0]); got != tt.fields[1] {
+				t.Errorf("%s = %q, want %q", tt.fields[0], got, tt.fields[1])
+			}
+			data, _ := io.ReadAll(form.file)
+			if !bytes.Equal(data, file) || form.size != int64(len(file)) || form.filename != "f.bin" {
+				t.Errorf("file = %d bytes (size %d, name %q)", len(data), form.size, form.filename)
+			}
+			if (form.tmp != nil) != tt.spilled {
+				t.Fatalf("spilled = %v, want %v", form.tmp != nil, tt.spilled)
+			}
+			if tt.spilled && filepath.Dir(form.tmp.Name()) != tempDir {
+				t.Errorf("temp file %s is outside TempDir %s", form.tmp.Name(), tempDir)
+			}
+			form.Close()
+			if tt.spilled {
+				if _, err := os.Stat(form.tmp.Name()); !os.IsNotExist(err) {
+					t.Errorf("temp file survived Close: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadRejectsTooManyParts(t *testing.T) {
+	setupUploadDir(t)
+	fields := []string{"linkName", "many"}
+	for range 100 {
+		fields = append(fields, "pad", "x")
+	}
+	rec := httptest.NewRecorder()
+	Upload(rec, multipartRequest(fields, []byte("data")))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Too many form parts") {
+		t.Errorf("status = %d %q, want 400 Too many form parts", rec.Code, rec.Body)
+	}
+}
@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
+	"lanpaper/config"
 	"lanpaper/storage"
 )
 
@@ -14,7 +19,7 @@ func Public(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case path == "/":
-		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		http.Redirect(w, r, config.URLPath("/admin"), http.StatusSeeOther)
 		return
 	case path == "/admin",
 		strings.HasPrefix(path, "/api/"),
@@ -30,19 +35,233 @@ func Public(w http.ResponseWriter, r *http.Request) {
 	}
 	id := cleanPath[1:]
 
+	// Link names can't contain dots, so "/desk.json" is never an image.
+	if name, ok := strings.CutSuffix(id, ".json"); ok {
+		publicMeta(w, r, name)
+		return
+	}
+
+	if hotlinked(r) {
+		log.Printf("Security: refused hotlink of %s from %q", id, r.Header.Get("Referer"))
+		http.Error(w, "Hotlinking not allowed", http.StatusForbidden)
+		return
+	}
+
+	if name, ok := strings.CutSuffix(id, "/resize"); ok {
+		resize(w, r, name)
+		return
+	}
+
 	if !isValidLinkName(id) {
 		http.NotFound(w, r)
 		return
 	}
 
+	// The same URL serves the image or its metadata depending on Accept.
+	w.Header().Add("Vary", "Accept")
+	if prefersJSON(r.Header.Get("Accept")) {
+		publicMeta(w, r, id)
+		return
+	}
+
 	wp, exists := storage.Global.Get(id)
-	if !exists || !wp.HasImage || wp.ImagePath == "" {
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	servePath, mimeType := wp.ImagePath, wp.MIMEType
+	i, ok := albumIndex(r, wp)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if i > 0 {
+		// Device variants only exist for the main image.
+		v := wp.Album[i-1]
+		servePath, mimeType = v.ImagePath, v.MIMEType
+	} else if len(wp.Variants) > 0 {
+		// The response depends on the device hint, so caches must key on it.
+		w.Header().Set("Accept-CH", "Sec-CH-UA-Mobile")
+		w.Header().Add("Vary", "Sec-CH-UA-Mobile")
+		if v := wp.Variants[requestedVariant(r)]; v != nil {
+			servePath, mimeType = v.ImagePath, v.MIMEType
+		}
+	}
+	if servePath == "" || (servePath == wp.ImagePath && !wp.HasImage) {
+		http.NotFound(w, r)
+		return
+	}
+
+	mime := contentType(mimeType)
+	filename := wp.LinkName + "." + mimeType
+
+	// ?poster=1 serves the still frame of a video instead of the video itself.
+	if r.URL.Query().Get("poster") == "1" {
+		if !isVideo(wp.MIMEType) || wp.PosterPath == "" {
+			http.NotFound(w, r)
+			return
+		}
+		servePath, mime, filename = wp.PosterPath, "image/jpeg", wp.LinkName+".jpg"
+	}
+
+	if config.Current.NoIndex {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	// Only the main image's size is recorded, so variants, album images
+	// and posters go without. A HEAD request gets the size without the
+	// file being read.
+	if config.Current.ImageSizeHeaders && servePath == wp.ImagePath && wp.Width > 0 && wp.Height > 0 {
+		w.Header().Set("X-Image-Width", strconv.Itoa(wp.Width))
+		w.Header().Set("X-Image-Height", strconv.Itoa(wp.Height))
+	}
+	serveStoredFile(w, r, servePath, mime, filename)
+}
+
+// PublicMeta is the unauthenticated metadata of a link served at
+// /{linkName}.json, so displays can tell whether the image changed.
+type PublicMeta struct {
+	LinkName  string `json:"linkName"`
+	URL       string `json:"url"`
+	MIMEType  string `json:"mimeType"` // e.g. "image/png"
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	SizeBytes int64  `json:"sizeBytes"`
+	ModTime   int64  `json:"modTime"`
+	AlbumSize int    `json:"albumSize,omitempty"` // images including the main one
+}
+
+// publicMeta serves PublicMeta for linkName's main image; like Public it
+// is 404 for unknown links and links without an image.
+func publicMeta(w http.ResponseWriter, r *http.Request, linkName string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !isValidLinkName(linkName) {
+		http.NotFound(w, r)
+		return
+	}
+	wp, exists := storage.Global.Get(linkName)
+	if !exists || !wp.HasImage {
+		http.NotFound(w, r)
+		return
+	}
+	meta := PublicMeta{
+		LinkName:  wp.LinkName,
+		URL:       config.URLPath("/" + wp.LinkName),
+		MIMEType:  contentType(wp.MIMEType),
+		Width:     wp.Width,
+		Height:    wp.Height,
+		SizeBytes: wp.SizeBytes,
+		ModTime:   wp.ModTime,
+	}
+	if len(wp.Album) > 0 {
+		meta.AlbumSize = len(wp.Album) + 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Displays poll this to decide whether to reload, so never serve it stale.
+	w.Header().Set("Cache-Control", "no-cache")
+	if config.Current.NoIndex {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := writeJSON(w, meta, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding link metadata response: %v", err)
+	}
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above
+// every range that also matches the image (image/*, video/*, */* and
+// specific image or video types). Ties go to the image, so browsers, which
+// send */* among other ranges, always get it.
+func prefersJSON(accept string) bool {
+	jsonQ, mediaQ := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch {
+		case mediaType == "application/json":
+			jsonQ = max(jsonQ, q)
+		case mediaType == "*/*", strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"):
+			mediaQ = max(mediaQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > mediaQ
+}
+
+// contentType is the MIME type of a stored file from its MIMEType field,
+// which holds the extension ("png", "mp4").
+func contentType(ext string) string {
+	switch {
+	case isVideo(ext):
+		return "video/" + ext
+	case ext == "jpg":
+		return "image/jpeg"
+	}
+	return "image/" + ext
+}
+
+// Robots handles GET /robots.txt: config.Current.RobotsTxt if set,
+// otherwise a file that disallows everything under NoIndex and allows
+// everything without it, matching the behaviour before it was served.
+func Robots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	body := config.Current.RobotsTxt
+	if body == "" {
+		body = "User-agent: *\nDisallow:\n"
+		if config.Current.NoIndex {
+			body = "User-agent: *\nDisallow: /\n"
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = io.WriteString(w, body)
+}
+
+// StoredPreview handles GET /api/preview/{linkName}: the thumbnail of a
+// stored image, so clients don't depend on the /static/images/previews/
+// layout. Videos and entries without a preview are 404.
+func StoredPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	linkName := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/preview/"), "/")
+	if !isValidLinkName(linkName) {
 		http.NotFound(w, r)
 		return
 	}
+	wp, exists := storage.Global.Get(linkName)
+	if !exists || !wp.HasImage || isVideo(wp.MIMEType) || wp.PreviewPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+	mime := "image/webp"
+	if filepath.Ext(wp.PreviewPath) == ".jpg" {
+		mime = "image/jpeg"
+	}
+	serveStoredFile(w, r, wp.PreviewPath, mime, linkName+filepath.Ext(wp.PreviewPath))
+}
 
+// serveStoredFile serves a file from disk with Lanpaper's caching headers.
+func serveStoredFile(w http.ResponseWriter, r *http.Request, servePath, mime, filename string) {
 	// Open once for both Stat and ServeContent to avoid a TOCTOU race.
-	f, err := os.Open(wp.ImagePath)
+	f, err := os.Open(servePath)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -55,17 +274,19 @@ func Public(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mime := "image/" + wp.MIMEType
-	if wp.MIMEType == "mp4" || wp.MIMEType == "webm" {
-		mime = "video/" + wp.MIMEType
-	}
-
 	h := w.Header()
 	h.Set("Content-Type", mime)
-	h.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.%s"`, wp.LinkName, wp.MIMEType))
+	h.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
 	// Not immutable: the same URL path can be reassigned to a different image.
 	h.Set("Cache-Control", "public, max-age=60, must-revalidate")
 	h.Set("X-Content-Type-Options", "nosniff")
+	// Last-Modified has one-second resolution, so a file replaced within
+	// the same second would still satisfy If-Range by date and a scrubbing
+	// player would splice bytes from two files. The nanosecond mtime and
+	// size give a validator that changes with every write.
+	if h.Get("ETag") == "" {
+		h.Set("ETag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+	}
 
-	http.ServeContent(w, r, wp.LinkName+"."+wp.MIMEType, fi.ModTime(), f)
+	http.ServeContent(w, r, filename, fi.ModTime(), f)
 }
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+func TestRobots(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{"default allows all", config.Config{}, "Disallow:\n"},
+		{"noindex disallows all", config.Config{NoIndex: true}, "Disallow: /\n"},
+		{"custom", config.Config{NoIndex: true, RobotsTxt: "User-agent: Foo\nDisallow: /x\n"}, "User-agent: Foo\nDisallow: /x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current = tt.cfg
+			rec := httptest.NewRecorder()
+			Robots(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+			if rec.Code != http.StatusOK || !strings.HasSuffix(rec.Body.String(), tt.want) {
+				t.Errorf("status %d, body %q, want suffix %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublicNoIndex(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("img.png", []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage.Global.Set("noidx", &storage.Wallpaper{
+		ID: "noidx", LinkName: "noidx", HasImage: true, MIMEType: "png", ImagePath: filepath.Join(".", "img.png"),
+	})
+	t.Cleanup(func() { storage.Global.Delete("noidx") })
+
+	for _, noIndex := range []bool{false, true} {
+		config.Current = config.Config{NoIndex: noIndex}
+		rec := httptest.NewRecorder()
+		Public(rec, httptest.NewRequest(http.MethodGet, "/noidx", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		if got := rec.Header().Get("X-Robots-Tag") == "noindex"; got != noIndex {
+			t.Errorf("NoIndex=%v: X-Robots-Tag = %q", noIndex, rec.Header().Get("X-Robots-Tag"))
+		}
+	}
+}
+
+func TestPublicMeta(t *testing.T) {
+	config.Current = config.Config{}
+	storage.Global.Set("meta", &storage.Wallpaper{
+		ID: "meta", LinkName: "meta", HasImage: true, MIMEType: "jpg",
+		Width: 1920, Height: 1080, SizeBytes: 4321, ModTime: 1700000000,
+		Album: []*storage.Variant{{MIMEType: "png"}},
+	})
+	storage.Global.Set("meta-empty", &storage.Wallpaper{ID: "meta-empty", LinkName: "meta-empty"})
+	t.Cleanup(func() {
+		storage.Global.Delete("meta")
+		storage.Global.Delete("meta-empty")
+	})
+
+	rec := httptest.NewRecorder()
+	Public(rec, httptest.NewRequest(http.MethodGet, "/meta.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var got PublicMeta
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := PublicMeta{LinkName: "meta", URL: "/meta", MIMEType: "image/jpeg", Width: 1920, Height: 1080,
+		SizeBytes: 4321, ModTime: 1700000000, AlbumSize: 2}
+	if got != want {
+		t.Errorf("meta = %+v, want %+v", got, want)
+	}
+
+	for _, p := range []string{"/meta-empty.json", "/missing.json", "/bad!.json", "/.json"} {
+		rec := httptest.NewRecorder()
+		Public(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", p, rec.Code)
+		}
+	}
+}
+
+func TestPublicAcceptNegotiation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("img.png", []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.Current = config.Config{}
+	storage.Global.Set("neg", &storage.Wallpaper{
+		ID: "neg", LinkName: "neg", HasImage: true, MIMEType: "png", ImagePath: "img.png",
+	})
+	t.Cleanup(func() { storage.Global.Delete("neg") })
+
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "image/png"},
+		{"image/*", "image/png"},
+		{browser, "image/png"},
+		{"application/json", "application/json"},
+		{"application/json, */*;q=0.1", "application/json"},
+		{"application/json, text/plain, */*", "image/png"},
+		{"application/json;q=0.5, image/png", "image/png"},
+		{"application/json;q=0", "image/png"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/neg", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		Public(rec, req)
+		if got := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || got != tt.want {
+			t.Errorf("Accept %q: status %d, Content-Type %q, want %q", tt.accept, rec.Code, got, tt.want)
+		}
+		if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Accept") {
+			t.Errorf("Accept %q: missing Vary: Accept", tt.accept)
+		}
+	}
+}
+
+func TestPublicIfRange(t *testing.T) {
+	t.Chdir(t.TempDir())
+	config.Current = config.Config{}
+	path := filepath.Join(".", "clip.mp4")
+	if err := os.WriteFile(path, []byte("0123456789abcdefghij"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 100, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	storage.Global.Set("clip", &storage.Wallpaper{
+		ID: "clip", LinkName: "clip", HasImage: true, MIMEType: "mp4", ImagePath: path,
+	})
+	t.Cleanup(func() { storage.Global.Delete("clip") })
+
+	get := func(ifRange string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/clip", nil)
+		req.Header.Set("Range", "bytes=0-3")
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+		rec := httptest.NewRecorder()
+		Public(rec, req)
+		return rec
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	lastModified := first.Header().Get("Last-Modified")
+	if first.Code != http.StatusPartialContent || etag == "" || lastModified == "" {
+		t.Fatalf("plain range: status %d, ETag %q, Last-Modified %q", first.Code, etag, lastModified)
+	}
+
+	// The file is replaced within the same second: its Last-Modified is
+	// unchanged, but the ETag is not.
+	if err := os.WriteFile(path, []byte("ABCDEFGHIJ0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	replaced := modTime.Add(500 * time.Millisecond)
+	if err := os.Chtimes(path, replaced, replaced); err != nil {
+		t.Fatal(err)
+	}
+	current := get("").Header().Get("ETag")
+
+	tests := []struct {
+		name    string
+		ifRange string
+		code    int
+		body    string
+	}{
+		{"current etag", current, http.StatusPartialContent, "ABCD"},
+		{"stale etag", etag, http.StatusOK, "ABCDEFGHIJ0123456789"},
+		// A date can't tell the two files apart; clients that have the
+		// ETag send it instead.
+		{"date in the same second", modTime.Format(http.TimeFormat), http.StatusPartialContent, "ABCD"},
+		{"stale date", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, "ABCDEFGHIJ0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.ifRange)
+			if rec.Code != tt.code || rec.Body.String() != tt.body {
+				t.Errorf("If-Range %s: %d %q, want %d %q", tt.ifRange, rec.Code, rec.Body, tt.code, tt.body)
+			}
+		})
+	}
+}
+
+func TestPublicHotlinkProtection(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("img.png", []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage.Global.Set("hotlink", &storage.Wallpaper{
+		ID: "hotlink", LinkName: "hotlink", HasImage: true, MIMEType: "png", ImagePath: filepath.Join(".", "img.png"),
+	})
+	t.Cleanup(func() { storage.Global.Delete("hotlink") })
+	config.Current = config.Config{HotlinkProtection: true, AllowedReferers: []string{"friend.example", "https://blog.example/"}}
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"no referer", nil, http.StatusOK},
+		{"same origin", map[string]string{"Sec-Fetch-Site": "same-origin", "Referer": "http://example.com/admin"}, http.StatusOK},
+		{"own host without fetch metadata", map[string]string{"Referer": "http://example.com/admin"}, http.StatusOK},
+		{"navigation", map[string]string{"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Dest": "document", "Referer": "https://evil.example/"}, http.StatusOK},
+		{"allowed referer", map[string]string{"Sec-Fetch-Site": "cross-site", "Referer": "https://friend.example/page"}, http.StatusOK},
+		{"allowed subdomain", map[string]string{"Referer": "https://www.blog.example/post"}, http.StatusOK},
+		{"disallowed referer", map[string]string{"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Dest": "image", "Referer": "https://evil.example/"}, http.StatusForbidden},
+		{"lookalike host", map[string]string{"Referer": "https://notfriend.example/"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/hotlink", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			Public(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	config.Current.HotlinkProtection = false
+	req := httptest.NewRequest(http.MethodGet, "/hotlink", nil)
+	req.Header.Set("Referer", "https://evil.example/")
+	rec := httptest.NewRecorder()
+	Public(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("protection off: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestPublicImageSizeHeaders(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, name := range []string{"img.png", "mobile.png"} {
+		if err := os.WriteFile(name, []byte("png"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage.Global.Set("sized", &storage.Wallpaper{
+		ID: "sized", LinkName: "sized", HasImage: true, MIMEType: "png", ImagePath: filepath.Join(".", "img.png"),
+		Width: 1920, Height: 1080,
+		Variants: map[string]*storage.Variant{"mobile": {MIMEType: "png", ImagePath: filepath.Join(".", "mobile.png")}},
+	})
+	t.Cleanup(func() { storage.Global.Delete("sized") })
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	tests := []struct {
+		name   string
+		on     bool
+		method string
+		target string
+		w, h   string
+	}{
+		{"HEAD", true, http.MethodHead, "/sized", "1920", "1080"},
+		{"GET", true, http.MethodGet, "/sized", "1920", "1080"},
+		{"variant", true, http.MethodHead, "/sized?variant=mobile", "", ""},
+		{"off", false, http.MethodHead, "/sized", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current = config.Config{ImageSizeHeaders: tt.on}
+			rec := httptest.NewRecorder()
+			Public(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if w, h := rec.Header().Get("X-Image-Width"), rec.Header().Get("X-Image-Height"); w != tt.w || h != tt.h {
+				t.Errorf("size headers = %q x %q, want %q x %q", w, h, tt.w, tt.h)
+			}
+			if tt.method == http.MethodHead && rec.Body.Len() != 0 {
+				t.Errorf("HEAD sent a %d-byte body", rec.Body.Len())
+			}
+		})
+	}
+}
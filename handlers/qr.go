@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lanpaper/qrcode"
+	"lanpaper/storage"
+)
+
+// QR code image sizes in pixels; size is the ?size default.
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 2048
+)
+
+// LinkQR handles GET /api/link/{name}/qr: a QR code of the link's absolute
+// public URL, for printed signage. ?size sets the width in pixels and
+// ?format=svg returns SVG instead of PNG.
+func LinkQR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/link/")
+	path = strings.TrimSuffix(path, "/qr")
+	linkName := strings.Trim(path, "/")
+
+	if linkName == "" || !isValidLinkName(linkName) {
+		http.Error(w, "Invalid link name", http.StatusBadRequest)
+		return
+	}
+	if _, exists := storage.Global.Get(linkName); !exists {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	size := qrDefaultSize
+	if s := q.Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			http.Error(w, "Invalid size (64-2048)", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	format := q.Get("format")
+	if format != "" && format != "png" && format != "svg" {
+		http.Error(w, "Invalid format (png|svg)", http.StatusBadRequest)
+		return
+	}
+
+	target := absoluteURL(r, "/"+linkName)
+	code, err := qrcode.Encode([]byte(target))
+	if err != nil {
+		log.Printf("Error encoding QR code for %s: %v", linkName, err)
+		http.Error(w, "URL too long for a QR code", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		buf.Write(code.SVG(size))
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		if err := png.Encode(&buf, code.Image(size)); err != nil {
+			log.Printf("Error encoding QR code PNG for %s: %v", linkName, err)
+			http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("Error writing QR code response: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+func TestLinkQR(t *testing.T) {
+	config.Current = config.Config{}
+	storage.Global.Set("sign", &storage.Wallpaper{ID: "sign", LinkName: "sign"})
+	t.Cleanup(func() { storage.Global.Delete("sign") })
+
+	tests := []struct {
+		url    string
+		status int
+		ctype  string
+	}{
+		{"/api/link/sign/qr", http.StatusOK, "image/png"},
+		{"/api/link/sign/qr?size=512&format=png", http.StatusOK, "image/png"},
+		{"/api/link/sign/qr?format=svg", http.StatusOK, "image/svg+xml"},
+		{"/api/link/sign/qr?size=10", http.StatusBadRequest, ""},
+		{"/api/link/sign/qr?size=big", http.StatusBadRequest, ""},
+		{"/api/link/sign/qr?format=gif", http.StatusBadRequest, ""},
+		{"/api/link/missing/qr", http.StatusNotFound, ""},
+		{"/api/link/bad!name/qr", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		LinkQR(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.url, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.url, got, tt.ctype)
+		}
+		if tt.ctype == "image/png" {
+			img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Errorf("%s: %v", tt.url, err)
+			} else if want := map[bool]int{true: 512, false: 256}[strings.Contains(tt.url, "512")]; img.Bounds().Dx() != want {
+				t.Errorf("%s: width = %d, want %d", tt.url, img.Bounds().Dx(), want)
+			}
+		}
+	}
+}
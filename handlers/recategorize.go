@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"lanpaper/storage"
+)
+
+// maxRecategorizeBody caps the /api/recategorize request body.
+const maxRecategorizeBody = 1 << 20
+
+// Per-name outcomes of /api/recategorize.
+const (
+	recatUpdated   = "updated"
+	recatUnchanged = "unchanged"
+	recatNotFound  = "not_found"
+	recatInvalid   = "invalid_name"
+)
+
+// RecategorizeResult reports what happened to one link of a bulk
+// recategorize request.
+type RecategorizeResult struct {
+	LinkName string `json:"linkName"`
+	Status   string `json:"status"`
+}
+
+// Recategorize handles POST /api/recategorize: {"linkNames": [...],
+// "category": "..."} moves every listed link to category and saves once.
+// Invalid and unknown names are skipped and reported, not fatal.
+func Recategorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req struct {
+		LinkNames []string `json:"linkNames"`
+		Category  string   `json:"category"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRecategorizeBody)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	cat := req.Category
+	if cat == "" {
+		cat = defaultCategory()
+	} else if !isValidCategory(cat) {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	if len(req.LinkNames) == 0 {
+		http.Error(w, "No link names", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]RecategorizeResult, 0, len(req.LinkNames))
+	updated := 0
+	for _, name := range req.LinkNames {
+		results = append(results, RecategorizeResult{LinkName: name, Status: recategorize(name, cat)})
+		if results[len(results)-1].Status == recatUpdated {
+			updated++
+		}
+	}
+	if updated > 0 {
+		if err := storage.Global.Save(); err != nil {
+			log.Printf("Error saving after recategorize: %v", err)
+		}
+		log.Printf("Recategorized %d links to %s", updated, cat)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, results, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding recategorize response: %v", err)
+	}
+}
+
+// recategorize sets one link's category and returns its result status.
+func recategorize(name, cat string) string {
+	if !isValidLinkName(name) {
+		return recatInvalid
+	}
+	// Checked before locking too: linkLocks is never pruned, so a request
+	// naming unknown links must not add entries to it.
+	if _, exists := storage.Global.Get(name); !exists {
+		return recatNotFound
+	}
+	// Upload stores a copy of the entry it started from; holding the link
+	// lock keeps it from overwriting the new category.
+	unlock := lockLink(name)
+	defer unlock()
+	wp, exists := storage.Global.Get(name)
+	switch {
+	case !exists:
+		return recatNotFound
+	case wp.Category == cat:
+		return recatUnchanged
+	}
+	wp = wp.Clone()
+	wp.Category = cat
+	storage.Global.Set(name, wp)
+	return recatUpdated
+}
+
+// RenameCategory handles POST /api/categories/rename: {"from": "...",
+// "to": "..."} moves every link in category from to category to and saves
+// once. The response is {"changed": n}.
+func RenameCategory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRecategorizeBody)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !isValidCategory(req.From) || !isValidCategory(req.To) {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+
+	changed := 0
+	for _, wp := range storage.Global.GetAllCopy() {
+		if wp != nil && wp.Category == req.From && renameCategory(wp.LinkName, req.From, req.To) {
+			changed++
+		}
+	}
+	if changed > 0 {
+		if err := storage.Global.Save(); err != nil {
+			log.Printf("Error saving after category rename: %v", err)
+			http.Error(w, "Failed to save", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Renamed category %s to %s on %d links", req.From, req.To, changed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, map[string]int{"changed": changed}, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding category rename response: %v", err)
+	}
+}
+
+// renameCategory moves link name from category from to to, and reports
+// whether it did; a link recategorized since the caller looked is left.
+func renameCategory(name, from, to string) bool {
+	unlock := lockLink(name)
+	defer unlock()
+	wp, exists := storage.Global.Get(name)
+	if !exists || wp.Category != from || from == to {
+		return false
+	}
+	wp = wp.Clone()
+	wp.Category = to
+	storage.Global.Set(name, wp)
+	return true
+}
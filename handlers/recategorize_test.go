@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"lanpaper/storage"
+)
+
+func TestRecategorize(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rc-a", "rc-b", "rc-c"} {
+		storage.Global.Set(name, &storage.Wallpaper{ID: name, LinkName: name, Category: "other"})
+		t.Cleanup(func() { storage.Global.Delete(name) })
+	}
+	storage.Global.Set("rc-d", &storage.Wallpaper{ID: "rc-d", LinkName: "rc-d", Category: "desktop"})
+	t.Cleanup(func() { storage.Global.Delete("rc-d") })
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		Recategorize(rec, httptest.NewRequest(http.MethodPost, "/api/recategorize", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post(`{"linkNames": ["rc-a", "rc-b", "rc-d", "rc-missing", "bad name"], "category": "desktop"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var results []RecategorizeResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	want := []RecategorizeResult{
+		{"rc-a", "updated"}, {"rc-b", "updated"}, {"rc-d", "unchanged"},
+		{"rc-missing", "not_found"}, {"bad name", "invalid_name"},
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("results = %v, want %v", results, want)
+	}
+	if _, ok := linkLocks.Load("rc-missing"); ok {
+		t.Error("unknown link name added to linkLocks")
+	}
+
+	rec = httptest.NewRecorder()
+	Wallpapers(rec, httptest.NewRequest(http.MethodGet, "/api/wallpapers?category=desktop", nil))
+	var resp []WallpaperResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, wp := range resp {
+		if strings.HasPrefix(wp.ID, "rc-") {
+			got = append(got, wp.ID)
+		}
+	}
+	slices.Sort(got)
+	if strings.Join(got, ",") != "rc-a,rc-b,rc-d" {
+		t.Errorf("desktop links = %v", got)
+	}
+	if data, err := os.ReadFile("data/wallpapers.json"); err != nil || !strings.Contains(string(data), `"rc-a"`) {
+		t.Errorf("not saved: %v", err)
+	}
+
+	for _, body := range []string{`{"linkNames": ["rc-a"], "category": "nope"}`, `{"linkNames": []}`, `not json`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestRenameCategory(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	cats := map[string]string{"rn-a": "mobile", "rn-b": "mobile", "rn-c": "desktop"}
+	for name, cat := range cats {
+		storage.Global.Set(name, &storage.Wallpaper{ID: name, LinkName: name, Category: cat})
+		t.Cleanup(func() { storage.Global.Delete(name) })
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		RenameCategory(rec, httptest.NewRequest(http.MethodPost, "/api/categories/rename", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post(`{"from": "mobile", "to": "other"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var res struct{ Changed int }
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed < 2 {
+		t.Errorf("changed = %d, want at least the 2 mobile links", res.Changed)
+	}
+	for name, want := range map[string]string{"rn-a": "other", "rn-b": "other", "rn-c": "desktop"} {
+		if wp, _ := storage.Global.Get(name); wp.Category != want {
+			t.Errorf("%s category = %q, want %q", name, wp.Category, want)
+		}
+	}
+	if data, err := os.ReadFile("data/wallpapers.json"); err != nil || !strings.Contains(string(data), `"rn-a"`) {
+		t.Errorf("not saved: %v", err)
+	}
+
+	for _, body := range []string{`{"from": "mobile", "to": "nope"}`, `{"from": "", "to": "other"}`, `not json`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	RenameCategory(rec, httptest.NewRequest(http.MethodGet, "/api/categories/rename", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want 405", rec.Code)
+	}
+}
@@ -3,8 +3,6 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"encoding/json"
-	"image"
 	"log"
 	"net/http"
 	"os"
@@ -14,6 +12,7 @@ import (
 	"sync/atomic"
 
 	"lanpaper/config"
+	"lanpaper/imageproc"
 	"lanpaper/storage"
 )
 
@@ -28,11 +27,12 @@ type RegeneratePreviewsResult struct {
 
 const maxFailedItems = 100
 
-// RegeneratePreviews re-generates WebP thumbnails for every stored image entry.
+// RegeneratePreviews re-generates thumbnails for every stored image entry in the
+// configured preview format.
 // Only POST is accepted. Worker count scales with available CPUs (capped at 8).
 func RegeneratePreviews(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodPost)
 		return
 	}
 
@@ -106,13 +106,13 @@ func RegeneratePreviews(w http.ResponseWriter, r *http.Request) {
 	cleanStalePreviewFiles()
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(RegeneratePreviewsResult{
+	_ = writeJSON(w, RegeneratePreviewsResult{
 		Total:   total,
 		OK:      int(okCount.Load()),
 		Skipped: skipped,
 		Errors:  int(errCount.Load()),
 		Failed:  failed,
-	})
+	}, prettyJSON(r))
 }
 
 func regenPreview(ctx context.Context, wp *storage.Wallpaper) error {
@@ -127,40 +127,62 @@ func regenPreview(ctx context.Context, wp *storage.Wallpaper) error {
 		if len(fileData) == 0 {
 			return nil // nothing to do
 		}
-		img, _, err = image.Decode(bytes.NewReader(fileData))
+		img, _, err = decodeImage(bytes.NewReader(fileData))
 		if err != nil {
 			return err
 		}
 	}
-	previewPath := filepath.Join("static", "images", "previews", wp.LinkName+".webp")
-	thumb := thumbnail(img, config.ThumbnailMaxWidth, config.ThumbnailMaxHeight)
-	if err := saveImage(thumb, "webp", previewPath); err != nil {
+	if h := perceptualHash(img); h != 0 {
+		wp.PHash = h
+	}
+	base := wp.LinkName
+	if wp.Hash != "" {
+		base = wp.Hash
+	}
+	previewPath, _ := previewPathFor(base)
+	thumb := imageproc.Thumbnail(img, config.ThumbnailMaxWidth, config.ThumbnailMaxHeight)
+	if err := imageproc.Save(thumb, previewExt(), previewPath, config.Current.Compression.Quality); err != nil {
 		return err
 	}
+	previewPath, previewURL := keyPreview(previewPath)
+	// Drop the old preview when the configured format or, with
+	// HashedPreviews, its content has changed.
+	if wp.PreviewPath != "" && wp.PreviewPath != previewPath {
+		if err := os.Remove(wp.PreviewPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("regenPreview: remove old preview %s: %v", wp.PreviewPath, err)
+		}
+	}
 	wp.PreviewPath = previewPath
-	wp.Preview = "/static/images/previews/" + wp.LinkName + ".webp"
+	wp.Preview = previewURL
 	storage.Global.Set(wp.LinkName, wp)
 	return nil
 }
 
-// cleanStalePreviewFiles removes .webp files in previews/ with no matching storage entry.
+// cleanStalePreviewFiles removes preview files in previews/ that no storage
+// entry references, including leftovers from a previous preview format.
 func cleanStalePreviewFiles() {
 	previewDir := filepath.Join("static", "images", "previews")
 	entries, err := os.ReadDir(previewDir)
 	if err != nil {
 		return
 	}
+	// Previews are matched by path rather than by name, since hashed
+	// entries don't name their files after the link.
+	inUse := make(map[string]bool)
+	for _, wp := range storage.Global.GetAll() {
+		inUse[wp.PreviewPath] = true
+		inUse[wp.PosterPath] = true
+	}
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
 		ext := filepath.Ext(e.Name())
-		if ext != ".webp" {
+		if ext != ".webp" && ext != ".jpg" {
 			continue
 		}
-		linkName := e.Name()[:len(e.Name())-len(ext)]
-		if _, exists := storage.Global.Get(linkName); !exists {
-			path := filepath.Join(previewDir, e.Name())
+		path := filepath.Join(previewDir, e.Name())
+		if !inUse[path] {
 			if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
 				log.Printf("cleanStalePreviewFiles: remove %s: %v", path, removeErr)
 			}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"lanpaper/storage"
+)
+
+// RepairResult is the JSON response for /api/repair. Each list names the
+// links the step applied to.
+type RepairResult struct {
+	Total    int      `json:"total"`
+	OK       int      `json:"ok"`
+	Relinked []string `json:"relinked,omitempty"` // image found under another extension
+	Missing  []string `json:"missing,omitempty"`  // image gone; the link is now empty
+	Previews []string `json:"previews,omitempty"` // missing preview regenerated
+	Failed   []string `json:"failed,omitempty"`   // preview regeneration failed
+}
+
+// Repair handles POST /api/repair: reconciles the store with the files on
+// disk after files were moved by hand or a migration went wrong. For every
+// link with an image it checks that the image exists, looks for it under
+// the other supported extensions when it doesn't, empties the link when it
+// is gone for good, and regenerates missing previews.
+func Repair(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	exts := slices.Sorted(maps.Values(mimeToExt))
+	var res RepairResult
+	for _, wp := range storage.Global.GetAll() {
+		if wp != nil && wp.HasImage {
+			res.repair(r.Context(), wp.LinkName, exts)
+		}
+	}
+
+	if err := storage.Global.Save(); err != nil {
+		log.Printf("Repair: save storage: %v", err)
+		http.Error(w, "Failed to save repaired store", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Repair: %d checked, %d relinked, %d missing, %d previews regenerated, %d failed",
+		res.Total, len(res.Relinked), len(res.Missing), len(res.Previews), len(res.Failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, res, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding repair response: %v", err)
+	}
+}
+
+// repair checks and fixes one link, recording the outcome in res. It holds
+// the link lock and works on the current entry, so it neither races an
+// upload's files nor writes back a snapshot a concurrent change has
+// replaced.
+func (res *RepairResult) repair(ctx context.Context, name string, exts []string) {
+	unlock := lockLink(name)
+	defer unlock()
+	wp, exists := storage.Global.Get(name)
+	if !exists || !wp.HasImage {
+		return
+	}
+	wp = wp.Clone()
+	res.Total++
+	if !fileExists(wp.ImagePath) {
+		path, ext := findStoredImage(wp, exts)
+		if path == "" {
+			log.Printf("Repair: %s: %s is missing, emptying the link", wp.LinkName, wp.ImagePath)
+			storage.Global.Set(wp.LinkName, emptySlot(wp))
+			res.Missing = append(res.Missing, wp.LinkName)
+			return
+		}
+		log.Printf("Repair: %s: %s is missing, using %s", wp.LinkName, wp.ImagePath, path)
+		wp.MIMEType, wp.ImagePath, wp.ImageURL = ext, path, staticURL(path)
+		storage.Global.Set(wp.LinkName, wp)
+		res.Relinked = append(res.Relinked, wp.LinkName)
+	}
+	if !isVideo(wp.MIMEType) && (wp.PreviewPath == "" || !fileExists(wp.PreviewPath)) {
+		if err := regenPreview(ctx, wp); err != nil {
+			log.Printf("Repair: %s: regenerate preview: %v", wp.LinkName, err)
+			res.Failed = append(res.Failed, wp.LinkName)
+			return
+		}
+		res.Previews = append(res.Previews, wp.LinkName)
+	}
+	res.OK++
+}
+
+// findStoredImage looks for wp's image file under each of exts and returns
+// the first that exists.
+func findStoredImage(wp *storage.Wallpaper, exts []string) (path, ext string) {
+	base := wp.LinkName
+	if wp.Hash != "" {
+		base = wp.Hash
+	}
+	for _, ext := range exts {
+		p := filepath.Join("static", "images", base+"."+ext)
+		if fileExists(p) {
+			return p, ext
+		}
+	}
+	return "", ""
+}
+
+// fileExists reports whether path names an existing regular file.
+func fileExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"lanpaper/storage"
+)
+
+func TestRepair(t *testing.T) {
+	setupUploadDir(t)
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, src.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry := func(name, ext string) *storage.Wallpaper {
+		img := filepath.Join("static", "images", name+"."+ext)
+		preview := filepath.Join("static", "images", "previews", name+".webp")
+		return &storage.Wallpaper{
+			ID: name, LinkName: name, HasImage: true, MIMEType: ext, Category: "desktop",
+			ImageURL: staticURL(img), ImagePath: img,
+			Preview: staticURL(preview), PreviewPath: preview,
+		}
+	}
+
+	// intact: image and preview present.
+	write(filepath.Join("static", "images", "intact.png"))
+	write(filepath.Join("static", "images", "previews", "intact.webp"))
+	// moved: stored as jpg, but the file on disk is a png.
+	write(filepath.Join("static", "images", "moved.png"))
+	write(filepath.Join("static", "images", "previews", "moved.webp"))
+	// nopreview: image present, preview gone.
+	write(filepath.Join("static", "images", "nopreview.png"))
+	// gone: nothing on disk.
+	wps := map[string]*storage.Wallpaper{
+		"intact":    entry("intact", "png"),
+		"moved":     entry("moved", "jpg"),
+		"nopreview": entry("nopreview", "png"),
+		"gone":      entry("gone", "png"),
+		"empty":     {ID: "empty", LinkName: "empty"},
+	}
+	for name, wp := range wps {
+		storage.Global.Set(name, wp)
+		t.Cleanup(func() { storage.Global.Delete(name) })
+	}
+
+	rec := httptest.NewRecorder()
+	Repair(rec, httptest.NewRequest(http.MethodPost, "/api/repair", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var res RepairResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 4 || res.OK != 3 ||
+		!slices.Equal(res.Relinked, []string{"moved"}) ||
+		!slices.Equal(res.Missing, []string{"gone"}) ||
+		!slices.Equal(res.Previews, []string{"nopreview"}) ||
+		len(res.Failed) != 0 {
+		t.Errorf("result = %+v", res)
+	}
+
+	if wp, _ := storage.Global.Get("moved"); wp.MIMEType != "png" || wp.ImageURL != "/static/images/moved.png" {
+		t.Errorf("moved = %s %s, want png at /static/images/moved.png", wp.MIMEType, wp.ImageURL)
+	}
+	if wp, _ := storage.Global.Get("gone"); wp.HasImage || wp.ImageURL != "" || wp.Category != "desktop" {
+		t.Errorf("gone = %+v, want an empty link that kept its category", wp)
+	}
+	wp, _ := storage.Global.Get("nopreview")
+	if _, err := os.Stat(wp.PreviewPath); err != nil {
+		t.Errorf("preview not regenerated: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	Repair(rec, httptest.NewRequest(http.MethodGet, "/api/repair", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", rec.Code)
+	}
+}
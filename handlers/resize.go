@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"lanpaper/config"
+	"lanpaper/imageproc"
+	"lanpaper/storage"
+)
+
+const (
+	// maxResizeDimension bounds w and h of /{linkName}/resize.
+	maxResizeDimension = 4096
+	// maxResizedPerLink caps the cached sizes of one link, so walking
+	// through every w/h combination can't fill the disk.
+	maxResizedPerLink = 32
+	// mediumSize bounds the rendition linked as MediumURL.
+	mediumSize = 800
+)
+
+// resizedDir holds the resize cache of linkName.
+func resizedDir(linkName string) string {
+	return filepath.Join("static", "images", "resized", linkName)
+}
+
+// removeResized drops the resize cache of linkName; call it whenever the
+// link's image is replaced or removed.
+func removeResized(linkName string) {
+	if err := os.RemoveAll(resizedDir(linkName)); err != nil {
+		log.Printf("Error removing resized images of %s: %v", linkName, err)
+	}
+}
+
+// mediumURL returns the resize URL of wp's medium rendition, or "" when
+// it has no image to resize. The ModTime parameter changes the URL with
+// every new image, so browsers don't show a cached older one.
+func mediumURL(wp *storage.Wallpaper) string {
+	if !wp.HasImage || (isVideo(wp.MIMEType) && wp.PosterPath == "") {
+		return ""
+	}
+	return config.URLPath(fmt.Sprintf("/%s/resize?w=%d&h=%d&v=%d", wp.LinkName, mediumSize, mediumSize, wp.ModTime))
+}
+
+// resizeParams are the validated query of a resize request.
+type resizeParams struct {
+	w, h   int    // 0 leaves that side unbounded
+	fit    string // "contain" or "cover"
+	format string // "webp" or "jpg"
+}
+
+func parseResizeParams(r *http.Request) (resizeParams, error) {
+	q := r.URL.Query()
+	p := resizeParams{fit: q.Get("fit"), format: q.Get("fmt")}
+	for _, d := range []struct {
+		name string
+		v    *int
+	}{{"w", &p.w}, {"h", &p.h}} {
+		s := q.Get(d.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxResizeDimension {
+			return p, fmt.Errorf("%s must be 1-%d", d.name, maxResizeDimension)
+		}
+		*d.v = n
+	}
+	if p.w == 0 && p.h == 0 {
+		return p, fmt.Errorf("w or h is required")
+	}
+	switch p.fit {
+	case "":
+		p.fit = "contain"
+	case "contain", "cover":
+	default:
+		return p, fmt.Errorf("fit must be contain or cover")
+	}
+	if p.fit == "cover" && (p.w == 0 || p.h == 0) {
+		// Cropping needs a box; with one side free, contain gives the same.
+		p.fit = "contain"
+	}
+	switch p.format {
+	case "":
+		p.format = previewExt()
+	case "webp":
+	case "jpeg", "jpg":
+		p.format = "jpg"
+	default:
+		return p, fmt.Errorf("fmt must be webp or jpeg")
+	}
+	return p, nil
+}
+
+// resize handles GET /{linkName}/resize?w=&h=&fit=&fmt=: the link's main
+// image (a video's poster) scaled to fit within w×h, or cropped to fill it
+// with fit=cover, never enlarged. Results are cached on disk per image
+// version and parameters.
+func resize(w http.ResponseWriter, r *http.Request, linkName string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !isValidLinkName(linkName) {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := parseResizeParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wp, exists := storage.Global.Get(linkName)
+	if !exists || !wp.HasImage {
+		http.NotFound(w, r)
+		return
+	}
+	src := wp.ImagePath
+	if isVideo(wp.MIMEType) {
+		src = wp.PosterPath
+	}
+	if src == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	version := fmt.Sprintf("%d-", wp.ModTime)
+	name := fmt.Sprintf("%s%dx%d-%s.%s", version, p.w, p.h, p.fit, p.format)
+	cached := filepath.Join(resizedDir(linkName), name)
+	if _, err := os.Stat(cached); err != nil {
+		if err := writeResized(src, cached, version, p); err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("Error resizing %s: %v", linkName, err)
+			http.Error(w, "Resize failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(name, filepath.Ext(name))+`"`)
+	if config.Current.NoIndex {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	serveStoredFile(w, r, cached, contentType(p.format), linkName+"."+p.format)
+}
+
+// writeResized renders src per p into path. Files in the same directory
+// from an older image version are removed, and the oldest entries are
+// evicted once the link has maxResizedPerLink.
+func writeResized(src, path, version string, p resizeParams) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, _, err := decodeImage(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if p.fit == "cover" {
+		img = imageproc.Cover(img, p.w, p.h)
+	} else {
+		img = imageproc.Thumbnail(img, cmp.Or(p.w, maxResizeDimension), cmp.Or(p.h, maxResizeDimension))
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	pruneResized(dir, version)
+	tmp, err := os.CreateTemp(dir, ".resize-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	encodeErr := imageproc.Encode(tmp, img, p.format, config.Current.Compression.Quality)
+	if err := tmp.Close(); encodeErr == nil {
+		encodeErr = err
+	}
+	if encodeErr != nil {
+		return encodeErr
+	}
+	// Concurrent requests for the same size each render it; the last
+	// rename wins and readers see either whole file.
+	return os.Rename(tmp.Name(), path)
+}
+
+// pruneResized removes cached files in dir not of version, then the oldest
+// ones beyond maxResizedPerLink-1 to make room for the next.
+func pruneResized(dir, version string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type file struct {
+		path string
+		mod  int64
+	}
+	var keep []file
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if strings.HasPrefix(e.Name(), ".") {
+			continue // another request's temp file
+		}
+		if !strings.HasPrefix(e.Name(), version) {
+			removeFiles(path)
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			keep = append(keep, file{path, fi.ModTime().UnixNano()})
+		}
+	}
+	if excess := len(keep) - (maxResizedPerLink - 1); excess > 0 {
+		slices.SortFunc(keep, func(a, b file) int { return cmp.Compare(a.mod, b.mod) })
+		for _, f := range keep[:excess] {
+			removeFiles(f.path)
+		}
+	}
+}
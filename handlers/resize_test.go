@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"lanpaper/storage"
+)
+
+func TestResize(t *testing.T) {
+	setupUploadDir(t)
+	storage.Global.Set("dash", &storage.Wallpaper{ID: "dash", LinkName: "dash"})
+	t.Cleanup(func() { storage.Global.Delete("dash") })
+
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 400, 300))); err != nil {
+		t.Fatal(err)
+	}
+	if rec := uploadFile("dash", "d.png", src.Bytes()); rec.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		Public(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		query    string
+		code     int
+		mime     string
+		wantSize image.Point
+	}{
+		{"w=100", http.StatusOK, "image/webp", image.Pt(100, 75)},
+		{"h=150&fmt=jpeg", http.StatusOK, "image/jpeg", image.Pt(200, 150)},
+		{"w=50&h=50&fit=cover&fmt=jpeg", http.StatusOK, "image/jpeg", image.Pt(50, 50)},
+		{"w=1600&h=900", http.StatusOK, "image/webp", image.Pt(400, 300)},
+		{"w=1600&h=900&fit=cover", http.StatusOK, "image/webp", image.Pt(400, 225)},
+		{"", http.StatusBadRequest, "", image.Point{}},
+		{"w=0", http.StatusBadRequest, "", image.Point{}},
+		{"w=5000", http.StatusBadRequest, "", image.Point{}},
+		{"w=100&fit=stretch", http.StatusBadRequest, "", image.Point{}},
+		{"w=100&fmt=gif", http.StatusBadRequest, "", image.Point{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := get("/dash/resize?" + tt.query)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.mime {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.mime)
+			}
+			cfg, _, err := image.DecodeConfig(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := image.Pt(cfg.Width, cfg.Height); got != tt.wantSize {
+				t.Errorf("size = %v, want %v", got, tt.wantSize)
+			}
+		})
+	}
+
+	rec := get("/dash/resize?w=100")
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if rec := get("/dash/resize?w=100", "If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", rec.Code)
+	}
+	if rec := get("/nope/resize?w=100"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown link status = %d, want 404", rec.Code)
+	}
+
+	// A new upload invalidates every cached size.
+	if rec := uploadFile("dash", "d.png", src.Bytes()); rec.Code != http.StatusOK {
+		t.Fatalf("re-upload: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(resizedDir("dash")); !os.IsNotExist(err) {
+		t.Errorf("resize cache survived re-upload: %v", err)
+	}
+
+	// So does a rename, which would otherwise orphan it.
+	if rec := get("/dash/resize?w=100"); rec.Code != http.StatusOK {
+		t.Fatalf("resize after re-upload: %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	Link(rec, httptest.NewRequest(http.MethodPatch, "/api/link/dash", strings.NewReader(`{"newLinkName":"dash-renamed"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename: %d %s", rec.Code, rec.Body)
+	}
+	t.Cleanup(func() { storage.Global.Delete("dash-renamed") })
+	if _, err := os.Stat(resizedDir("dash")); !os.IsNotExist(err) {
+		t.Errorf("resize cache survived rename: %v", err)
+	}
+}
+
+func TestResizeCacheLimit(t *testing.T) {
+	setupUploadDir(t)
+	storage.Global.Set("many", &storage.Wallpaper{ID: "many", LinkName: "many"})
+	t.Cleanup(func() { storage.Global.Delete("many") })
+
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+	if rec := uploadFile("many", "m.png", src.Bytes()); rec.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+	for w := 1; w <= maxResizedPerLink+5; w++ {
+		rec := httptest.NewRecorder()
+		Public(rec, httptest.NewRequest(http.MethodGet, "/many/resize?fmt=jpeg&w="+strconv.Itoa(w), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("w=%d: %d %s", w, rec.Code, rec.Body)
+		}
+	}
+	entries, err := os.ReadDir(resizedDir("many"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > maxResizedPerLink {
+		t.Errorf("%d cached files, want at most %d", len(entries), maxResizedPerLink)
+	}
+}
+
+func TestMediumURL(t *testing.T) {
+	setupUploadDir(t)
+	storage.Global.Set("big", &storage.Wallpaper{ID: "big", LinkName: "big"})
+	t.Cleanup(func() { storage.Global.Delete("big") })
+
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 1600, 1200))); err != nil {
+		t.Fatal(err)
+	}
+	if rec := uploadFile("big", "b.png", src.Bytes()); rec.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+	wp, _ := storage.Global.Get("big")
+	u := toResponse(wp).MediumURL
+	if u == "" {
+		t.Fatal("no mediumUrl")
+	}
+	rec := httptest.NewRecorder()
+	Public(rec, httptest.NewRequest(http.MethodGet, u, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", u, rec.Code, rec.Body)
+	}
+	cfg, _, err := image.DecodeConfig(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 800 || cfg.Height != 600 {
+		t.Errorf("medium is %dx%d, want 800x600", cfg.Width, cfg.Height)
+	}
+
+	if got := toResponse(&storage.Wallpaper{LinkName: "empty"}).MediumURL; got != "" {
+		t.Errorf("mediumUrl of an empty link = %q", got)
+	}
+}
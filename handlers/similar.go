@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"cmp"
+	"image"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"lanpaper/config"
+	"lanpaper/imageproc"
+	"lanpaper/storage"
+)
+
+// defaultSimilarThreshold is the /api/similar distance cutoff when the
+// request names none; past about 10 bits pHash matches are mostly noise.
+const defaultSimilarThreshold = 10
+
+// SimilarLink is one match of /api/similar.
+type SimilarLink struct {
+	LinkName string `json:"linkName"`
+	Distance int    `json:"distance"`
+	Preview  string `json:"preview,omitempty"`
+}
+
+// perceptualHash returns img's perceptual hash, or 0 when PerceptualHash
+// is disabled.
+func perceptualHash(img image.Image) uint64 {
+	if !config.Current.PerceptualHash {
+		return 0
+	}
+	return imageproc.PHash(img)
+}
+
+// Similar handles GET /api/similar/{name}?threshold=N: every other link
+// whose perceptual hash lies within N bits (default 10, 0–64) of name's,
+// closest first. Links uploaded while PerceptualHash was off have no hash
+// until POST /api/regenerate-previews backfills it.
+func Similar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	linkName := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/similar/"), "/")
+	if linkName == "" || !isValidLinkName(linkName) {
+		http.Error(w, "Invalid link name", http.StatusBadRequest)
+		return
+	}
+	threshold := defaultSimilarThreshold
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 64 {
+			http.Error(w, "Invalid threshold", http.StatusBadRequest)
+			return
+		}
+		threshold = n
+	}
+
+	wp, exists := storage.Global.Get(linkName)
+	if !exists {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	if wp.PHash == 0 {
+		http.Error(w, "Link has no perceptual hash", http.StatusUnprocessableEntity)
+		return
+	}
+
+	matches := []SimilarLink{}
+	for _, other := range storage.Global.GetAll() {
+		if other == nil || other.LinkName == linkName || other.PHash == 0 {
+			continue
+		}
+		if d := imageproc.Distance(wp.PHash, other.PHash); d <= threshold {
+			matches = append(matches, SimilarLink{LinkName: other.LinkName, Distance: d, Preview: config.URLPath(other.Preview)})
+		}
+	}
+	slices.SortFunc(matches, func(a, b SimilarLink) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), strings.Compare(a.LinkName, b.LinkName))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, matches, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding similar links response: %v", err)
+	}
+}
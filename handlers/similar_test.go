@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/imageproc"
+	"lanpaper/storage"
+)
+
+func TestSimilar(t *testing.T) {
+	setupUploadDir(t)
+	const base = 0xF0F0_F0F0_F0F0_F0F0
+	for name, h := range map[string]uint64{
+		"orig":     base,
+		"copy":     base ^ 0b111,          // 3 bits off
+		"near":     base ^ 0b1111_1111_11, // 10 bits off
+		"other":    ^uint64(base),         // 64 bits off
+		"unhashed": 0,
+	} {
+		storage.Global.Set(name, &storage.Wallpaper{ID: name, LinkName: name, HasImage: true, PHash: h})
+		t.Cleanup(func() { storage.Global.Delete(name) })
+	}
+
+	tests := []struct {
+		target string
+		code   int
+		want   []string
+	}{
+		{"/api/similar/orig", http.StatusOK, []string{"copy", "near"}},
+		{"/api/similar/orig?threshold=5", http.StatusOK, []string{"copy"}},
+		{"/api/similar/orig?threshold=0", http.StatusOK, []string{}},
+		{"/api/similar/orig?threshold=64", http.StatusOK, []string{"copy", "near", "other"}},
+		{"/api/similar/orig?threshold=65", http.StatusBadRequest, nil},
+		{"/api/similar/orig?threshold=x", http.StatusBadRequest, nil},
+		{"/api/similar/unhashed", http.StatusUnprocessableEntity, nil},
+		{"/api/similar/missing", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Similar(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got []SimilarLink
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, m := range got {
+				names = append(names, m.LinkName)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("matches = %v, want %v", names, tt.want)
+			}
+		})
+	}
+
+	t.Run("base path", func(t *testing.T) {
+		wp, _ := storage.Global.Get("copy")
+		wp = wp.Clone()
+		wp.Preview = "/static/images/previews/copy.webp"
+		storage.Global.Set("copy", wp)
+		config.Current.BasePath = "/wp"
+		defer func() { config.Current.BasePath = "" }()
+
+		rec := httptest.NewRecorder()
+		Similar(rec, httptest.NewRequest(http.MethodGet, "/api/similar/orig?threshold=5", nil))
+		var got []SimilarLink
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Preview != "/wp/static/images/previews/copy.webp" {
+			t.Errorf("matches = %+v, want the preview under the base path", got)
+		}
+	})
+}
+
+func TestUploadPerceptualHash(t *testing.T) {
+	setupUploadDir(t)
+	storage.Global.Set("hashed", &storage.Wallpaper{ID: "hashed", LinkName: "hashed"})
+	t.Cleanup(func() { storage.Global.Delete("hashed") })
+
+	img := image.NewRGBA(image.Rect(0, 0, 96, 64))
+	for y := range 64 {
+		for x := range 96 {
+			img.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 3), uint8((x + y) % 64 * 4), 255})
+		}
+	}
+	var src bytes.Buffer
+	if err := png.Encode(&src, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		on      bool
+		quality int
+	}{
+		{"disabled", false, 85},
+		{"re-encoded", true, 85},
+		{"lossless", true, 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current.PerceptualHash = tt.on
+			config.Current.Compression.Quality = tt.quality
+			if rec := uploadFile("hashed", "h.png", src.Bytes()); rec.Code != http.StatusOK {
+				t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+			}
+			wp, _ := storage.Global.Get("hashed")
+			if want := imageproc.PHash(img); tt.on && wp.PHash != want {
+				t.Errorf("PHash = %x, want %x", wp.PHash, want)
+			} else if !tt.on && wp.PHash != 0 {
+				t.Errorf("PHash = %x with hashing disabled", wp.PHash)
+			}
+		})
+	}
+}
@@ -3,16 +3,13 @@ package handlers
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
-	"image/draw"
-	"image/gif"
 	"image/jpeg"
-	"image/png"
 	"io"
 	"log"
 	"mime/multipart"
@@ -25,21 +22,12 @@ import (
 	"sync"
 	"time"
 
-	"github.com/chai2010/webp"
-	xdraw "golang.org/x/image/draw"
-
-	_ "golang.org/x/image/bmp"
-	_ "golang.org/x/image/tiff"
-
 	"lanpaper/config"
+	"lanpaper/imageproc"
 	"lanpaper/storage"
 	"lanpaper/utils"
 )
 
-func init() {
-	image.RegisterFormat("webp", "RIFF????WEBP", webp.Decode, webp.DecodeConfig)
-}
-
 var uploadSem chan struct{}
 
 func InitUploadSemaphore(n int) {
@@ -49,6 +37,34 @@ func InitUploadSemaphore(n int) {
 	uploadSem = make(chan struct{}, n)
 }
 
+// linkLocks holds a *sync.Mutex per link name so concurrent uploads to the
+// same link (e.g. a double-click) run one after another instead of removing
+// and writing each other's files.
+var linkLocks sync.Map
+
+// lockLink locks linkName's upload mutex and returns the unlock function.
+func lockLink(linkName string) func() {
+	mu, _ := linkLocks.LoadOrStore(linkName, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+var (
+	seqMu   sync.Mutex
+	lastSeq int64
+)
+
+// nextUploadSeq returns the UploadSeq for an upload finishing now, so
+// uploads within the same second still sort newest first. It follows the
+// clock in nanoseconds, which keeps it increasing across restarts, and is
+// only bumped past it when two calls land on the same reading.
+func nextUploadSeq() int64 {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	lastSeq = max(time.Now().UnixNano(), lastSeq+1)
+	return lastSeq
+}
+
 var (
 	transportMu     sync.Mutex
 	cachedTransport *http.Transport
@@ -95,6 +111,9 @@ func getTransport() *http.Transport {
 	return t
 }
 
+// ssrfSafeDialer resolves the host once and dials the address it checked,
+// never the hostname, so a DNS answer that changes between the check and
+// the connection (rebinding) can't reach a private address.
 type ssrfSafeDialer struct{ inner *net.Dialer }
 
 func (d *ssrfSafeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -106,7 +125,15 @@ func (d *ssrfSafeDialer) DialContext(ctx context.Context, network, addr string)
 	if err != nil || len(ips) == 0 {
 		return nil, fmt.Errorf("DNS resolution failed for %s", host)
 	}
-	var safeIP string
+	ip := safeIP(ips)
+	if ip == nil {
+		return nil, errors.New("address is not allowed")
+	}
+	return d.inner.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+}
+
+// safeIP returns the first of ips that is public, or nil if none is.
+func safeIP(ips []net.IPAddr) net.IP {
 	for _, ipAddr := range ips {
 		ip := ipAddr.IP
 		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
@@ -120,14 +147,10 @@ func (d *ssrfSafeDialer) DialContext(ctx context.Context, network, addr string)
 			}
 		}
 		if !isPrivate {
-			safeIP = ip.String()
-			break
+			return ip
 		}
 	}
-	if safeIP == "" {
-		return nil, errors.New("address is not allowed")
-	}
-	return d.inner.DialContext(ctx, network, net.JoinHostPort(safeIP, port))
+	return nil
 }
 
 func copyFile(srcPath, dst string, r io.Reader) error {
@@ -162,24 +185,42 @@ var mimeToExt = map[string]string{
 	"video/webm": "webm",
 }
 
-func normalizeFormat(format string) string {
-	if format == "jpeg" {
-		return "jpg"
+// exifCaptureTime reads the EXIF capture date of an uploaded JPEG or TIFF,
+// from the multipart file when there is one and from data otherwise.
+func exifCaptureTime(ext string, f multipart.File, data []byte) (time.Time, bool) {
+	if ext != "jpg" && ext != "tiff" {
+		return time.Time{}, false
 	}
-	return format
+	if f != nil {
+		return imageproc.CaptureTime(f)
+	}
+	return imageproc.CaptureTime(bytes.NewReader(data))
 }
 
-// storedExt returns the file extension to use for storage.
-// In lossless mode, the original format is preserved.
-// In compression mode, BMP/TIFF are converted to JPEG.
-func storedExt(ext string, lossless bool) string {
-	if lossless {
-		return ext
+// checkDecodedFormat enforces StrictTypeCheck: the format the image decoder
+// reports must match the extension derived from the content sniff. Lenient
+// mode accepts any combination the earlier checks let through.
+func checkDecodedFormat(sniffed, decoded string) error {
+	if !config.Current.StrictTypeCheck {
+		return nil
 	}
-	if ext == "bmp" || ext == "tiff" {
-		return "jpg"
+	if d := imageproc.NormalizeFormat(decoded); d != sniffed {
+		return fmt.Errorf("decoder reports %q but content sniffs as %q", d, sniffed)
+	}
+	return nil
+}
+
+// losslessFormatCheck is checkDecodedFormat for lossless mode, where the
+// file is never fully decoded: only the header is read.
+func losslessFormatCheck(sniffed string, data []byte) error {
+	if !config.Current.StrictTypeCheck {
+		return nil
 	}
-	return ext
+	decoded, err := imageproc.DetectFormat(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return checkDecodedFormat(sniffed, decoded)
 }
 
 // canUseLosslessMode returns true if the file can be copied byte-for-byte
@@ -188,62 +229,173 @@ func canUseLosslessMode(ext string) bool {
 	return config.Current.Compression.Quality == 100 && config.Current.Compression.Scale == 100
 }
 
-// checkImageDimensions returns an error if the image exceeds the allowed
-// dimensions. Unlike before, a decode error is now propagated so callers
-// can decide whether to reject the file.
-func checkImageDimensions(r io.ReadSeeker) error {
+// isTruncated reports whether a decode failed because the data ended early,
+// as with a valid header followed by missing image data. image/jpeg reports
+// a scan that runs out as "short Huffman data" rather than an EOF.
+func isTruncated(err error) bool {
+	var fe jpeg.FormatError
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.As(err, &fe) && fe == "short Huffman data"
+}
+
+func isVideo(ext string) bool { return ext == "mp4" || ext == "webm" }
+
+// orientationCategory maps image dimensions to "desktop" (landscape) or
+// "mobile" (portrait). Square or unknown dimensions return "".
+func orientationCategory(width, height int) string {
+	switch {
+	case width <= 0 || height <= 0:
+		return ""
+	case width > height:
+		return "desktop"
+	case height > width:
+		return "mobile"
+	}
+	return ""
+}
+
+// sourceSize returns the pixel size of the upload source: the decoded img
+// when there is one, otherwise the header of data or f. f is left rewound.
+func sourceSize(img image.Image, data []byte, f multipart.File) (width, height int, err error) {
+	if img != nil {
+		b := img.Bounds()
+		return b.Dx(), b.Dy(), nil
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) == 0 && f != nil {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		defer f.Seek(0, io.SeekStart)
+		r = f
+	}
 	cfg, _, err := image.DecodeConfig(r)
-	if err != nil {
-		return fmt.Errorf("could not read image config: %w", err)
+	return cfg.Width, cfg.Height, err
+}
+
+// toSRGB converts the upload to sRGB when its bytes, data or else f, embed
+// an ICC profile other than sRGB. In lossless mode img is nil and decoded
+// here. It returns nil when there is nothing to convert.
+func toSRGB(img image.Image, data []byte, f multipart.File) (image.Image, error) {
+	var src io.ReaderAt = bytes.NewReader(data)
+	if len(data) == 0 {
+		if f == nil {
+			return nil, nil
+		}
+		src = f
 	}
-	if cfg.Width > config.MaxImageDimension || cfg.Height > config.MaxImageDimension {
-		return fmt.Errorf("image %dx%d exceeds %dx%d limit",
-			cfg.Width, cfg.Height, config.MaxImageDimension, config.MaxImageDimension)
+	profile := imageproc.ICCProfile(src)
+	if profile == nil {
+		return nil, nil
 	}
-	return nil
+	conv, err := imageproc.NewSRGBConverter(profile)
+	if err != nil || conv.IsSRGB() {
+		return nil, err
+	}
+	if img == nil {
+		var r io.Reader = bytes.NewReader(data)
+		if len(data) == 0 {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			r = f
+		}
+		if img, _, err = decodeImage(r); err != nil {
+			return nil, err
+		}
+	}
+	return conv.Convert(img), nil
+}
+
+// uploadCancelled reports whether the upload's client has gone away. If so it
+// logs the cancellation and removes the files written so far.
+func uploadCancelled(ctx context.Context, linkName string, written ...string) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	log.Printf("Upload of %s cancelled: %v", linkName, ctx.Err())
+	removeFiles(written...)
+	return true
 }
 
-func thumbnail(src image.Image, maxW, maxH int) image.Image {
-	b := src.Bounds()
-	scale := min(float64(maxW)/float64(b.Dx()), float64(maxH)/float64(b.Dy()))
-	if scale >= 1 {
-		return src
+// emptySlot returns old without its image: the link, category, creation
+// time and pin survive.
+func emptySlot(old *storage.Wallpaper) *storage.Wallpaper {
+	return &storage.Wallpaper{
+		ID:        old.ID,
+		LinkName:  old.LinkName,
+		Category:  old.Category,
+		CreatedAt: old.CreatedAt,
+		IsPinned:  old.IsPinned,
+		PinnedAt:  old.PinnedAt,
 	}
-	dst := image.NewRGBA(image.Rect(0, 0, int(float64(b.Dx())*scale), int(float64(b.Dy())*scale)))
-	xdraw.BiLinear.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
-	return dst
 }
 
-func scaleImage(src image.Image, scalePercent int) image.Image {
-	if scalePercent >= 100 {
-		return src
+// resetSlot turns old back into an empty slot once its files have been
+// removed but the replacement upload was abandoned, so the store never
+// points at files that no longer exist.
+func resetSlot(old *storage.Wallpaper) {
+	if old == nil || !old.HasImage {
+		return
 	}
-	b := src.Bounds()
-	scale := float64(scalePercent) / 100.0
-	newW := int(float64(b.Dx()) * scale)
-	newH := int(float64(b.Dy()) * scale)
-	if newW < 1 {
-		newW = 1
+	storage.Global.Set(old.LinkName, emptySlot(old))
+	if err := storage.Global.Save(); err != nil {
+		log.Printf("Error saving after abandoned upload: %v", err)
 	}
-	if newH < 1 {
-		newH = 1
+}
+
+// removeLinkPreviews deletes the link-named preview of linkName in either
+// format. The old entry's PreviewPath covers the usual case; this also
+// catches previews it no longer points at, such as one written in the other
+// format or left by an upload that was abandoned, which a video replacing
+// the image would otherwise leave behind.
+func removeLinkPreviews(linkName string) {
+	dir := filepath.Join("static", "images", "previews")
+	for _, ext := range []string{".webp", ".jpg"} {
+		removeUnshared(linkName, filepath.Join(dir, linkName+ext))
+		// Content-keyed names from HashedPreviews; link names have no
+		// dots, so the pattern can't match another link's files.
+		keyed, _ := filepath.Glob(filepath.Join(dir, linkName+".*"+ext))
+		for _, p := range keyed {
+			if IsKeyedPreview(filepath.Base(p)) {
+				removeUnshared(linkName, p)
+			}
+		}
 	}
-	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
-	xdraw.BiLinear.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
-	return dst
 }
 
-func isVideo(ext string) bool { return ext == "mp4" || ext == "webm" }
+// previewFormatFor returns the format to encode a preview at previewPath in,
+// or "" when no preview is wanted.
+func previewFormatFor(previewPath string) string {
+	if previewPath == "" {
+		return ""
+	}
+	return previewExt()
+}
+
+// previewExt returns the file extension used for generated previews.
+func previewExt() string {
+	if config.Current.PreviewFormat == "jpeg" {
+		return "jpg"
+	}
+	return "webp"
+}
 
+// previewPathFor returns the on-disk path and public URL of linkName's preview
+// in the currently configured preview format.
+func previewPathFor(linkName string) (path, url string) {
+	name := linkName + "." + previewExt()
+	return filepath.Join("static", "images", "previews", name), "/static/images/previews/" + name
+}
+
+// Upload handles POST /api/upload.
 func Upload(w http.ResponseWriter, r *http.Request) {
-	select {
-	case uploadSem <- struct{}{}:
-		defer func() { <-uploadSem }()
-	default:
-		http.Error(w, "Too many concurrent uploads", http.StatusTooManyRequests)
+	if !allowMethod(w, r, http.MethodPost) {
 		return
 	}
-
+	// Reject oversized bodies before anything touches r.Body. net/http only
+	// sends "100 Continue" on the first body read, so a client that sent
+	// "Expect: 100-continue" gets the 413 without uploading the file.
 	maxBytes := int64(config.Current.MaxUploadMB) << 20
 	if r.ContentLength > maxBytes {
 		log.Printf("Security: rejected upload with Content-Length %d (max %d)", r.ContentLength, maxBytes)
@@ -251,22 +403,85 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	select {
+	case uploadSem <- struct{}{}:
+		defer func() { <-uploadSem }()
+	default:
+		http.Error(w, "Too many concurrent uploads", http.StatusTooManyRequests)
+		return
+	}
+
+	// ctx is checked before each expensive step so an abandoned upload stops
+	// early instead of decoding and encoding for a client that has gone away.
+	ctx := r.Context()
+
+	// MaxBytesReader caps the whole upload; only the first
+	// MultipartMemoryMB of the file is held in memory, the rest goes to a
+	// temp file.
 	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
-	if err := r.ParseMultipartForm(maxBytes); err != nil {
-		http.Error(w, "File too large", http.StatusBadRequest)
+	memBytes := int64(cmp.Or(config.Current.MultipartMemoryMB, config.DefaultMultipartMemoryMB)) << 20
+	form, formErr := readUploadForm(r, memBytes, cmp.Or(config.Current.MaxMultipartParts, config.DefaultMaxMultipartParts))
+	if formErr != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(formErr, &tooLarge):
+			http.Error(w, "File too large", http.StatusBadRequest)
+		case errors.Is(formErr, errTooManyParts):
+			log.Printf("Security: rejected upload from %s with too many form parts", r.RemoteAddr)
+			http.Error(w, "Too many form parts", http.StatusBadRequest)
+		default:
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+		}
 		return
 	}
+	defer form.Close()
 
-	linkName := r.FormValue("linkName")
+	linkName := form.Value("linkName")
 	if !isValidLinkName(linkName) {
 		http.Error(w, "Invalid link name", http.StatusBadRequest)
 		return
 	}
+	if _, exists := storage.Global.Get(linkName); !exists {
+		http.Error(w, "Link does not exist", http.StatusBadRequest)
+		return
+	}
+	// Held until the new entry is stored, so the oldWp read below and the
+	// files it names stay ours for the whole decode→save→preview→store run.
+	unlock := lockLink(linkName)
+	defer unlock()
 	oldWp, exists := storage.Global.Get(linkName)
 	if !exists {
 		http.Error(w, "Link does not exist", http.StatusBadRequest)
 		return
 	}
+	// An optional variant stores the file as an alternative image for that
+	// device class instead of replacing the link's main image.
+	variant := form.Value("variant")
+	if variant != "" && !validVariants[variant] {
+		http.Error(w, "Invalid variant", http.StatusBadRequest)
+		return
+	}
+	// append=true adds the file to the link's album instead of replacing
+	// its image; the existing images are left untouched.
+	appendMode := form.Value("append") == "true"
+	if appendMode && variant != "" {
+		http.Error(w, "Cannot append a variant", http.StatusBadRequest)
+		return
+	}
+	if appendMode && !oldWp.HasImage {
+		http.Error(w, "Link has no image to append to", http.StatusBadRequest)
+		return
+	}
+	abandon := func() {
+		switch {
+		case appendMode:
+			// Nothing was removed, so there is nothing to undo.
+		case variant == "":
+			resetSlot(oldWp)
+		default:
+			dropVariant(oldWp, variant)
+		}
+	}
 
 	var (
 		img          image.Image
@@ -278,11 +493,20 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		losslessMode bool
 	)
 
-	urlStr := r.FormValue("url")
+	urlStr := form.Value("url")
 	if urlStr != "" {
+		if strings.HasPrefix(urlStr, "http://") && config.Current.RequireHTTPSDownloads {
+			log.Printf("Security: blocked plaintext download: %s", utils.RedactURL(urlStr))
+			http.Error(w, "HTTPS required for downloads", http.StatusBadRequest)
+			return
+		}
 		if strings.HasPrefix(urlStr, "http://") || strings.HasPrefix(urlStr, "https://") {
-			img, ext, fileData, err = downloadImage(r.Context(), urlStr)
+			img, ext, fileData, err = downloadImage(ctx, urlStr)
 		} else {
+			if config.Current.DisableExternalImages {
+				http.Error(w, "External images are disabled", http.StatusForbidden)
+				return
+			}
 			if !utils.IsValidLocalPath(urlStr) {
 				log.Printf("Security: blocked invalid path: %s", urlStr)
 				http.Error(w, "Invalid path", http.StatusBadRequest)
@@ -298,7 +522,7 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 			if isVideo(ext) {
 				video = true
 			} else {
-				img, ext, fileData, err = loadLocalImage(r.Context(), absPath)
+				img, ext, fileData, err = loadLocalImage(ctx, absPath)
 			}
 		}
 		if err != nil {
@@ -307,20 +531,23 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		var header *multipart.FileHeader
-		upFile, header, err = r.FormFile("file")
-		if err != nil {
+		upFile = form.file
+		if upFile == nil {
 			http.Error(w, "No file provided", http.StatusBadRequest)
 			return
 		}
-		defer upFile.Close()
+		if form.size == 0 {
+			log.Printf("Rejected empty file %s for %s", form.filename, linkName)
+			http.Error(w, "Empty file", http.StatusBadRequest)
+			return
+		}
 
-		if header.Size > maxBytes {
-			log.Printf("Security: rejected file %s size %d (max %d)", header.Filename, header.Size, maxBytes)
+		if form.size > maxBytes {
+			log.Printf("Security: rejected file %s size %d (max %d)", form.filename, form.size, maxBytes)
 			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
 			return
 		}
-		safeFilename := utils.SanitizeFilename(header.Filename)
+		safeFilename := utils.SanitizeFilename(form.filename)
 
 		head := make([]byte, 512)
 		n, readErr := upFile.Read(head)
@@ -351,8 +578,12 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if !video {
-			if dimErr := checkImageDimensions(upFile); dimErr != nil {
+			if dimErr := imageproc.CheckDimensions(upFile); dimErr != nil {
 				log.Printf("Security: rejected image %s: %v", safeFilename, dimErr)
+				if isTruncated(dimErr) {
+					http.Error(w, "Image data is truncated", http.StatusBadRequest)
+					return
+				}
 				http.Error(w, "Image dimensions too large", http.StatusBadRequest)
 				return
 			}
@@ -373,14 +604,32 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 					http.Error(w, "Read error", http.StatusInternalServerError)
 					return
 				}
+				if err := losslessFormatCheck(ext, fileData); err != nil {
+					log.Printf("Security: type check failed for %s: %v", safeFilename, err)
+					http.Error(w, "File content does not match file type", http.StatusBadRequest)
+					return
+				}
 			} else {
+				if uploadCancelled(ctx, linkName) {
+					return
+				}
 				log.Printf("Compression mode: %s (quality=%d, scale=%d)",
 					safeFilename, config.Current.Compression.Quality, config.Current.Compression.Scale)
-				if img, _, err = image.Decode(upFile); err != nil {
+				var format string
+				if img, format, err = decodeImage(upFile); err != nil {
 					log.Printf("Image decode error for %s: %v", safeFilename, err)
+					if isTruncated(err) {
+						http.Error(w, "Image data is truncated", http.StatusBadRequest)
+						return
+					}
 					http.Error(w, "Invalid image", http.StatusBadRequest)
 					return
 				}
+				if err := checkDecodedFormat(ext, format); err != nil {
+					log.Printf("Security: type check failed for %s: %v", safeFilename, err)
+					http.Error(w, "File content does not match file type", http.StatusBadRequest)
+					return
+				}
 			}
 		}
 	}
@@ -398,19 +647,83 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if oldWp != nil && oldWp.HasImage {
-		removeFiles(oldWp.ImagePath, oldWp.PreviewPath)
+	if config.Current.NormalizeToSRGB && !video {
+		converted, err := toSRGB(img, fileData, upFile)
+		switch {
+		case err != nil:
+			log.Printf("Warning: keeping the colour profile of the upload for %s: %v", linkName, err)
+		case converted != nil:
+			// Saving re-encodes without the profile, so lossless copies
+			// are re-encoded too.
+			log.Printf("Converted upload for %s to sRGB", linkName)
+			img, losslessMode = converted, false
+		}
+	}
+
+	// Categories may cap resolution below the global MaxImageDimension.
+	if limit := config.Current.CategoryMaxPixels[oldWp.Category]; limit > 0 && !video {
+		width, height, err := sourceSize(img, fileData, upFile)
+		if err != nil {
+			log.Printf("Error reading size of upload for %s: %v", linkName, err)
+			http.Error(w, "Invalid image", http.StatusBadRequest)
+			return
+		}
+		if width*height > limit {
+			log.Printf("Rejected %dx%d image for %s: category %s allows %d pixels", width, height, linkName, oldWp.Category, limit)
+			http.Error(w, fmt.Sprintf("Image is %dx%d (%d pixels); category %q allows at most %d pixels",
+				width, height, width*height, oldWp.Category, limit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Last chance to abort before the previous files are replaced.
+	if uploadCancelled(ctx, linkName) {
+		return
 	}
 
-	saveExt := storedExt(ext, losslessMode)
-	originalPath := filepath.Join("static", "images", linkName+"."+saveExt)
-	previewPath := filepath.Join("static", "images", "previews", linkName+".webp")
+	if variant != "" {
+		if old := oldWp.Variants[variant]; old != nil {
+			removeFiles(old.ImagePath)
+		}
+	} else if !appendMode {
+		if oldWp.HasImage {
+			// A plain upload replaces the whole album.
+			removeUnshared(linkName, oldWp.ImagePath, oldWp.PreviewPath, oldWp.PosterPath)
+			removeFiles(albumPaths(oldWp)...)
+		}
+		removeLinkPreviews(linkName)
+		removeResized(linkName)
+	}
+
+	// bounds is filled in once the image is decoded, or from ffprobe for
+	// videos; duration only for videos.
+	var (
+		bounds   image.Rectangle
+		duration float64
+	)
+	// phash is the main image's perceptual hash; 0 for videos, variants and
+	// album images.
+	var phash uint64
+
+	saveExt := imageproc.StoredExt(ext, losslessMode)
+	fileBase := variantFileBase(linkName, variant)
+	if appendMode {
+		fileBase = albumFileBase(linkName, len(oldWp.Album)+1)
+	}
+	originalPath := filepath.Join("static", "images", fileBase+"."+saveExt)
+	previewPath, previewURL := previewPathFor(linkName)
+	if variant != "" || appendMode {
+		// Previews and posters always show the main image.
+		previewPath, previewURL = "", ""
+	}
+	var posterPath, posterURL string
 
 	if video {
 		var copyErr error
 		if urlStr == "" {
 			if _, err := upFile.Seek(0, io.SeekStart); err != nil {
 				log.Printf("Seek error before video copy: %v", err)
+				abandon()
 				http.Error(w, "Failed to prepare video file", http.StatusInternalServerError)
 				return
 			}
@@ -419,6 +732,7 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 			absPath, _, pathErr := utils.ValidateAndResolvePath(utils.ExternalBaseDir(), urlStr)
 			if pathErr != nil {
 				log.Printf("Security: path validation failed for video %s: %v", urlStr, pathErr)
+				abandon()
 				http.Error(w, "Path outside allowed directory", http.StatusForbidden)
 				return
 			}
@@ -428,10 +742,34 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		}
 		if copyErr != nil {
 			log.Printf("Error saving video %s: %v", originalPath, copyErr)
+			removeFiles(originalPath)
+			abandon()
 			http.Error(w, "Failed to save video", http.StatusInternalServerError)
 			return
 		}
 		previewPath = ""
+		if uploadCancelled(ctx, linkName, originalPath) {
+			abandon()
+			return
+		}
+		info, err := probeVideo(ctx, originalPath)
+		if err != nil {
+			log.Printf("Security: rejected video for %s: %v", linkName, err)
+			removeFiles(originalPath)
+			abandon()
+			http.Error(w, "Video is not playable", http.StatusUnprocessableEntity)
+			return
+		}
+		bounds, duration = image.Rect(0, 0, info.Width, info.Height), info.Duration
+		if variant == "" && !appendMode {
+			posterPath, posterURL, err = posterForUpload(ctx, linkName, originalPath)
+			if err != nil {
+				removeFiles(originalPath)
+				abandon()
+				http.Error(w, "Failed to generate video thumbnail", http.StatusUnprocessableEntity)
+				return
+			}
+		}
 	} else if losslessMode {
 		// Lossless mode: copy file directly without re-encoding
 		var copyErr error
@@ -440,6 +778,7 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		} else if urlStr == "" && upFile != nil {
 			if _, err := upFile.Seek(0, io.SeekStart); err != nil {
 				log.Printf("Seek error before lossless copy: %v", err)
+				abandon()
 				http.Error(w, "Failed to prepare file", http.StatusInternalServerError)
 				return
 			}
@@ -447,83 +786,252 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		}
 		if copyErr != nil {
 			log.Printf("Error saving lossless image %s: %v", originalPath, copyErr)
+			removeFiles(originalPath)
+			abandon()
 			http.Error(w, "Save failed", http.StatusInternalServerError)
 			return
 		}
-		// Generate preview by decoding from the already-read bytes
-		var previewImg image.Image
-		if len(fileData) > 0 {
-			previewImg, _, err = image.Decode(bytes.NewReader(fileData))
-		} else if upFile != nil {
-			if _, seekErr := upFile.Seek(0, io.SeekStart); seekErr == nil {
-				previewImg, _, err = image.Decode(upFile)
-			}
+		if uploadCancelled(ctx, linkName, originalPath) {
+			abandon()
+			return
 		}
-		if err != nil || previewImg == nil {
-			log.Printf("Warning: failed to generate preview for %s: %v", linkName, err)
-			previewPath = ""
-		} else {
-			if err := saveImage(thumbnail(previewImg, config.ThumbnailMaxWidth, config.ThumbnailMaxHeight), "webp", previewPath); err != nil {
-				log.Printf("Error saving preview %s: %v", previewPath, err)
+		// Generate preview by decoding from the already-read bytes.
+		// Variant uploads have no preview of their own.
+		if previewPath != "" {
+			var previewImg image.Image
+			if len(fileData) > 0 {
+				previewImg, _, err = decodeImage(bytes.NewReader(fileData))
+			} else if upFile != nil {
+				if _, seekErr := upFile.Seek(0, io.SeekStart); seekErr == nil {
+					previewImg, _, err = decodeImage(upFile)
+				}
+			}
+			if err != nil || previewImg == nil {
+				log.Printf("Warning: failed to generate preview for %s: %v", linkName, err)
 				previewPath = ""
+			} else {
+				bounds = previewImg.Bounds()
+				phash = perceptualHash(previewImg)
+				thumb := imageproc.Thumbnail(previewImg, config.ThumbnailMaxWidth, config.ThumbnailMaxHeight)
+				if err := imageproc.Save(thumb, previewExt(), previewPath, config.Current.Compression.Quality); err != nil {
+					log.Printf("Error saving preview %s: %v", previewPath, err)
+					previewPath = ""
+				}
 			}
 		}
 	} else {
 		// Normal mode: decode, process, and re-encode
-		img = scaleImage(img, config.Current.Compression.Scale)
-
-		if err := saveImage(img, saveExt, originalPath); err != nil {
-			log.Printf("Error saving image %s: %v", originalPath, err)
+		res, procErr := imageproc.Process(ctx, img, imageproc.Options{
+			Quality:       config.Current.Compression.Quality,
+			Scale:         config.Current.Compression.Scale,
+			Format:        saveExt,
+			Path:          originalPath,
+			PreviewFormat: previewFormatFor(previewPath),
+			PreviewPath:   previewPath,
+			PreviewWidth:  config.ThumbnailMaxWidth,
+			PreviewHeight: config.ThumbnailMaxHeight,
+		})
+		if procErr != nil {
+			if uploadCancelled(ctx, linkName) {
+				abandon()
+				return
+			}
+			log.Printf("Error processing image %s: %v", originalPath, procErr)
+			removeFiles(originalPath, previewPath)
+			abandon()
 			http.Error(w, "Save failed", http.StatusInternalServerError)
 			return
 		}
-		if err := saveImage(thumbnail(img, config.ThumbnailMaxWidth, config.ThumbnailMaxHeight), "webp", previewPath); err != nil {
-			log.Printf("Error saving preview %s: %v", previewPath, err)
-			removeFiles(originalPath, previewPath)
-			http.Error(w, "Preview generation failed", http.StatusInternalServerError)
+		bounds = res.Bounds
+		if variant == "" && !appendMode {
+			phash = perceptualHash(img)
+		}
+	}
+
+	if previewPath != "" {
+		previewPath, previewURL = keyPreview(previewPath)
+	}
+	if uploadCancelled(ctx, linkName, originalPath, previewPath, posterPath) {
+		abandon()
+		return
+	}
+
+	// With hashed storage the files were written under the link name and
+	// now move to their content-hash names. Variants and album images keep
+	// link-based names.
+	var hash string
+	if config.Current.HashedStorage && variant == "" && !appendMode {
+		hash, err = fileHash(originalPath)
+		for _, p := range []*string{&originalPath, &previewPath, &posterPath} {
+			if err == nil {
+				*p, err = rehome(*p, linkName, hash)
+			}
+		}
+		if err != nil {
+			log.Printf("Error moving %s to hashed storage: %v", linkName, err)
+			removeFiles(originalPath, previewPath, posterPath)
+			abandon()
+			http.Error(w, "Save failed", http.StatusInternalServerError)
 			return
 		}
+		previewURL, posterURL = staticURL(previewPath), staticURL(posterPath)
 	}
 
 	fi, err := os.Stat(originalPath)
 	if err != nil {
 		log.Printf("Error stating %s: %v", originalPath, err)
+		removeUnshared(linkName, originalPath, previewPath, posterPath)
+		abandon()
 		http.Error(w, "Failed to stat file", http.StatusInternalServerError)
 		return
 	}
+	// Every accepted upload has content, so an empty file means the write
+	// came up short, e.g. on a full disk.
+	if fi.Size() == 0 {
+		log.Printf("Error: %s was stored empty — discarding the upload", originalPath)
+		removeUnshared(linkName, originalPath, previewPath, posterPath)
+		abandon()
+		http.Error(w, "Stored file is empty", http.StatusInternalServerError)
+		return
+	}
+
+	if variant != "" {
+		wp := oldWp.Clone()
+		if wp.Variants == nil {
+			wp.Variants = make(map[string]*storage.Variant)
+		}
+		wp.Variants[variant] = &storage.Variant{
+			ImageURL:  "/static/images/" + fileBase + "." + saveExt,
+			MIMEType:  saveExt,
+			SizeBytes: fi.Size(),
+			ModTime:   time.Now().Unix(),
+			ImagePath: originalPath,
+		}
+		storage.Global.Set(linkName, wp)
+		if err := storage.Global.Save(); err != nil {
+			log.Printf("Error saving after variant upload: %v — rolling back", err)
+			dropVariant(oldWp, variant)
+			removeFiles(originalPath)
+			http.Error(w, "Failed to persist upload", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Uploaded %s variant: %s (%s, %d KB)", variant, linkName, saveExt, fi.Size()/1024)
+		notify(eventUpload, linkName)
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeJSON(w, uploadResponse(wp), prettyJSON(r)); err != nil {
+			log.Printf("Error encoding upload response: %v", err)
+		}
+		return
+	}
+
+	if appendMode {
+		wp := oldWp.Clone()
+		wp.Album = append(wp.Album, &storage.Variant{
+			ImageURL:  "/static/images/" + fileBase + "." + saveExt,
+			MIMEType:  saveExt,
+			SizeBytes: fi.Size(),
+			ModTime:   time.Now().Unix(),
+			ImagePath: originalPath,
+		})
+		storage.Global.Set(linkName, wp)
+		if err := storage.Global.Save(); err != nil {
+			log.Printf("Error saving after album upload: %v — rolling back", err)
+			storage.Global.Set(linkName, oldWp)
+			removeFiles(originalPath)
+			http.Error(w, "Failed to persist upload", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Appended to album: %s #%d (%s, %d KB)", linkName, len(wp.Album), saveExt, fi.Size()/1024)
+		notify(eventUpload, linkName)
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeJSON(w, uploadResponse(wp), prettyJSON(r)); err != nil {
+			log.Printf("Error encoding upload response: %v", err)
+		}
+		return
+	}
 
 	createdAt := time.Now().Unix()
+	category := ""
 	if oldWp != nil {
 		createdAt = oldWp.CreatedAt
+		category = oldWp.Category
 	}
-	previewURL := ""
-	if previewPath != "" {
-		previewURL = "/static/images/previews/" + linkName + ".webp"
+	if config.Current.UseExifDate {
+		if t, ok := exifCaptureTime(ext, upFile, fileData); ok {
+			createdAt = t.Unix()
+		}
+	}
+	// Categories the server assigned itself may be replaced; ones the user
+	// chose may not.
+	assigned := func() bool {
+		return category == "" || category == defaultCategory() || category == "image" || category == "video"
+	}
+	if config.Current.AutoCategorize && !video && assigned() {
+		if c := orientationCategory(bounds.Dx(), bounds.Dy()); c != "" {
+			category = c
+		}
+	}
+	if config.Current.InferCategory && assigned() {
+		category = "image"
+		if video {
+			category = "video"
+		}
+	}
+	if previewPath == "" {
+		previewURL = ""
 	}
 
 	wp := &storage.Wallpaper{
-		ID:          linkName,
-		LinkName:    linkName,
-		ImageURL:    "/static/images/" + linkName + "." + saveExt,
-		Preview:     previewURL,
-		HasImage:    true,
-		MIMEType:    saveExt,
-		SizeBytes:   fi.Size(),
-		ModTime:     fi.ModTime().Unix(),
-		CreatedAt:   createdAt,
-		ImagePath:   originalPath,
-		PreviewPath: previewPath,
+		ID:              linkName,
+		LinkName:        linkName,
+		Category:        category,
+		ImageURL:        staticURL(originalPath),
+		Preview:         previewURL,
+		Poster:          posterURL,
+		HasImage:        true,
+		MIMEType:        saveExt,
+		SizeBytes:       fi.Size(),
+		ModTime:         time.Now().Unix(),
+		UploadSeq:       nextUploadSeq(),
+		CreatedAt:       createdAt,
+		Width:           bounds.Dx(),
+		Height:          bounds.Dy(),
+		DurationSeconds: duration,
+		Hash:            hash,
+		PHash:           phash,
+		ImagePath:       originalPath,
+		PreviewPath:     previewPath,
+		PosterPath:      posterPath,
+	}
+	if oldWp != nil {
+		wp.Variants = oldWp.Variants
 	}
 	storage.Global.Set(linkName, wp)
 	if err := storage.Global.Save(); err != nil {
 		log.Printf("Error saving after upload: %v — rolling back", err)
-		storage.Global.Delete(linkName)
-		removeFiles(originalPath, previewPath)
+		// Put the link back rather than deleting it. The old image files
+		// are already gone, so it becomes an empty slot, keeping the
+		// variants, whose files were left alone.
+		slot := oldWp
+		if oldWp.HasImage {
+			slot = emptySlot(oldWp)
+			slot.Variants = oldWp.Variants
+		}
+		storage.Global.Set(linkName, slot)
+		if err := storage.Global.Save(); err != nil {
+			log.Printf("Error saving after abandoned upload: %v", err)
+		}
+		removeUnshared(linkName, originalPath, previewPath, posterPath)
 		http.Error(w, "Failed to persist upload", http.StatusInternalServerError)
 		return
 	}
 	if config.Current.MaxImages > 0 {
-		go storage.PruneOldImages(config.Current.MaxImages)
+		maxImages := config.Current.MaxImages
+		goBackground(func() {
+			for _, id := range storage.PruneOldImages(maxImages) {
+				notify(eventPrune, id)
+			}
+		})
 	}
 
 	mode := "compressed"
@@ -533,42 +1041,27 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		mode = "video"
 	}
 	log.Printf("Uploaded: %s (%s, %d KB, %s)", linkName, saveExt, fi.Size()/1024, mode)
+	notify(eventUpload, linkName)
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(wp); err != nil {
+	if err := writeJSON(w, uploadResponse(wp), prettyJSON(r)); err != nil {
 		log.Printf("Error encoding upload response: %v", err)
 	}
 }
 
-func saveImage(img image.Image, format, path string) error {
-	out, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("create: %w", err)
+// uploadResponse returns a copy of wp with its URLs under BasePath, for the
+// upload response body.
+func uploadResponse(wp *storage.Wallpaper) *storage.Wallpaper {
+	out := wp.Clone()
+	out.ImageURL = config.URLPath(out.ImageURL)
+	out.Preview = config.URLPath(out.Preview)
+	out.Poster = config.URLPath(out.Poster)
+	for _, v := range out.Variants {
+		v.ImageURL = config.URLPath(v.ImageURL)
 	}
-	encodeErr := encodeImage(out, img, format)
-	closeErr := out.Close()
-	if encodeErr != nil {
-		if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
-			log.Printf("Error removing partial file %s: %v", path, removeErr)
-		}
-		return encodeErr
-	}
-	return closeErr
-}
-
-func encodeImage(w io.Writer, img image.Image, format string) error {
-	quality := config.Current.Compression.Quality
-	switch format {
-	case "jpg", "jpeg":
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
-	case "png":
-		return png.Encode(w, img)
-	case "gif":
-		return gif.Encode(w, img, &gif.Options{NumColors: config.GIFColors})
-	case "webp":
-		return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
-	default:
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	for _, v := range out.Album {
+		v.ImageURL = config.URLPath(v.ImageURL)
 	}
+	return out
 }
 
 func loadLocalImage(ctx context.Context, path string) (image.Image, string, []byte, error) {
@@ -588,7 +1081,7 @@ func loadLocalImage(ctx context.Context, path string) (image.Image, string, []by
 	if _, err := f.Seek(0, io.SeekStart); err != nil {
 		return nil, "", nil, fmt.Errorf("seek: %w", err)
 	}
-	if dimErr := checkImageDimensions(f); dimErr != nil {
+	if dimErr := imageproc.CheckDimensions(f); dimErr != nil {
 		log.Printf("Security: rejected local image %s: %v", path, dimErr)
 		return nil, "", nil, errors.New("image dimensions too large")
 	}
@@ -611,16 +1104,36 @@ func loadLocalImage(ctx context.Context, path string) (image.Image, string, []by
 	}
 
 	if canUseLosslessMode(ext) {
+		if err := losslessFormatCheck(ext, fileData); err != nil {
+			log.Printf("Security: type check failed for %s: %v", path, err)
+			return nil, "", nil, errors.New("file content does not match file type")
+		}
 		log.Printf("Lossless mode: local file %s", path)
 		return nil, ext, fileData, nil
 	}
 
-	img, format, err := image.Decode(bytes.NewReader(fileData))
+	img, format, err := decodeImage(bytes.NewReader(fileData))
 	if err != nil {
 		log.Printf("Image decode error for %s: %v", path, err)
 		return nil, "", nil, errors.New("invalid or unsupported image format")
 	}
-	return img, normalizeFormat(format), fileData, nil
+	if err := checkDecodedFormat(ext, format); err != nil {
+		log.Printf("Security: type check failed for %s: %v", path, err)
+		return nil, "", nil, errors.New("file content does not match file type")
+	}
+	return img, imageproc.NormalizeFormat(format), fileData, nil
+}
+
+// checkRedirect keeps the client's default limit of 10 redirects and, with
+// RequireHTTPSDownloads, refuses to follow one to plaintext http.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if config.Current.RequireHTTPSDownloads && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to %s blocked: HTTPS required", req.URL.Scheme)
+	}
+	return nil
 }
 
 func downloadImage(ctx context.Context, urlStr string) (image.Image, string, []byte, error) {
@@ -639,7 +1152,8 @@ func downloadImage(ctx context.Context, urlStr string) (image.Image, string, []b
 	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Lanpaper/1.0)")
 	req.Header.Set("Accept", "image/*,*/*;q=0.8")
 
-	resp, err := (&http.Client{Transport: getTransport()}).Do(req)
+	client := &http.Client{Transport: getTransport(), CheckRedirect: checkRedirect}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, "", nil, errors.New("network error")
 	}
@@ -664,8 +1178,8 @@ func downloadImage(ctx context.Context, urlStr string) (image.Image, string, []b
 		return nil, "", nil, errors.New("file too large")
 	}
 
-	if dimErr := checkImageDimensions(bytes.NewReader(buf)); dimErr != nil {
-		log.Printf("Security: rejected remote image %s: %v", urlStr, dimErr)
+	if dimErr := imageproc.CheckDimensions(bytes.NewReader(buf)); dimErr != nil {
+		log.Printf("Security: rejected remote image %s: %v", utils.RedactURL(urlStr), dimErr)
 		return nil, "", nil, errors.New("image dimensions too large")
 	}
 
@@ -676,13 +1190,21 @@ func downloadImage(ctx context.Context, urlStr string) (image.Image, string, []b
 	}
 
 	if canUseLosslessMode(ext) {
-		log.Printf("Lossless mode: downloaded %s", urlStr)
+		if err := losslessFormatCheck(ext, buf); err != nil {
+			log.Printf("Security: type check failed for %s: %v", utils.RedactURL(urlStr), err)
+			return nil, "", nil, errors.New("file content does not match file type")
+		}
+		log.Printf("Lossless mode: downloaded %s", utils.RedactURL(urlStr))
 		return nil, ext, buf, nil
 	}
 
-	img, format, err := image.Decode(bytes.NewReader(buf))
+	img, format, err := decodeImage(bytes.NewReader(buf))
 	if err != nil {
 		return nil, "", nil, errors.New("invalid or unsupported image format")
 	}
-	return img, normalizeFormat(format), buf, nil
+	if err := checkDecodedFormat(ext, format); err != nil {
+		log.Printf("Security: type check failed for %s: %v", utils.RedactURL(urlStr), err)
+		return nil, "", nil, errors.New("file content does not match file type")
+	}
+	return img, imageproc.NormalizeFormat(format), buf, nil
 }
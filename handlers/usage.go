@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+	"lanpaper/utils"
+)
+
+// usageCacheTTL is how long a computed /api/usage result is reused. The
+// external directory is stat'ed file by file, which is slow on large mounts.
+const usageCacheTTL = 30 * time.Second
+
+// UsageTotals counts stored files and their bytes.
+type UsageTotals struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (u *UsageTotals) add(size int64) {
+	u.Files++
+	u.Bytes += size
+}
+
+// UsageResult is the JSON response for /api/usage. Variants and album
+// images count towards their link's category. External is the external
+// image directory, nil when it is disabled or can't be read.
+type UsageResult struct {
+	Total      UsageTotals            `json:"total"`
+	Categories map[string]UsageTotals `json:"categories"`
+	MIMETypes  map[string]UsageTotals `json:"mimeTypes"`
+	External   *UsageTotals           `json:"external,omitempty"`
+}
+
+var usageCache struct {
+	mu  sync.Mutex
+	res *UsageResult
+	at  time.Time
+}
+
+// Usage handles GET /api/usage: disk usage of the stored images grouped by
+// category and by MIME type, plus the size of the external image directory.
+// Results are cached for usageCacheTTL; ?refresh=1 recomputes them.
+func Usage(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	c := &usageCache
+	c.mu.Lock()
+	if c.res == nil || time.Since(c.at) >= usageCacheTTL || r.URL.Query().Get("refresh") == "1" {
+		c.res, c.at = computeUsage(), time.Now()
+	}
+	res := c.res
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, res, prettyJSON(r)); err != nil {
+		log.Printf("Error encoding usage response: %v", err)
+	}
+}
+
+// computeUsage sums the recorded sizes of every stored file.
+func computeUsage() *UsageResult {
+	res := &UsageResult{
+		Categories: map[string]UsageTotals{},
+		MIMETypes:  map[string]UsageTotals{},
+	}
+	count := func(category, mime string, size int64) {
+		res.Total.add(size)
+		c := res.Categories[category]
+		c.add(size)
+		res.Categories[category] = c
+		m := res.MIMETypes[mime]
+		m.add(size)
+		res.MIMETypes[mime] = m
+	}
+	for _, wp := range storage.Global.GetAllCopy() {
+		if wp == nil || !wp.HasImage {
+			continue
+		}
+		count(wp.Category, wp.MIMEType, wp.SizeBytes)
+		for _, v := range wp.Variants {
+			count(wp.Category, v.MIMEType, v.SizeBytes)
+		}
+		for _, v := range wp.Album {
+			count(wp.Category, v.MIMEType, v.SizeBytes)
+		}
+	}
+	if !config.Current.DisableExternalImages {
+		res.External = externalUsage()
+	}
+	return res
+}
+
+// externalUsage totals the files of the (cached) external image listing.
+func externalUsage() *UsageTotals {
+	files, err := cachedExternalImages(false)
+	if err != nil {
+		return nil
+	}
+	root := utils.ExternalBaseDir()
+	var u UsageTotals
+	for _, rel := range files {
+		if fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err == nil {
+			u.add(fi.Size())
+		}
+	}
+	return &u
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+func TestUsage(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"a.jpg": 100, "sub/b.png": 50} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Current = config.Config{ExternalImageDir: dir, MaxWalkDepth: 3}
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	wps := []*storage.Wallpaper{
+		{ID: "us-a", LinkName: "us-a", Category: "desktop", HasImage: true, MIMEType: "jpg", SizeBytes: 1000,
+			Variants: map[string]*storage.Variant{"mobile": {MIMEType: "webp", SizeBytes: 300}}},
+		{ID: "us-b", LinkName: "us-b", Category: "desktop", HasImage: true, MIMEType: "png", SizeBytes: 2000},
+		{ID: "us-c", LinkName: "us-c", Category: "work", HasImage: true, MIMEType: "jpg", SizeBytes: 500,
+			Album: []*storage.Variant{{MIMEType: "jpg", SizeBytes: 200}}},
+		{ID: "us-d", LinkName: "us-d", Category: "work"},
+	}
+	for _, wp := range wps {
+		storage.Global.Set(wp.LinkName, wp)
+		t.Cleanup(func() { storage.Global.Delete(wp.LinkName) })
+	}
+
+	get := func() UsageResult {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		Usage(rec, httptest.NewRequest(http.MethodGet, "/api/usage?refresh=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var res UsageResult
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := get()
+	if res.Total != (UsageTotals{5, 4000}) {
+		t.Errorf("total = %+v, want 5 files, 4000 bytes", res.Total)
+	}
+	for cat, want := range map[string]UsageTotals{"desktop": {3, 3300}, "work": {2, 700}} {
+		if got := res.Categories[cat]; got != want {
+			t.Errorf("category %s = %+v, want %+v", cat, got, want)
+		}
+	}
+	for mime, want := range map[string]UsageTotals{"jpg": {3, 1700}, "png": {1, 2000}, "webp": {1, 300}} {
+		if got := res.MIMETypes[mime]; got != want {
+			t.Errorf("MIME %s = %+v, want %+v", mime, got, want)
+		}
+	}
+	if res.External == nil || *res.External != (UsageTotals{2, 150}) {
+		t.Errorf("external = %+v, want 2 files, 150 bytes", res.External)
+	}
+
+	config.Current.DisableExternalImages = true
+	if res := get(); res.External != nil {
+		t.Errorf("external = %+v with external images disabled, want none", res.External)
+	}
+
+	rec := httptest.NewRecorder()
+	Usage(rec, httptest.NewRequest(http.MethodPost, "/api/usage", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
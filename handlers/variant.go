@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+// validVariants are the device classes a link can hold alternative images for.
+var validVariants = map[string]bool{"mobile": true, "desktop": true}
+
+// requestedVariant picks the variant a client asked for: an explicit
+// ?variant= wins, otherwise the Sec-CH-UA-Mobile client hint is used.
+// Returns "" when the client expressed no preference.
+func requestedVariant(r *http.Request) string {
+	if v := r.URL.Query().Get("variant"); validVariants[v] {
+		return v
+	}
+	switch r.Header.Get("Sec-CH-UA-Mobile") {
+	case "?1":
+		return "mobile"
+	case "?0":
+		return "desktop"
+	}
+	return ""
+}
+
+// variantFileBase returns the file name stem for a variant image. '@' is not
+// allowed in link names, so variant files never collide with other links.
+func variantFileBase(linkName, variant string) string {
+	if variant == "" {
+		return linkName
+	}
+	return linkName + "@" + variant
+}
+
+// variantPaths returns the on-disk paths of all of wp's variant images.
+func variantPaths(wp *storage.Wallpaper) []string {
+	var paths []string
+	for _, v := range wp.Variants {
+		if v != nil && v.ImagePath != "" {
+			paths = append(paths, v.ImagePath)
+		}
+	}
+	return paths
+}
+
+// dropVariant removes variant from old's entry after an abandoned variant
+// upload already deleted its previous file.
+func dropVariant(old *storage.Wallpaper, variant string) {
+	if old == nil || old.Variants[variant] == nil {
+		return
+	}
+	wp := old.Clone()
+	delete(wp.Variants, variant)
+	storage.Global.Set(wp.LinkName, wp)
+	if err := storage.Global.Save(); err != nil {
+		log.Printf("Error saving after abandoned variant upload: %v", err)
+	}
+}
+
+// variantURLs maps each of wp's variants to its image URL for API responses.
+func variantURLs(wp *storage.Wallpaper) map[string]string {
+	if len(wp.Variants) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(wp.Variants))
+	for name, v := range wp.Variants {
+		if v != nil {
+			out[name] = config.URLPath(v.ImageURL)
+		}
+	}
+	return out
+}
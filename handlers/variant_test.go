@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestedVariant(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		hint   string
+		want   string
+	}{
+		{"no preference", "/wall", "", ""},
+		{"mobile hint", "/wall", "?1", "mobile"},
+		{"desktop hint", "/wall", "?0", "desktop"},
+		{"param wins over hint", "/wall?variant=desktop", "?1", "desktop"},
+		{"unknown param falls back to hint", "/wall?variant=tablet", "?1", "mobile"},
+		{"unknown param", "/wall?variant=tablet", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.hint != "" {
+				r.Header.Set("Sec-CH-UA-Mobile", tt.hint)
+			}
+			if got := requestedVariant(r); got != tt.want {
+				t.Errorf("requestedVariant() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
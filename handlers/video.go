@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"lanpaper/config"
+	"lanpaper/imageproc"
+)
+
+// runFFmpeg executes ffmpeg with args. Tests replace it with a stub.
+var runFFmpeg = func(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, out)
+	}
+	return nil
+}
+
+// ffmpegAvailable reports whether ffmpeg is on PATH. Looked up once.
+var ffmpegAvailable = sync.OnceValue(func() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+})
+
+var errNoFFmpeg = errors.New("ffmpeg not found on PATH")
+
+// runFFprobe executes ffprobe with args and returns its standard output.
+// Tests replace it with a stub.
+var runFFprobe = func(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("ffprobe: %w: %s", err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+	return out, nil
+}
+
+// ffprobeAvailable reports whether ffprobe is on PATH. Looked up once.
+var ffprobeAvailable = sync.OnceValue(func() bool {
+	_, err := exec.LookPath("ffprobe")
+	return err == nil
+})
+
+// ffprobeOutput is the part of ffprobe's JSON output probeVideo reads.
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+type ffprobeStream struct {
+	CodecType string `json:"codec_type"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+// videoInfo is what probeVideo learns about a video.
+type videoInfo struct {
+	Duration      float64 // seconds
+	Width, Height int     // of the first video stream
+}
+
+// errUnplayableVideo is returned by probeVideo for files ffprobe finds no
+// video stream or duration in.
+var errUnplayableVideo = errors.New("no playable video stream")
+
+// probeVideo checks that videoPath, which has already passed the magic-byte
+// check, holds at least one video stream and a readable duration, and
+// returns its length and resolution. Like posters it runs only with
+// VideoThumbnails on; it returns a zero videoInfo and no error when that is
+// off or ffprobe isn't installed.
+func probeVideo(ctx context.Context, videoPath string) (videoInfo, error) {
+	if !config.Current.VideoThumbnails || !ffprobeAvailable() {
+		return videoInfo{}, nil
+	}
+	out, err := runFFprobe(ctx, "-v", "error",
+		"-show_entries", "stream=codec_type,width,height:format=duration", "-of", "json", videoPath)
+	if err != nil {
+		return videoInfo{}, fmt.Errorf("%w: %v", errUnplayableVideo, err)
+	}
+	var probe ffprobeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return videoInfo{}, fmt.Errorf("%w: ffprobe output: %v", errUnplayableVideo, err)
+	}
+	i := slices.IndexFunc(probe.Streams, func(s ffprobeStream) bool { return s.CodecType == "video" })
+	if i < 0 {
+		return videoInfo{}, errUnplayableVideo
+	}
+	d, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil || d <= 0 {
+		return videoInfo{}, fmt.Errorf("%w: duration %q", errUnplayableVideo, probe.Format.Duration)
+	}
+	return videoInfo{Duration: d, Width: probe.Streams[i].Width, Height: probe.Streams[i].Height}, nil
+}
+
+// errPosterRequired is returned by posterForUpload when VideoThumbFallback is
+// "fail" and no poster could be extracted.
+var errPosterRequired = errors.New("video thumbnail generation failed")
+
+// posterPathFor returns the on-disk path and public URL of a video's poster frame.
+func posterPathFor(linkName string) (path, url string) {
+	name := linkName + ".poster.jpg"
+	return filepath.Join("static", "images", "previews", name), "/static/images/previews/" + name
+}
+
+// generatePoster extracts the first frame of videoPath as a JPEG scaled to fit
+// within the thumbnail bounds.
+func generatePoster(ctx context.Context, videoPath, posterPath string) error {
+	scale := fmt.Sprintf("scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease",
+		config.ThumbnailMaxWidth, config.ThumbnailMaxHeight)
+	return runFFmpeg(ctx, "-y", "-loglevel", "error",
+		"-i", videoPath, "-frames:v", "1", "-vf", scale, posterPath)
+}
+
+// placeholderColor is the flat frame written when a real poster can't be made.
+var placeholderColor = color.RGBA{0x2b, 0x2b, 0x2b, 0xff}
+
+// writePlaceholderPoster writes a neutral thumbnail-sized JPEG to posterPath.
+func writePlaceholderPoster(posterPath string) error {
+	img := image.NewRGBA(image.Rect(0, 0, config.ThumbnailMaxWidth, config.ThumbnailMaxHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: placeholderColor}, image.Point{}, draw.Src)
+	return imageproc.Save(img, "jpeg", posterPath, config.DefaultCompressionQuality)
+}
+
+// posterForUpload produces the poster for a freshly stored video when
+// VideoThumbnails is on, applying VideoThumbFallback if extraction fails:
+// "placeholder" writes a generic frame, "none" leaves the video without a
+// poster, and "fail" returns errPosterRequired so the upload is rejected.
+// It returns empty paths when no poster is stored.
+func posterForUpload(ctx context.Context, linkName, videoPath string) (path, url string, err error) {
+	if !config.Current.VideoThumbnails {
+		return "", "", nil
+	}
+	path, url = posterPathFor(linkName)
+	genErr := errNoFFmpeg
+	if ffmpegAvailable() {
+		genErr = generatePoster(ctx, videoPath, path)
+	}
+	if genErr == nil {
+		return path, url, nil
+	}
+	log.Printf("Warning: failed to generate poster for %s: %v", linkName, genErr)
+	removeFiles(path)
+
+	switch config.Current.VideoThumbFallback {
+	case "none":
+		return "", "", nil
+	case "fail":
+		return "", "", errPosterRequired
+	}
+	if err := writePlaceholderPoster(path); err != nil {
+		log.Printf("Warning: failed to write placeholder poster for %s: %v", linkName, err)
+		removeFiles(path)
+		return "", "", nil
+	}
+	return path, url, nil
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+// stubFFmpeg makes ffmpeg look installed and replaces the runner with fn.
+func stubFFmpeg(t *testing.T, fn func(ctx context.Context, args ...string) error) {
+	origRun, origAvail := runFFmpeg, ffmpegAvailable
+	runFFmpeg = fn
+	ffmpegAvailable = func() bool { return true }
+	t.Cleanup(func() { runFFmpeg, ffmpegAvailable = origRun, origAvail })
+}
+
+// stubFFprobe makes ffprobe look installed and answer every probe with out
+// and err.
+func stubFFprobe(t *testing.T, out string, err error) {
+	origRun, origAvail := runFFprobe, ffprobeAvailable
+	runFFprobe = func(context.Context, ...string) ([]byte, error) { return []byte(out), err }
+	ffprobeAvailable = func() bool { return true }
+	t.Cleanup(func() { runFFprobe, ffprobeAvailable = origRun, origAvail })
+}
+
+// playableProbe is ffprobe's report for a file with a video stream.
+const playableProbe = `{"streams":[{"codec_type":"audio"},{"codec_type":"video","width":1920,"height":1080}],"format":{"duration":"12.5"}}`
+
+func TestVideoThumbFallback(t *testing.T) {
+	failing := func(context.Context, ...string) error { return errors.New("no frames") }
+
+	tests := []struct {
+		fallback   string
+		wantStatus int
+		wantPoster bool
+	}{
+		{"placeholder", http.StatusOK, true},
+		{"none", http.StatusOK, false},
+		{"fail", http.StatusUnprocessableEntity, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fallback, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			for _, d := range []string{"data", "external", "static/images/previews"} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join("external", "clip.mp4"), []byte("fake video"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			config.Current = config.Config{
+				MaxUploadMB:        10,
+				ExternalImageDir:   "external",
+				PreviewFormat:      "webp",
+				VideoThumbnails:    true,
+				VideoThumbFallback: tt.fallback,
+			}
+			InitUploadSemaphore(1)
+			stubFFmpeg(t, failing)
+			stubFFprobe(t, playableProbe, nil)
+			storage.Global.Set("vid", &storage.Wallpaper{ID: "vid", LinkName: "vid"})
+			t.Cleanup(func() { storage.Global.Delete("vid") })
+
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			_ = mw.WriteField("linkName", "vid")
+			_ = mw.WriteField("url", "clip.mp4")
+			_ = mw.Close()
+			req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+			Upload(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			wp, _ := storage.Global.Get("vid")
+			if got := wp.PosterPath != ""; got != tt.wantPoster {
+				t.Errorf("poster stored = %v, want %v", got, tt.wantPoster)
+			}
+			posterPath, _ := posterPathFor("vid")
+			if _, err := os.Stat(posterPath); (err == nil) != tt.wantPoster {
+				t.Errorf("poster file exists = %v, want %v", err == nil, tt.wantPoster)
+			}
+			if tt.fallback == "fail" {
+				if wp.HasImage {
+					t.Error("rejected upload left the link with an image")
+				}
+				if _, err := os.Stat(filepath.Join("static", "images", "vid.mp4")); !os.IsNotExist(err) {
+					t.Error("rejected upload left the video on disk")
+				}
+			}
+		})
+	}
+}
+
+func TestVideoProbe(t *testing.T) {
+	ok := func(context.Context, ...string) error { return nil }
+
+	tests := []struct {
+		name       string
+		thumbnails bool
+		out        string
+		err        error
+		wantStatus int
+		want       videoInfo
+	}{
+		{"playable", true, playableProbe, nil, http.StatusOK, videoInfo{12.5, 1920, 1080}},
+		{"audio only", true, `{"streams":[{"codec_type":"audio"}],"format":{"duration":"3.0"}}`, nil, http.StatusUnprocessableEntity, videoInfo{}},
+		{"no duration", true, `{"streams":[{"codec_type":"video"}],"format":{}}`, nil, http.StatusUnprocessableEntity, videoInfo{}},
+		{"probe fails", true, "", errors.New("invalid data found"), http.StatusUnprocessableEntity, videoInfo{}},
+		{"thumbnails off", false, "", errors.New("invalid data found"), http.StatusOK, videoInfo{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			for _, d := range []string{"data", "external", "static/images/previews"} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join("external", "clip.mp4"), []byte("fake video"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			config.Current = config.Config{
+				MaxUploadMB:      10,
+				ExternalImageDir: "external",
+				PreviewFormat:    "webp",
+				VideoThumbnails:  tt.thumbnails,
+			}
+			InitUploadSemaphore(1)
+			stubFFmpeg(t, ok)
+			stubFFprobe(t, tt.out, tt.err)
+			storage.Global.Set("vid", &storage.Wallpaper{ID: "vid", LinkName: "vid"})
+			t.Cleanup(func() { storage.Global.Delete("vid") })
+
+			rec := httptest.NewRecorder()
+			Upload(rec, multipartRequest([]string{"linkName", "vid", "url", "clip.mp4"}, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			wp, _ := storage.Global.Get("vid")
+			if got := (videoInfo{wp.DurationSeconds, wp.Width, wp.Height}); got != tt.want {
+				t.Errorf("stored %+v, want %+v", got, tt.want)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if wp.HasImage {
+					t.Error("rejected upload left the link with an image")
+				}
+				if _, err := os.Stat(filepath.Join("static", "images", "vid.mp4")); !os.IsNotExist(err) {
+					t.Error("rejected upload left the video on disk")
+				}
+			}
+		})
+	}
+}
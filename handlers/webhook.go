@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"lanpaper/config"
+)
+
+// Webhook event names.
+const (
+	eventUpload = "upload"
+	eventCreate = "create"
+	eventDelete = "delete"
+	eventPrune  = "prune"
+)
+
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+)
+
+// webhookRetryDelay is the wait before the first retry, doubled for each
+// one after; tests shorten it.
+var webhookRetryDelay = 2 * time.Second
+
+// WebhookEvent is the JSON body POSTed to each configured webhook.
+type WebhookEvent struct {
+	Event     string `json:"event"`
+	LinkName  string `json:"linkName"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// webhookClient returns the client deliveries use. It shares the download
+// transport, whose dialer refuses private and loopback addresses, so a
+// webhook can't be pointed at internal services. Tests replace it.
+var webhookClient = func() *http.Client {
+	return &http.Client{
+		Transport: getTransport(),
+		Timeout:   webhookTimeout,
+		// A redirect would turn the POST into a GET elsewhere; treat it as
+		// the final response instead.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// notify sends event for linkName to every configured webhook and the MQTT
+// broker in the background; failures are logged, never returned to the
+// request.
+func notify(event, linkName string) {
+	hooks := config.Current.Webhooks
+	useMQTT := config.Current.MQTTBroker != ""
+	if len(hooks) == 0 && !useMQTT {
+		return
+	}
+	body, err := json.Marshal(WebhookEvent{Event: event, LinkName: linkName, Timestamp: time.Now().Unix()})
+	if err != nil {
+		log.Printf("Error encoding webhook event: %v", err)
+		return
+	}
+	if useMQTT {
+		goBackground(func() { publishMQTT(event, linkName, body) })
+	}
+	for i, u := range hooks {
+		goBackground(func() {
+			if err := deliverWebhook(u, body); err != nil {
+				// The URL may carry a token, so log its position instead.
+				log.Printf("Webhook #%d: %s %s not delivered: %v", i+1, event, linkName, err)
+			}
+		})
+	}
+}
+
+// signatureHeader carries the HMAC of the body when WebhookSecret is set.
+const signatureHeader = "X-Lanpaper-Signature"
+
+// webhookSignature returns "sha256=" and the hex HMAC-SHA256 of body keyed
+// with secret, the format GitHub uses, so receivers can reuse their checks.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs body to u, retrying with backoff until a 2xx
+// response or webhookAttempts failures.
+func deliverWebhook(u string, body []byte) error {
+	client := webhookClient()
+	var signature string
+	if secret := config.Current.WebhookSecret; secret != "" {
+		signature = webhookSignature(secret, body)
+	}
+	delay := webhookRetryDelay
+	var err error
+	for attempt := range webhookAttempts {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return errors.New("invalid URL") // validated at load; don't echo it
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if signature != "" {
+			req.Header.Set(signatureHeader, signature)
+		}
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err // drop the URL, it may carry a token
+		}
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return fmt.Errorf("%d attempts: %w", webhookAttempts, err)
+}
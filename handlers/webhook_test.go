@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+	"lanpaper/storage"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var events []WebhookEvent
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable) // first attempt fails
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write(body)
+		if got, want := r.Header.Get("X-Lanpaper-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		var ev WebhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("bad webhook request: %v", err)
+		}
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	origClient, origDelay := webhookClient, webhookRetryDelay
+	webhookClient = func() *http.Client { return srv.Client() }
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookClient, webhookRetryDelay = origClient, origDelay })
+	config.Current = config.Config{Webhooks: []string{srv.URL + "/hook"}, WebhookSecret: "hook-secret"}
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	rec := httptest.NewRecorder()
+	Link(rec, httptest.NewRequest(http.MethodPost, "/api/link", strings.NewReader(`{"linkName": "hooked"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	t.Cleanup(func() { storage.Global.Delete("hooked") })
+	rec = httptest.NewRecorder()
+	Link(rec, httptest.NewRequest(http.MethodDelete, "/api/link/hooked", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := WaitBackground(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 3 || len(events) != 2 {
+		t.Fatalf("calls = %d, events = %+v; want a retry and two deliveries", calls, events)
+	}
+	got := map[string]bool{}
+	for _, ev := range events {
+		if ev.LinkName != "hooked" || ev.Timestamp == 0 {
+			t.Errorf("event = %+v", ev)
+		}
+		got[ev.Event] = true
+	}
+	if !got[eventCreate] || !got[eventDelete] {
+		t.Errorf("events = %+v, want create and delete", events)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	config.Current = config.Config{}
+	var sig []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig = r.Header.Values("X-Lanpaper-Signature")
+	}))
+	defer srv.Close()
+	orig := webhookClient
+	webhookClient = func() *http.Client { return srv.Client() }
+	t.Cleanup(func() { webhookClient = orig })
+
+	if err := deliverWebhook(srv.URL, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sig) != 0 {
+		t.Errorf("signature sent without a secret: %q", sig)
+	}
+}
+
+func TestWebhookRefusesLoopback(t *testing.T) {
+	config.Current = config.Config{}
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { hit = true }))
+	defer srv.Close()
+
+	origDelay := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = origDelay })
+
+	err := deliverWebhook(srv.URL+"/hook?token=s3cret", []byte(`{}`))
+	if err == nil || hit {
+		t.Fatalf("err = %v, hit = %v; want loopback refused", err, hit)
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("error leaks the URL: %v", err)
+	}
+}
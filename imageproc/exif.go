@@ -0,0 +1,193 @@
+package imageproc
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"lanpaper/config"
+)
+
+// EXIF tags CaptureTime reads.
+const (
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+)
+
+// exifTime is the EXIF date layout; EXIF stores no zone with it.
+const exifTime = "2006:01:02 15:04:05"
+
+// errExifLimit stops parsing metadata that exceeds ExifMaxBytes or
+// ExifMaxEntries; such files are treated as having no EXIF.
+var errExifLimit = errors.New("exif: metadata exceeds limits")
+
+// exifReader reads a file's metadata relative to base, the start of the
+// TIFF structure, charging every read against the configured budgets so a
+// malformed file can't make the parser do unbounded work.
+type exifReader struct {
+	r           io.ReaderAt
+	base        int64
+	bytesLeft   int64
+	entriesLeft int
+}
+
+func (e *exifReader) ReadAt(p []byte, off int64) (int, error) {
+	if int64(len(p)) > e.bytesLeft {
+		return 0, errExifLimit
+	}
+	e.bytesLeft -= int64(len(p))
+	return e.r.ReadAt(p, e.base+off)
+}
+
+// CaptureTime returns the EXIF DateTimeOriginal of a JPEG or TIFF file.
+// The time is read in OffsetTimeOriginal's zone when the camera recorded
+// one and in the server's local zone otherwise. ok is false when the file
+// has no usable date or its metadata exceeds ExifMaxBytes/ExifMaxEntries.
+func CaptureTime(r io.ReaderAt) (t time.Time, ok bool) {
+	e := &exifReader{
+		r:           r,
+		bytesLeft:   int64(cmp.Or(config.Current.ExifMaxBytes, config.DefaultExifMaxBytes)),
+		entriesLeft: cmp.Or(config.Current.ExifMaxEntries, config.DefaultExifMaxEntries),
+	}
+	var magic [4]byte
+	if _, err := e.ReadAt(magic[:], 0); err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		off, ok := jpegExifOffset(e)
+		if !ok {
+			return time.Time{}, false
+		}
+		e.base = off
+		return tiffCaptureTime(e)
+	case string(magic[:]) == "II*\x00" || string(magic[:]) == "MM\x00*":
+		return tiffCaptureTime(e)
+	}
+	return time.Time{}, false
+}
+
+// jpegExifOffset walks the JPEG marker segments up to the first scan and
+// returns the offset of the TIFF header inside the Exif APP1 segment.
+func jpegExifOffset(r io.ReaderAt) (int64, bool) {
+	off := int64(2)
+	var seg [10]byte
+	for {
+		if _, err := r.ReadAt(seg[:4], off); err != nil || seg[0] != 0xFF {
+			return 0, false
+		}
+		marker := seg[1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 0, false // start of scan or end of image: no Exif
+		}
+		length := int64(binary.BigEndian.Uint16(seg[2:4]))
+		if length < 2 {
+			return 0, false
+		}
+		if marker == 0xE1 && length >= 8 {
+			if _, err := r.ReadAt(seg[4:10], off+4); err != nil {
+				return 0, false
+			}
+			if bytes.Equal(seg[4:10], []byte("Exif\x00\x00")) {
+				return off + 10, true
+			}
+		}
+		off += 2 + length
+	}
+}
+
+// tiffCaptureTime reads DateTimeOriginal from the Exif sub-IFD of the TIFF
+// structure r points at.
+func tiffCaptureTime(r *exifReader) (time.Time, bool) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return time.Time{}, false
+	}
+	var bo binary.ByteOrder
+	switch string(hdr[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+	ifd0 := readIFD(r, bo, int64(bo.Uint32(hdr[4:])))
+	exif, ok := ifd0[tagExifIFD]
+	if !ok {
+		return time.Time{}, false
+	}
+	sub := readIFD(r, bo, int64(bo.Uint32(exif[8:])))
+	e, ok := sub[tagDateTimeOriginal]
+	if !ok {
+		return time.Time{}, false
+	}
+	date, ok := ifdString(r, bo, e)
+	if !ok {
+		return time.Time{}, false
+	}
+	loc := time.Local
+	if e, ok := sub[tagOffsetTimeOriginal]; ok {
+		if s, ok := ifdString(r, bo, e); ok {
+			if z, err := time.Parse("-07:00", s); err == nil {
+				loc = z.Location()
+			}
+		}
+	}
+	t, err := time.ParseInLocation(exifTime, date, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// readIFD returns the 12-byte entries of the image file directory at off,
+// keyed by tag. A directory larger than the remaining entry budget is
+// ignored whole.
+func readIFD(r *exifReader, bo binary.ByteOrder, off int64) map[uint16][]byte {
+	var n [2]byte
+	if _, err := r.ReadAt(n[:], off); err != nil {
+		return nil
+	}
+	count := int(bo.Uint16(n[:]))
+	if count > r.entriesLeft {
+		r.entriesLeft = 0
+		return nil
+	}
+	r.entriesLeft -= count
+	buf := make([]byte, 12*count)
+	if _, err := r.ReadAt(buf, off+2); err != nil {
+		return nil
+	}
+	entries := make(map[uint16][]byte, count)
+	for i := 0; i < count; i++ {
+		e := buf[12*i : 12*i+12]
+		entries[bo.Uint16(e)] = e
+	}
+	return entries
+}
+
+// ifdString returns the value of an ASCII (type 2) entry without its
+// trailing NULs.
+func ifdString(r io.ReaderAt, bo binary.ByteOrder, e []byte) (string, bool) {
+	if bo.Uint16(e[2:]) != 2 {
+		return "", false
+	}
+	n := bo.Uint32(e[4:])
+	if n > 64 {
+		return "", false
+	}
+	val := e[8 : 8+min(n, 4)]
+	if n > 4 {
+		val = make([]byte, n)
+		if _, err := r.ReadAt(val, int64(bo.Uint32(e[8:]))); err != nil {
+			return "", false
+		}
+	}
+	return strings.TrimRight(string(val), "\x00 "), true
+}
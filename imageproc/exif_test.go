@@ -0,0 +1,147 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+)
+
+// exifTIFF builds a TIFF header with an Exif sub-IFD holding date as
+// DateTimeOriginal and, when non-empty, zone as OffsetTimeOriginal.
+func exifTIFF(bo binary.ByteOrder, date, zone string) []byte {
+	type entry struct {
+		tag uint16
+		val string
+	}
+	entries := []entry{{tagDateTimeOriginal, date}}
+	if zone != "" {
+		entries = append(entries, entry{tagOffsetTimeOriginal, zone})
+	}
+	var b bytes.Buffer
+	if bo == binary.LittleEndian {
+		b.WriteString("II")
+	} else {
+		b.WriteString("MM")
+	}
+	put16 := func(v uint16) { _ = binary.Write(&b, bo, v) }
+	put32 := func(v uint32) { _ = binary.Write(&b, bo, v) }
+	put16(42)
+	put32(8)
+	// IFD0: a single pointer to the Exif IFD right after it.
+	put16(1)
+	put16(tagExifIFD)
+	put16(4)
+	put32(1)
+	put32(8 + 2 + 12 + 4)
+	put32(0)
+	// Exif IFD, its string values following the directory.
+	data := uint32(26 + 2 + 12*len(entries) + 4)
+	var values []byte
+	put16(uint16(len(entries)))
+	for _, e := range entries {
+		v := append([]byte(e.val), 0)
+		put16(e.tag)
+		put16(2)
+		put32(uint32(len(v)))
+		put32(data + uint32(len(values)))
+		values = append(values, v...)
+	}
+	put32(0)
+	b.Write(values)
+	return b.Bytes()
+}
+
+// exifJPEG returns a small JPEG with tiff embedded as its Exif segment.
+func exifJPEG(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	var out bytes.Buffer
+	out.Write(enc.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(enc.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestCaptureTime(t *testing.T) {
+	plain := func() []byte {
+		var b bytes.Buffer
+		_ = jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+		return b.Bytes()
+	}()
+	local := time.Date(2019, 7, 14, 8, 30, 0, 0, time.Local)
+	zoned := time.Date(2019, 7, 14, 8, 30, 0, 0, time.FixedZone("", 2*3600))
+
+	tests := []struct {
+		name string
+		data []byte
+		want time.Time
+		ok   bool
+	}{
+		{"jpeg little endian", exifJPEG(t, exifTIFF(binary.LittleEndian, "2019:07:14 08:30:00", "")), local, true},
+		{"jpeg big endian", exifJPEG(t, exifTIFF(binary.BigEndian, "2019:07:14 08:30:00", "")), local, true},
+		{"jpeg with offset", exifJPEG(t, exifTIFF(binary.LittleEndian, "2019:07:14 08:30:00", "+02:00")), zoned, true},
+		{"tiff", exifTIFF(binary.BigEndian, "2019:07:14 08:30:00", ""), local, true},
+		{"blank date", exifJPEG(t, exifTIFF(binary.LittleEndian, "0000:00:00 00:00:00", "")), time.Time{}, false},
+		{"no exif", plain, time.Time{}, false},
+		{"truncated", exifJPEG(t, exifTIFF(binary.LittleEndian, "2019:07:14 08:30:00", ""))[:30], time.Time{}, false},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := CaptureTime(bytes.NewReader(tt.data))
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("CaptureTime = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCaptureTimeLimits(t *testing.T) {
+	t.Cleanup(func() { config.Current = config.Config{} })
+	valid := exifJPEG(t, exifTIFF(binary.LittleEndian, "2019:07:14 08:30:00", ""))
+
+	// Thousands of empty APP segments ahead of the Exif one.
+	padded := append([]byte{}, valid[:2]...)
+	for range 20000 {
+		padded = append(padded, 0xFF, 0xE2, 0x00, 0x02)
+	}
+	padded = append(padded, valid[2:]...)
+
+	// IFD0 claims 65535 entries, none of them present.
+	huge := []byte("II*\x00\x08\x00\x00\x00\xff\xff")
+
+	tests := []struct {
+		name    string
+		entries int
+		bytes   int
+		data    []byte
+		ok      bool
+	}{
+		{"defaults", 0, 0, valid, true},
+		{"entry limit below file", 1, 0, valid, false},
+		{"entry limit at file", 2, 0, valid, true},
+		{"byte limit below file", 0, 40, valid, false},
+		{"padding beyond default bytes", 0, 0, padded, false},
+		{"padding within raised bytes", 0, 1 << 20, padded, true},
+		{"oversized directory", 0, 0, huge, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current = config.Config{ExifMaxEntries: tt.entries, ExifMaxBytes: tt.bytes}
+			if _, ok := CaptureTime(bytes.NewReader(tt.data)); ok != tt.ok {
+				t.Errorf("CaptureTime ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package imageproc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// maxICCBytes caps an embedded ICC profile; real ones are a few KB, and a
+// larger one is treated as absent rather than read into memory.
+const maxICCBytes = 4 << 20
+
+// errUnsupportedProfile is returned for profiles SRGBConverter can't
+// apply: anything but an RGB matrix/TRC profile, e.g. LUT-based ones.
+var errUnsupportedProfile = errors.New("icc: unsupported profile")
+
+// ICCProfile returns the ICC profile embedded in a JPEG, PNG or WebP file,
+// or nil when it has none or the format can't carry one.
+func ICCProfile(r io.ReaderAt) []byte {
+	var magic [12]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil
+	}
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		return jpegICC(r)
+	case bytes.Equal(magic[:8], []byte("\x89PNG\r\n\x1a\n")):
+		return pngICC(r)
+	case string(magic[:4]) == "RIFF" && string(magic[8:12]) == "WEBP":
+		return webpICC(r)
+	}
+	return nil
+}
+
+// jpegICC joins the ICC_PROFILE APP2 segments before the first scan.
+func jpegICC(r io.ReaderAt) []byte {
+	const sig = "ICC_PROFILE\x00"
+	var (
+		chunks = map[byte][]byte{}
+		count  byte
+		total  int
+		seg    [4]byte
+	)
+	for off := int64(2); ; {
+		if _, err := r.ReadAt(seg[:], off); err != nil || seg[0] != 0xFF {
+			break
+		}
+		marker := seg[1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int64(binary.BigEndian.Uint16(seg[2:4]))
+		if length < 2 {
+			return nil
+		}
+		if marker == 0xE2 && length >= 2+int64(len(sig))+2 {
+			data := make([]byte, length-2)
+			if _, err := r.ReadAt(data, off+4); err != nil {
+				return nil
+			}
+			if string(data[:len(sig)]) == sig {
+				seq, n := data[len(sig)], data[len(sig)+1]
+				if total += len(data); total > maxICCBytes {
+					return nil
+				}
+				chunks[seq], count = data[len(sig)+2:], n
+			}
+		}
+		off += 2 + length
+	}
+	if count == 0 || len(chunks) != int(count) {
+		return nil
+	}
+	var profile []byte
+	for seq := byte(1); seq <= count; seq++ {
+		c, ok := chunks[seq]
+		if !ok {
+			return nil
+		}
+		profile = append(profile, c...)
+	}
+	return profile
+}
+
+// pngICC inflates the iCCP chunk, which precedes the image data.
+func pngICC(r io.ReaderAt) []byte {
+	var hdr [8]byte
+	for off := int64(8); ; {
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return nil
+		}
+		length := int64(binary.BigEndian.Uint32(hdr[:4]))
+		switch string(hdr[4:8]) {
+		case "IDAT", "IEND":
+			return nil
+		case "iCCP":
+			if length > maxICCBytes {
+				return nil
+			}
+			data := make([]byte, length)
+			if _, err := r.ReadAt(data, off+8); err != nil {
+				return nil
+			}
+			// Profile name, NUL, compression method (0 = zlib), profile.
+			name := bytes.IndexByte(data, 0)
+			if name < 0 || name+2 > len(data) || data[name+1] != 0 {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(data[name+2:]))
+			if err != nil {
+				return nil
+			}
+			profile, err := io.ReadAll(io.LimitReader(zr, maxICCBytes+1))
+			if err != nil || len(profile) > maxICCBytes {
+				return nil
+			}
+			return profile
+		}
+		off += 12 + length
+	}
+}
+
+// webpICC returns the ICCP chunk of an extended (VP8X) WebP file.
+func webpICC(r io.ReaderAt) []byte {
+	var hdr [8]byte
+	for off := int64(12); ; {
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return nil
+		}
+		length := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		switch string(hdr[:4]) {
+		case "VP8 ", "VP8L", "ALPH", "ANIM":
+			return nil
+		case "ICCP":
+			if length > maxICCBytes {
+				return nil
+			}
+			profile := make([]byte, length)
+			if _, err := r.ReadAt(profile, off+8); err != nil {
+				return nil
+			}
+			return profile
+		}
+		off += 8 + length + length&1
+	}
+}
+
+// xyzD50ToSRGB converts ICC profile connection space XYZ (D50) to linear
+// sRGB, with Bradford chromatic adaptation to D65.
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// srgbEncode maps linear light, quantised to 4096 steps, to 8-bit sRGB.
+var srgbEncode = func() (t [4096]uint8) {
+	for i := range t {
+		v := float64(i) / 4095
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		t[i] = uint8(math.Round(v * 255))
+	}
+	return t
+}()
+
+// srgbDecode is the sRGB transfer curve, 8-bit value to linear light.
+func srgbDecode(i int) float64 {
+	v := float64(i) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// SRGBConverter converts pixels from an RGB matrix/TRC ICC profile, such
+// as Display P3 or Adobe RGB, to sRGB.
+type SRGBConverter struct {
+	trc [3][256]float64 // per channel, 8-bit value to linear light
+	m   [3][3]float64   // linear profile RGB to linear sRGB
+}
+
+// NewSRGBConverter parses profile. It fails for profiles other than
+// RGB matrix/TRC ones, which cover the common wide-gamut camera and
+// display profiles.
+func NewSRGBConverter(profile []byte) (*SRGBConverter, error) {
+	if len(profile) < 132 || string(profile[16:20]) != "RGB " || string(profile[20:24]) != "XYZ " {
+		return nil, errUnsupportedProfile
+	}
+	tags := map[string][]byte{}
+	n := int(binary.BigEndian.Uint32(profile[128:132]))
+	for i := range n {
+		e := 132 + 12*i
+		if e+12 > len(profile) {
+			return nil, errUnsupportedProfile
+		}
+		off := int(binary.BigEndian.Uint32(profile[e+4:]))
+		size := int(binary.BigEndian.Uint32(profile[e+8:]))
+		if off < 0 || size < 0 || off > len(profile) || size > len(profile)-off {
+			return nil, errUnsupportedProfile
+		}
+		tags[string(profile[e:e+4])] = profile[off : off+size]
+	}
+
+	c := &SRGBConverter{}
+	var toXYZ [3][3]float64 // columns are the red, green and blue colorants
+	for ch, name := range []string{"r", "g", "b"} {
+		xyz, ok := tags[name+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, errUnsupportedProfile
+		}
+		for row := range 3 {
+			toXYZ[row][ch] = s15Fixed16(xyz[8+4*row:])
+		}
+		curve, err := parseTRC(tags[name+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		for i := range 256 {
+			c.trc[ch][i] = curve(float64(i) / 255)
+		}
+	}
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				c.m[i][j] += xyzD50ToSRGB[i][k] * toXYZ[k][j]
+			}
+		}
+	}
+	return c, nil
+}
+
+// IsSRGB reports whether the profile is sRGB already, within rounding, so
+// converting would change nothing.
+func (c *SRGBConverter) IsSRGB() bool {
+	for i := range 3 {
+		for j := range 3 {
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			if math.Abs(c.m[i][j]-want) > 0.01 {
+				return false
+			}
+		}
+		for v := range 256 {
+			if math.Abs(c.trc[i][v]-srgbDecode(v)) > 0.003 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Convert returns img with its pixels converted to sRGB. Colours outside
+// the sRGB gamut are clipped.
+func (c *SRGBConverter) Convert(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	for i := 0; i+3 < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+3 : i+3]
+		r, g, bl := c.trc[0][p[0]], c.trc[1][p[1]], c.trc[2][p[2]]
+		for ch := range 3 {
+			v := c.m[ch][0]*r + c.m[ch][1]*g + c.m[ch][2]*bl
+			p[ch] = srgbEncode[int(math.Round(min(max(v, 0), 1)*4095))]
+		}
+	}
+	return dst
+}
+
+// s15Fixed16 decodes the ICC signed 15.16 fixed-point number at b.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseTRC returns the tone reproduction curve of a curv or para tag,
+// mapping a channel value in [0, 1] to linear light.
+func parseTRC(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, errUnsupportedProfile
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if n > (len(tag)-12)/2 {
+			return nil, errUnsupportedProfile
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			g := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(n-1)
+			i := min(int(pos), n-2)
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+	case "para":
+		// Parameter counts of function types 0–4 (ICC.1 10.18).
+		counts := []int{1, 3, 4, 5, 7}
+		fn := int(binary.BigEndian.Uint16(tag[8:10]))
+		if fn >= len(counts) || len(tag) < 12+4*counts[fn] {
+			return nil, errUnsupportedProfile
+		}
+		var p [7]float64
+		for i := range counts[fn] {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		pow := func(v float64) float64 { return math.Pow(max(v, 0), g) }
+		switch fn {
+		case 0:
+			return pow, nil
+		case 1:
+			return func(x float64) float64 {
+				if x >= -b/a {
+					return pow(a*x + b)
+				}
+				return 0
+			}, nil
+		case 2:
+			return func(x float64) float64 {
+				if x >= -b/a {
+					return pow(a*x+b) + c
+				}
+				return c
+			}, nil
+		case 3:
+			return func(x float64) float64 {
+				if x >= d {
+					return pow(a*x + b)
+				}
+				return c * x
+			}, nil
+		default:
+			return func(x float64) float64 {
+				if x >= d {
+					return pow(a*x+b) + e
+				}
+				return c*x + f
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: TRC type %q", errUnsupportedProfile, tag[:4])
+}
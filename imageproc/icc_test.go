@@ -0,0 +1,110 @@
+package imageproc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withJPEGICC splits profile into APP2 segments of at most chunk bytes
+// right after the SOI marker of jpg.
+func withJPEGICC(jpg, profile []byte, chunk int) []byte {
+	var segs [][]byte
+	for p := profile; len(p) > 0; p = p[min(chunk, len(p)):] {
+		segs = append(segs, p[:min(chunk, len(p))])
+	}
+	out := append([]byte(nil), jpg[:2]...)
+	for i, s := range segs {
+		out = append(out, 0xFF, 0xE2)
+		out = binary.BigEndian.AppendUint16(out, uint16(2+12+2+len(s)))
+		out = append(out, "ICC_PROFILE\x00"...)
+		out = append(out, byte(i+1), byte(len(segs)))
+		out = append(out, s...)
+	}
+	return append(out, jpg[2:]...)
+}
+
+// withPNGICC inserts an iCCP chunk after the IHDR chunk of pngData.
+func withPNGICC(pngData, profile []byte) []byte {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(profile)
+	zw.Close()
+	data := append([]byte("test\x00\x00"), z.Bytes()...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, "iCCP"...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	ihdrEnd := 8 + 12 + 13
+	return append(append(append([]byte(nil), pngData[:ihdrEnd]...), chunk...), pngData[ihdrEnd:]...)
+}
+
+// webpWithICC builds the chunk layout of an extended WebP with an ICCP
+// chunk; the image data itself is irrelevant to ICCProfile.
+func webpWithICC(profile []byte) []byte {
+	chunk := func(fourcc string, data []byte) []byte {
+		c := append([]byte(fourcc), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	body := append([]byte("WEBP"), chunk("VP8X", make([]byte, 10))...)
+	body = append(body, chunk("ICCP", profile)...)
+	body = append(body, chunk("VP8L", make([]byte, 5))...)
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestICCProfile(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	profile := bytes.Repeat([]byte("profile-bytes-"), 20)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		decodes bool // a real image, which the profile must not break
+	}{
+		{"jpeg", withJPEGICC(jpg.Bytes(), profile, 1000), profile, true},
+		{"jpeg in chunks", withJPEGICC(jpg.Bytes(), profile, 100), profile, true},
+		{"png", withPNGICC(pngData.Bytes(), profile), profile, true},
+		{"webp", webpWithICC(profile), profile, false},
+		{"odd-sized webp", webpWithICC(profile[:7]), profile[:7], false},
+		{"jpeg without", jpg.Bytes(), nil, true},
+		{"png without", pngData.Bytes(), nil, true},
+		{"not an image", []byte("hello world, not an image"), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ICCProfile(bytes.NewReader(tt.data)); !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes, want %d", len(got), len(tt.want))
+			}
+			if tt.decodes {
+				if _, _, err := image.Decode(bytes.NewReader(tt.data)); err != nil {
+					t.Errorf("decode: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSRGBConverterRejectsUnsupported(t *testing.T) {
+	for _, p := range [][]byte{nil, make([]byte, 200), append(make([]byte, 16), "GRAYXYZ "...)} {
+		if _, err := NewSRGBConverter(p); err == nil {
+			t.Errorf("%d-byte profile accepted", len(p))
+		}
+	}
+}
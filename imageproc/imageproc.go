@@ -0,0 +1,214 @@
+// Package imageproc holds the image encode/decode helpers shared by the
+// upload and preview pipelines: scaling, thumbnailing, dimension checks and
+// format-aware encoding.
+package imageproc
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"os"
+
+	"github.com/chai2010/webp"
+	xdraw "golang.org/x/image/draw"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+
+	"lanpaper/config"
+)
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBP", webp.Decode, webp.DecodeConfig)
+}
+
+// Options controls how Process turns a decoded image into stored files.
+type Options struct {
+	Quality int // 1-100, lossy encoder quality
+	Scale   int // 1-100, percentage of the original dimensions
+
+	Format string // stored image format: jpg, png, gif or webp
+	Path   string // destination of the stored image
+
+	PreviewFormat string // thumbnail format; empty skips the preview
+	PreviewPath   string
+	PreviewWidth  int
+	PreviewHeight int
+}
+
+// Result describes the output of Process.
+type Result struct {
+	Image  image.Image // the scaled image that was written to Path
+	Bounds image.Rectangle
+}
+
+// Process scales src, writes it to opts.Path and, when requested, writes a
+// thumbnail to opts.PreviewPath. ctx is checked between the expensive steps.
+// If the preview fails or ctx is cancelled, the files written so far are
+// removed so callers never observe a half-processed upload.
+func Process(ctx context.Context, src image.Image, opts Options) (Result, error) {
+	img := Scale(src, opts.Scale)
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	if err := Save(img, opts.Format, opts.Path, opts.Quality); err != nil {
+		return Result{}, fmt.Errorf("save image: %w", err)
+	}
+	if opts.PreviewFormat != "" {
+		if err := ctx.Err(); err != nil {
+			removeAll(opts.Path)
+			return Result{}, err
+		}
+		thumb := Thumbnail(img, opts.PreviewWidth, opts.PreviewHeight)
+		if err := Save(thumb, opts.PreviewFormat, opts.PreviewPath, opts.Quality); err != nil {
+			removeAll(opts.Path, opts.PreviewPath)
+			return Result{}, fmt.Errorf("save preview: %w", err)
+		}
+	}
+	return Result{Image: img, Bounds: img.Bounds()}, nil
+}
+
+func removeAll(paths ...string) {
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing %s: %v", p, err)
+		}
+	}
+}
+
+// NormalizeFormat maps decoder format names to stored file extensions.
+func NormalizeFormat(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
+
+// DetectFormat reads just the image header and returns the decoder's format
+// name, normalized like NormalizeFormat.
+func DetectFormat(r io.Reader) (string, error) {
+	_, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", err
+	}
+	return NormalizeFormat(format), nil
+}
+
+// StoredExt returns the file extension to use for storage.
+// In lossless mode, the original format is preserved.
+// In compression mode, BMP/TIFF are converted to JPEG.
+func StoredExt(ext string, lossless bool) string {
+	if lossless {
+		return ext
+	}
+	if ext == "bmp" || ext == "tiff" {
+		return "jpg"
+	}
+	return ext
+}
+
+// CheckDimensions returns an error if the image exceeds the allowed
+// dimensions. A decode error is propagated so callers can decide whether to
+// reject the file.
+func CheckDimensions(r io.Reader) error {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return fmt.Errorf("could not read image config: %w", err)
+	}
+	if cfg.Width > config.MaxImageDimension || cfg.Height > config.MaxImageDimension {
+		return fmt.Errorf("image %dx%d exceeds %dx%d limit",
+			cfg.Width, cfg.Height, config.MaxImageDimension, config.MaxImageDimension)
+	}
+	return nil
+}
+
+// Thumbnail scales src down to fit within maxW×maxH, preserving aspect ratio.
+// Images that already fit are returned unchanged.
+func Thumbnail(src image.Image, maxW, maxH int) image.Image {
+	b := src.Bounds()
+	scale := min(float64(maxW)/float64(b.Dx()), float64(maxH)/float64(b.Dy()))
+	if scale >= 1 {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, int(float64(b.Dx())*scale), int(float64(b.Dy())*scale)))
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+// Cover scales and center-crops src to fill exactly w×h. A source smaller
+// than the box is cropped to the box's aspect ratio but not enlarged.
+func Cover(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	// The largest centered rectangle with the box's aspect ratio.
+	cw, ch := b.Dx(), b.Dx()*h/w
+	if ch > b.Dy() {
+		cw, ch = b.Dy()*w/h, b.Dy()
+	}
+	cw, ch = max(cw, 1), max(ch, 1)
+	x0, y0 := b.Min.X+(b.Dx()-cw)/2, b.Min.Y+(b.Dy()-ch)/2
+	crop := image.Rect(x0, y0, x0+cw, y0+ch)
+	if cw < w {
+		w, h = cw, ch
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	return dst
+}
+
+// Scale resizes src to scalePercent of its dimensions (at least 1×1).
+func Scale(src image.Image, scalePercent int) image.Image {
+	if scalePercent >= 100 {
+		return src
+	}
+	b := src.Bounds()
+	scale := float64(scalePercent) / 100.0
+	newW := int(float64(b.Dx()) * scale)
+	newH := int(float64(b.Dy()) * scale)
+	if newW < 1 {
+		newW = 1
+	}
+	if newH < 1 {
+		newH = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+// Save encodes img as format into path, removing the partial file on failure.
+func Save(img image.Image, format, path string, quality int) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	encodeErr := Encode(out, img, format, quality)
+	closeErr := out.Close()
+	if encodeErr != nil {
+		if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Printf("Error removing partial file %s: %v", path, removeErr)
+		}
+		return encodeErr
+	}
+	return closeErr
+}
+
+// Encode writes img to w in the given format. Unknown formats fall back to JPEG.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "jpg", "jpeg":
+		return encodeJPEG(w, img, quality)
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, &gif.Options{NumColors: config.GIFColors})
+	case "webp":
+		return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+	default:
+		return encodeJPEG(w, img, quality)
+	}
+}
@@ -0,0 +1,164 @@
+package imageproc
+
+import (
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	dir := t.TempDir()
+	src := image.NewRGBA(image.Rect(0, 0, 2000, 1000))
+	opts := Options{
+		Quality:       80,
+		Scale:         50,
+		Format:        "jpg",
+		Path:          filepath.Join(dir, "img.jpg"),
+		PreviewFormat: "webp",
+		PreviewPath:   filepath.Join(dir, "prev.webp"),
+		PreviewWidth:  640,
+		PreviewHeight: 360,
+	}
+
+	res, err := Process(context.Background(), src, opts)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if got, want := res.Bounds.Size(), image.Pt(1000, 500); got != want {
+		t.Errorf("Process() bounds = %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		path, format string
+		size         image.Point
+	}{
+		{opts.Path, "jpeg", image.Pt(1000, 500)},
+		{opts.PreviewPath, "webp", image.Pt(640, 320)},
+	} {
+		f, err := os.Open(tc.path)
+		if err != nil {
+			t.Fatalf("open %s: %v", tc.path, err)
+		}
+		cfg, format, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("decode %s: %v", tc.path, err)
+		}
+		if format != tc.format || cfg.Width != tc.size.X || cfg.Height != tc.size.Y {
+			t.Errorf("%s = %s %dx%d, want %s %v", filepath.Base(tc.path), format, cfg.Width, cfg.Height, tc.format, tc.size)
+		}
+	}
+}
+
+func TestProcessPreviewFailureRemovesImage(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		Quality:       80,
+		Scale:         100,
+		Format:        "png",
+		Path:          filepath.Join(dir, "img.png"),
+		PreviewFormat: "webp",
+		PreviewPath:   filepath.Join(dir, "missing", "prev.webp"),
+		PreviewWidth:  640,
+		PreviewHeight: 360,
+	}
+	if _, err := Process(context.Background(), image.NewRGBA(image.Rect(0, 0, 10, 10)), opts); err == nil {
+		t.Fatal("Process() error = nil, want preview failure")
+	}
+	if _, err := os.Stat(opts.Path); !os.IsNotExist(err) {
+		t.Errorf("image %s left behind after preview failure", opts.Path)
+	}
+}
+
+func TestStoredExt(t *testing.T) {
+	tests := []struct {
+		ext      string
+		lossless bool
+		want     string
+	}{
+		{"bmp", false, "jpg"},
+		{"tiff", false, "jpg"},
+		{"png", false, "png"},
+		{"bmp", true, "bmp"},
+		{"tiff", true, "tiff"},
+	}
+	for _, tt := range tests {
+		if got := StoredExt(tt.ext, tt.lossless); got != tt.want {
+			t.Errorf("StoredExt(%q, %v) = %q, want %q", tt.ext, tt.lossless, got, tt.want)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        image.Point
+		maxW, maxH int
+		want       image.Point
+	}{
+		{"landscape 16:9 fits exactly", image.Pt(3840, 2160), 640, 360, image.Pt(640, 360)},
+		{"landscape wider than box", image.Pt(4000, 1000), 640, 360, image.Pt(640, 160)},
+		{"portrait limited by height", image.Pt(1080, 1920), 640, 360, image.Pt(202, 360)},
+		{"square limited by height", image.Pt(1000, 1000), 640, 360, image.Pt(360, 360)},
+		{"already small", image.Pt(320, 180), 640, 360, image.Pt(320, 180)},
+		{"exact fit", image.Pt(640, 360), 640, 360, image.Pt(640, 360)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rectangle{Max: tt.src})
+			got := Thumbnail(src, tt.maxW, tt.maxH)
+			if size := got.Bounds().Size(); size != tt.want {
+				t.Errorf("Thumbnail(%v, %d, %d) = %v, want %v", tt.src, tt.maxW, tt.maxH, size, tt.want)
+			}
+		})
+	}
+}
+
+func TestCover(t *testing.T) {
+	tests := []struct {
+		name string
+		src  image.Point
+		w, h int
+		want image.Point
+	}{
+		{"landscape into square", image.Pt(1600, 900), 300, 300, image.Pt(300, 300)},
+		{"portrait into landscape", image.Pt(1080, 1920), 800, 600, image.Pt(800, 600)},
+		{"same aspect", image.Pt(1920, 1080), 640, 360, image.Pt(640, 360)},
+		{"smaller than box keeps aspect", image.Pt(400, 300), 1600, 900, image.Pt(400, 225)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rectangle{Max: tt.src})
+			got := Cover(src, tt.w, tt.h)
+			if size := got.Bounds().Size(); size != tt.want {
+				t.Errorf("Cover(%v, %d, %d) = %v, want %v", tt.src, tt.w, tt.h, size, tt.want)
+			}
+		})
+	}
+}
+
+// TestThumbnailReturnsSourceWhenFits checks the scale >= 1 short-circuit hands
+// back the original image instead of copying it.
+func TestThumbnailReturnsSourceWhenFits(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	if got := Thumbnail(src, 640, 360); got != image.Image(src) {
+		t.Error("Thumbnail() of an image within bounds did not return the source")
+	}
+}
+
+func TestProcessCancelled(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := Options{Quality: 80, Scale: 50, Format: "png", Path: filepath.Join(dir, "img.png")}
+	if _, err := Process(ctx, image.NewRGBA(image.Rect(0, 0, 10, 10)), opts); err != context.Canceled {
+		t.Fatalf("Process() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(opts.Path); !os.IsNotExist(err) {
+		t.Errorf("image %s written despite cancellation", opts.Path)
+	}
+}
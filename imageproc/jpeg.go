@@ -0,0 +1,498 @@
+package imageproc
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"math/bits"
+
+	"lanpaper/config"
+)
+
+// encodeJPEG uses the standard library encoder unless config asks for
+// 4:4:4 chroma or progressive output, neither of which image/jpeg supports.
+func encodeJPEG(w io.Writer, img image.Image, quality int) error {
+	c := config.Current
+	if !c.JPEGProgressive && c.JPEGChroma != "444" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	return writeJPEG(w, img, quality, c.JPEGChroma == "444", c.JPEGProgressive)
+}
+
+// Annex K quantization tables in natural (row-major) order.
+var baseQuant = [2][64]uint8{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffSpec is a DHT table: counts of codes per length 1-16, then symbols.
+type huffSpec struct {
+	counts [16]uint8
+	values []uint8
+}
+
+// Annex K Huffman tables: luminance DC, luminance AC, chrominance DC,
+// chrominance AC. Their AC tables include EOB0 (0x00) and ZRL (0xf0), which
+// is all a spectral-selection-only progressive scan needs.
+var huffSpecs = [4]huffSpec{
+	{
+		counts: [16]uint8{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		values: []uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		counts: [16]uint8{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		values: []uint8{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		counts: [16]uint8{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		values: []uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		counts: [16]uint8{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		values: []uint8{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+const (
+	huffLumDC = iota
+	huffLumAC
+	huffChromaDC
+	huffChromaAC
+)
+
+// huffCode is a code word and its length in bits, indexed by symbol.
+type huffCode struct {
+	code uint32
+	n    uint
+}
+
+var (
+	huffTables [4][256]huffCode
+	unzig      [64]int       // zigzag index -> natural index
+	dctCos     [8][8]float64 // C(u)/2 * cos((2x+1)uπ/16)
+)
+
+func init() {
+	for i, s := range huffSpecs {
+		code, k := uint32(0), 0
+		for length, count := range s.counts {
+			for range count {
+				huffTables[i][s.values[k]] = huffCode{code, uint(length + 1)}
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+
+	// Walk the anti-diagonals, alternating direction.
+	i := 0
+	for d := range 15 {
+		for j := range d + 1 {
+			r, c := j, d-j
+			if d%2 == 0 {
+				r, c = d-j, j
+			}
+			if r < 8 && c < 8 {
+				unzig[i] = r*8 + c
+				i++
+			}
+		}
+	}
+
+	for u := range 8 {
+		cu := 0.5
+		if u == 0 {
+			cu = 0.5 / math.Sqrt2
+		}
+		for x := range 8 {
+			dctCos[u][x] = cu * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+}
+
+// jpegScan is one SOS segment: components and the zigzag band [ss, se].
+type jpegScan struct {
+	comps  []int
+	ss, se int
+}
+
+// progressiveScans is a spectral-selection script: DC for all components
+// first, then low luminance frequencies so a coarse image appears early.
+var progressiveScans = []jpegScan{
+	{comps: []int{0, 1, 2}, ss: 0, se: 0},
+	{comps: []int{0}, ss: 1, se: 5},
+	{comps: []int{1}, ss: 1, se: 63},
+	{comps: []int{2}, ss: 1, se: 63},
+	{comps: []int{0}, ss: 6, se: 63},
+}
+
+// jpegEncoder writes a three-component YCbCr JFIF stream. Planes are padded
+// to whole MCUs by edge replication; coefficients are recomputed per scan
+// so progressive output needs no coefficient buffer.
+type jpegEncoder struct {
+	w      *bufio.Writer
+	acc    uint32
+	nAcc   uint
+	quant  [2][64]int32 // zigzag order
+	planes [3][]uint8
+	stride [3]int
+	width  int
+	height int
+	h      int // luminance sampling factor: 2 for 4:2:0, 1 for 4:4:4
+}
+
+// writeJPEG encodes img as baseline or progressive JPEG with 4:2:0 or 4:4:4
+// chroma subsampling.
+func writeJPEG(w io.Writer, img image.Image, quality int, chroma444, progressive bool) error {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() > 65535 || b.Dy() > 65535 {
+		return errors.New("jpeg: image size out of range")
+	}
+	e := &jpegEncoder{w: bufio.NewWriter(w), width: b.Dx(), height: b.Dy(), h: 2}
+	if chroma444 {
+		e.h = 1
+	}
+	e.setQuality(quality)
+	e.fillPlanes(img)
+
+	e.write(0xff, 0xd8) // SOI
+	e.writeDQT()
+	e.writeSOF(progressive)
+	e.writeDHT()
+	if progressive {
+		for _, s := range progressiveScans {
+			e.writeScan(s)
+		}
+	} else {
+		e.writeScan(jpegScan{comps: []int{0, 1, 2}, ss: 0, se: 63})
+	}
+	e.write(0xff, 0xd9) // EOI
+	return e.w.Flush()
+}
+
+// setQuality scales the Annex K tables like libjpeg and image/jpeg do.
+func (e *jpegEncoder) setQuality(quality int) {
+	quality = max(1, min(100, quality))
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for t := range baseQuant {
+		for zz, nat := range unzig {
+			q := (int32(baseQuant[t][nat])*int32(scale) + 50) / 100
+			e.quant[t][zz] = max(1, min(255, q))
+		}
+	}
+}
+
+// fillPlanes converts img to Y, Cb and Cr planes. Luminance is padded to
+// whole MCUs; chroma is box-filtered down by the sampling factor.
+func (e *jpegEncoder) fillPlanes(img image.Image) {
+	mcu := 8 * e.h
+	pw := (e.width + mcu - 1) / mcu * mcu
+	ph := (e.height + mcu - 1) / mcu * mcu
+	full := [3][]uint8{make([]uint8, pw*ph), make([]uint8, pw*ph), make([]uint8, pw*ph)}
+
+	b := img.Bounds()
+	rgba, _ := img.(*image.RGBA)
+	for y := range ph {
+		sy := b.Min.Y + min(y, e.height-1)
+		for x := range pw {
+			sx := b.Min.X + min(x, e.width-1)
+			var r, g, bl uint8
+			if rgba != nil {
+				i := rgba.PixOffset(sx, sy)
+				r, g, bl = rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]
+			} else {
+				c := color.RGBAModel.Convert(img.At(sx, sy)).(color.RGBA)
+				r, g, bl = c.R, c.G, c.B
+			}
+			i := y*pw + x
+			full[0][i], full[1][i], full[2][i] = color.RGBToYCbCr(r, g, bl)
+		}
+	}
+
+	e.planes[0], e.stride[0] = full[0], pw
+	for c := 1; c < 3; c++ {
+		if e.h == 1 {
+			e.planes[c], e.stride[c] = full[c], pw
+			continue
+		}
+		cw, ch := pw/2, ph/2
+		p := make([]uint8, cw*ch)
+		for y := range ch {
+			for x := range cw {
+				i := 2*y*pw + 2*x
+				sum := int(full[c][i]) + int(full[c][i+1]) + int(full[c][i+pw]) + int(full[c][i+pw+1])
+				p[y*cw+x] = uint8((sum + 2) / 4)
+			}
+		}
+		e.planes[c], e.stride[c] = p, cw
+	}
+}
+
+// block computes the quantized coefficients, in zigzag order, of the 8×8
+// block (bx, by) of component c.
+func (e *jpegEncoder) block(c, bx, by int, dst *[64]int32) {
+	var px, tmp [8][8]float64
+	plane, stride := e.planes[c], e.stride[c]
+	for y := range 8 {
+		row := plane[(by*8+y)*stride+bx*8:]
+		for x := range 8 {
+			px[y][x] = float64(row[x]) - 128
+		}
+	}
+	// Separable 2-D DCT-II: rows, then columns.
+	for y := range 8 {
+		for u := range 8 {
+			var s float64
+			for x := range 8 {
+				s += dctCos[u][x] * px[y][x]
+			}
+			tmp[y][u] = s
+		}
+	}
+	q := &e.quant[min(c, 1)]
+	for zz, nat := range unzig {
+		v, u := nat/8, nat%8
+		var s float64
+		for y := range 8 {
+			s += dctCos[v][y] * tmp[y][u]
+		}
+		coef := int32(math.Round(s / float64(q[zz])))
+		if zz == 0 {
+			dst[zz] = max(-1024, min(1023, coef))
+		} else {
+			dst[zz] = max(-1023, min(1023, coef))
+		}
+	}
+}
+
+func (e *jpegEncoder) write(p ...byte) { e.w.Write(p) }
+
+func (e *jpegEncoder) writeMarker(marker byte, payload []byte) {
+	n := len(payload) + 2
+	e.write(0xff, marker, byte(n>>8), byte(n))
+	e.write(payload...)
+}
+
+func (e *jpegEncoder) writeDQT() {
+	p := make([]byte, 0, 2*65)
+	for t := range e.quant {
+		p = append(p, byte(t))
+		for _, q := range e.quant[t] {
+			p = append(p, byte(q))
+		}
+	}
+	e.writeMarker(0xdb, p)
+}
+
+func (e *jpegEncoder) writeSOF(progressive bool) {
+	marker := byte(0xc0)
+	if progressive {
+		marker = 0xc2
+	}
+	p := []byte{8, byte(e.height >> 8), byte(e.height), byte(e.width >> 8), byte(e.width), 3}
+	p = append(p, 1, byte(e.h<<4|e.h), 0) // Y
+	p = append(p, 2, 0x11, 1)             // Cb
+	p = append(p, 3, 0x11, 1)             // Cr
+	e.writeMarker(marker, p)
+}
+
+func (e *jpegEncoder) writeDHT() {
+	var p []byte
+	for i, s := range huffSpecs {
+		// Class (0 DC, 1 AC) in the high nibble, table id in the low.
+		p = append(p, byte(i%2<<4|i/2))
+		p = append(p, s.counts[:]...)
+		p = append(p, s.values...)
+	}
+	e.writeMarker(0xc4, p)
+}
+
+// writeScan writes the SOS header and entropy-coded data of one scan. A
+// scan over several components is interleaved in MCU order; a single
+// component scan covers just that component's blocks.
+func (e *jpegEncoder) writeScan(s jpegScan) {
+	p := []byte{byte(len(s.comps))}
+	for _, c := range s.comps {
+		t := byte(min(c, 1))
+		p = append(p, byte(c+1), t<<4|t)
+	}
+	p = append(p, byte(s.ss), byte(s.se), 0)
+	e.writeMarker(0xda, p)
+
+	var zz [64]int32
+	var pred [3]int32
+	if len(s.comps) == 1 {
+		c := s.comps[0]
+		sub := 1
+		if c > 0 {
+			sub = e.h
+		}
+		bw := ((e.width+sub-1)/sub + 7) / 8
+		bh := ((e.height+sub-1)/sub + 7) / 8
+		for by := range bh {
+			for bx := range bw {
+				e.block(c, bx, by, &zz)
+				e.encodeBlock(c, &zz, &pred[c], s.ss, s.se)
+			}
+		}
+	} else {
+		mcu := 8 * e.h
+		for my := range (e.height + mcu - 1) / mcu {
+			for mx := range (e.width + mcu - 1) / mcu {
+				for _, c := range s.comps {
+					n := 1
+					if c == 0 {
+						n = e.h
+					}
+					for v := range n {
+						for u := range n {
+							e.block(c, mx*n+u, my*n+v, &zz)
+							e.encodeBlock(c, &zz, &pred[c], s.ss, s.se)
+						}
+					}
+				}
+			}
+		}
+	}
+	e.flushBits()
+}
+
+// encodeBlock Huffman-codes the [ss, se] band of one block. Band 0 is the
+// DC difference against pred.
+func (e *jpegEncoder) encodeBlock(c int, zz *[64]int32, pred *int32, ss, se int) {
+	dc, ac := &huffTables[huffLumDC], &huffTables[huffLumAC]
+	if c > 0 {
+		dc, ac = &huffTables[huffChromaDC], &huffTables[huffChromaAC]
+	}
+	if ss == 0 {
+		e.emitValue(dc, 0, zz[0]-*pred)
+		*pred = zz[0]
+		ss = 1
+	}
+	run := 0
+	for k := ss; k <= se; k++ {
+		if zz[k] == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			e.emitSymbol(ac, 0xf0) // ZRL
+			run -= 16
+		}
+		e.emitValue(ac, run, zz[k])
+		run = 0
+	}
+	if run > 0 {
+		e.emitSymbol(ac, 0x00) // EOB
+	}
+}
+
+// emitValue writes the symbol (run, size of v) followed by v's magnitude bits.
+func (e *jpegEncoder) emitValue(t *[256]huffCode, run int, v int32) {
+	a := v
+	if a < 0 {
+		a, v = -a, v-1
+	}
+	size := uint(bits.Len32(uint32(a)))
+	e.emitSymbol(t, uint8(run<<4)|uint8(size))
+	if size > 0 {
+		e.emitBits(uint32(v)&(1<<size-1), size)
+	}
+}
+
+func (e *jpegEncoder) emitSymbol(t *[256]huffCode, sym uint8) {
+	e.emitBits(t[sym].code, t[sym].n)
+}
+
+// emitBits appends n bits, stuffing a zero byte after every 0xff.
+func (e *jpegEncoder) emitBits(code uint32, n uint) {
+	e.acc = e.acc<<n | code
+	e.nAcc += n
+	for e.nAcc >= 8 {
+		b := byte(e.acc >> (e.nAcc - 8))
+		e.w.WriteByte(b)
+		if b == 0xff {
+			e.w.WriteByte(0)
+		}
+		e.nAcc -= 8
+	}
+	e.acc &= 1<<e.nAcc - 1
+}
+
+// flushBits pads the last byte of a scan with one bits.
+func (e *jpegEncoder) flushBits() {
+	if e.nAcc > 0 {
+		pad := 8 - e.nAcc
+		e.emitBits(1<<pad-1, pad)
+	}
+}
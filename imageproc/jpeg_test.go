@@ -0,0 +1,112 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"lanpaper/config"
+)
+
+// gradient returns an odd-sized image so padding and partial MCUs are
+// exercised.
+func gradient() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 77, 45))
+	for y := range 45 {
+		for x := range 77 {
+			img.Set(x, y, color.RGBA{uint8(x * 3), uint8(y * 5), uint8(x + y), 255})
+		}
+	}
+	return img
+}
+
+func TestEncodeJPEGOptions(t *testing.T) {
+	t.Cleanup(func() { config.Current = config.Config{} })
+	src := gradient()
+
+	tests := []struct {
+		name        string
+		chroma      string
+		progressive bool
+		sof         []byte // marker and the Y component's sampling factors
+	}{
+		{"stdlib default", "420", false, []byte{0xff, 0xc0}},
+		{"444 baseline", "444", false, []byte{0xff, 0xc0}},
+		{"420 progressive", "420", true, []byte{0xff, 0xc2}},
+		{"444 progressive", "444", true, []byte{0xff, 0xc2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current = config.Config{JPEGChroma: tt.chroma, JPEGProgressive: tt.progressive}
+			var buf bytes.Buffer
+			if err := Encode(&buf, src, "jpg", 90); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+			i := bytes.Index(data, tt.sof)
+			if i < 0 {
+				t.Fatalf("missing SOF marker % x", tt.sof)
+			}
+			// SOF: marker(2) length(2) precision(1) height(2) width(2) ncomp(1) id(1) sampling(1)
+			wantSampling := byte(0x22)
+			if tt.chroma == "444" {
+				wantSampling = 0x11
+			}
+			if got := data[i+11]; got != wantSampling {
+				t.Errorf("Y sampling = %#x, want %#x", got, wantSampling)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if img.Bounds() != src.Bounds() {
+				t.Fatalf("bounds = %v, want %v", img.Bounds(), src.Bounds())
+			}
+			for _, p := range []image.Point{{0, 0}, {40, 20}, {76, 44}} {
+				r0, g0, b0, _ := src.At(p.X, p.Y).RGBA()
+				r1, g1, b1, _ := img.At(p.X, p.Y).RGBA()
+				for _, d := range []int{int(r0>>8) - int(r1>>8), int(g0>>8) - int(g1>>8), int(b0>>8) - int(b1>>8)} {
+					if d < -24 || d > 24 {
+						t.Errorf("pixel %v = %d,%d,%d, want ~%d,%d,%d", p, r1>>8, g1>>8, b1>>8, r0>>8, g0>>8, b0>>8)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHuffSpecsComplete(t *testing.T) {
+	for i, s := range huffSpecs {
+		n := 0
+		for _, c := range s.counts {
+			n += int(c)
+		}
+		if n != len(s.values) {
+			t.Errorf("table %d: counts sum to %d, have %d values", i, n, len(s.values))
+		}
+		seen := map[uint8]bool{}
+		for _, v := range s.values {
+			if seen[v] {
+				t.Errorf("table %d: duplicate symbol %#x", i, v)
+			}
+			seen[v] = true
+		}
+		if i%2 == 1 {
+			// AC tables need EOB, ZRL and every (run, size 1-10) pair.
+			for run := range 16 {
+				for size := 1; size <= 10; size++ {
+					if !seen[uint8(run<<4|size)] {
+						t.Errorf("table %d: missing symbol %#x", i, run<<4|size)
+					}
+				}
+			}
+			if !seen[0x00] || !seen[0xf0] {
+				t.Errorf("table %d: missing EOB or ZRL", i)
+			}
+		}
+	}
+}
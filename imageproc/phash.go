@@ -0,0 +1,91 @@
+package imageproc
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"math/bits"
+	"slices"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// pHash parameters: the image is reduced to phashSize² grey pixels and the
+// lowest phashBits×phashBits DCT frequencies form the hash.
+const (
+	phashSize = 32
+	phashBits = 8
+)
+
+// phashCos[u][x] is cos((2x+1)uπ / 2N), the DCT-II basis.
+var phashCos = func() (c [phashBits][phashSize]float64) {
+	for u := range phashBits {
+		for x := range phashSize {
+			c[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+		}
+	}
+	return c
+}()
+
+// PHash returns the 64-bit perceptual hash of img: each bit tells whether
+// one of the 8×8 lowest DCT frequencies of its 32×32 greyscale reduction
+// lies above their median. Rescaled or recompressed copies of an image
+// hash within a few bits of each other; compare hashes with Distance.
+func PHash(img image.Image) uint64 {
+	small := image.NewRGBA(image.Rect(0, 0, phashSize, phashSize))
+	// CatmullRom widens its kernel when shrinking, so every source pixel
+	// counts; BiLinear would sample a few and alias on large images.
+	xdraw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var grey [phashSize][phashSize]float64
+	for y := range phashSize {
+		for x := range phashSize {
+			i := small.PixOffset(x, y)
+			p := small.Pix[i : i+3 : i+3]
+			grey[y][x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+
+	// Separable 2D DCT-II, keeping only the low frequencies: rows first,
+	// then columns of the row results.
+	var rows [phashSize][phashBits]float64
+	for y := range phashSize {
+		for u := range phashBits {
+			var sum float64
+			for x := range phashSize {
+				sum += grey[y][x] * phashCos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	var coeffs [phashBits * phashBits]float64
+	for v := range phashBits {
+		for u := range phashBits {
+			var sum float64
+			for y := range phashSize {
+				sum += rows[y][u] * phashCos[v][y]
+			}
+			coeffs[v*phashBits+u] = sum
+		}
+	}
+
+	// The DC term is the mean brightness, not structure, so it is left out
+	// of the median.
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	var h uint64
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << (63 - i)
+		}
+	}
+	return h
+}
+
+// Distance is the number of differing bits between two perceptual hashes;
+// 0 means visually identical, and under about 10 usually the same picture.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,73 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// scene draws a w×h picture with a diagonal gradient and a bright disc
+// left of center, so it has structure at several scales.
+func scene(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 80 * (fx + fy)
+			if math.Hypot(fx-0.35, fy-0.5) < 0.2 {
+				v = 240
+			}
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v * 0.8), uint8(v * 0.6), 255})
+		}
+	}
+	return img
+}
+
+func TestPHash(t *testing.T) {
+	orig := scene(640, 400)
+	h := PHash(orig)
+	if h == 0 {
+		t.Fatal("PHash of a structured image is 0")
+	}
+
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, orig, &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(&enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same scene mirrored puts the disc on the other side.
+	mirrored := image.NewRGBA(orig.Bounds())
+	for y := range 400 {
+		for x := range 640 {
+			mirrored.Set(639-x, y, orig.At(x, y))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		maxDist int
+		minDist int
+	}{
+		{"identical", orig, 0, 0},
+		{"downscaled", Thumbnail(orig, 160, 100), 4, 0},
+		{"redrawn at another size", scene(1920, 1200), 4, 0},
+		{"recompressed", recompressed, 4, 0},
+		{"mirrored", mirrored, 64, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Distance(h, PHash(tt.img))
+			if d > tt.maxDist || d < tt.minDist {
+				t.Errorf("distance = %d, want %d-%d", d, tt.minDist, tt.maxDist)
+			}
+		})
+	}
+}
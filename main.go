@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -23,9 +25,61 @@ import (
 var Version = "dev"
 
 func main() {
-	_ = godotenv.Load()
+	checkConfig := flag.Bool("check-config", false, "validate the configuration, print any problems and exit")
+	envFile := flag.String("env-file", "", "load environment variables from this file instead of ./.env (or set ENV_FILE)")
+	configFile := flag.String("config", "", "read settings from this JSON file instead of ./config.json")
+	portFlag := flag.String("port", "", "listen port; overrides PORT and config.json")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	writeDefault := flag.Bool("write-default-config", false, "write a config.json with every setting at its default (to --config if set) and exit")
+	force := flag.Bool("force", false, "with --write-default-config, overwrite an existing file")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println("lanpaper", Version)
+		return
+	}
+	if *writeDefault {
+		path := config.File
+		if *configFile != "" {
+			path = *configFile
+		}
+		if err := config.WriteDefault(path, *force); err != nil {
+			log.Fatalf("Write default config: %v", err)
+		}
+		fmt.Println("wrote", path)
+		return
+	}
+
+	loadEnvFile(*envFile)
+	if *configFile != "" {
+		if _, err := os.Stat(*configFile); err != nil {
+			log.Fatalf("Config file: %v", err)
+		}
+		config.File = *configFile
+	}
+	// Flags take precedence over env and config.json. Setting the env var
+	// lets config.Load validate the value like any other source.
+	if *portFlag != "" {
+		os.Setenv("PORT", *portFlag)
+	}
 	config.Load()
 
+	// Strict mode and --check-config turn the warnings Load logged into a
+	// hard failure, so misconfiguration is caught before serving.
+	if problems := config.Problems(); len(problems) > 0 && (*checkConfig || config.Current.Strict) {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "config: %s\n", p)
+		}
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Println("config: OK")
+		return
+	}
+	if effective, err := json.Marshal(config.Effective()); err == nil {
+		log.Printf("Effective config: %s", effective)
+	}
+
 	if config.Current.DisableAuth {
 		if config.Current.AdminUser == "" && config.Current.AdminPass == "" {
 			log.Println("Warning: no credentials provided — authentication disabled.")
@@ -35,32 +89,38 @@ func main() {
 	}
 
 	handlers.InitUploadSemaphore(config.Current.MaxConcurrentUploads)
+	handlers.InitDecodeSemaphore(config.Current.MaxConcurrentDecodes)
+	handlers.InitDecodeBudget(int64(config.Current.DecodeMemoryMB) << 20)
 
-	for _, d := range []string{"data", "external/images", "static/images/previews"} {
+	dirs := []string{"data", "static/images/previews"}
+	if !config.Current.DisableExternalImages {
+		dirs = append(dirs, "external/images")
+	}
+	if config.Current.TempDir != "" {
+		dirs = append(dirs, config.Current.TempDir)
+	}
+	for _, d := range dirs {
 		if err := os.MkdirAll(d, 0755); err != nil {
 			log.Printf("Warning: failed to create %s: %v", d, err)
 		}
 	}
 
+	storage.InitBackups(config.Current.BackupCount)
 	if err := storage.Global.Load(); err != nil {
 		log.Printf("Warning: failed to load wallpapers: %v", err)
 	}
 
 	go middleware.StartCleaner()
 
-	// Serve static files with long-lived cache for versioned assets.
-	// The app uses ?t=<timestamp> cache-busting on dynamic resources.
-	staticFS := http.FileServer(http.Dir("static"))
+	handlers.Assets = embeddedAssets
 	mux := http.NewServeMux()
-	mux.Handle("/static/", http.StripPrefix("/static/",
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Cache-Control", "public, max-age=86400")
-			staticFS.ServeHTTP(w, r)
-		}),
-	))
+	mux.Handle("/static/", http.StripPrefix("/static/", staticHandler()))
+	mux.Handle("/favicon.ico", faviconHandler())
 	mux.HandleFunc("/health", healthHandler)
 	mux.HandleFunc("/health/ready", readyHandler)
 	mux.HandleFunc("/admin", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.Admin)))
+	mux.HandleFunc("/api/login", middleware.WithSecurity(middleware.Login))
+	mux.HandleFunc("/api/logout", middleware.WithSecurity(middleware.Logout))
 	mux.HandleFunc("/api/wallpapers", middleware.WithSecurity(handlers.Wallpapers))
 	mux.HandleFunc("/api/compression-config", middleware.WithSecurity(handlers.GetCompressionConfig))
 	mux.HandleFunc("/api/link/", middleware.WithSecurity(middleware.MaybeBasicAuth(handleLinkRoutes)))
@@ -69,14 +129,27 @@ func main() {
 		middleware.WithSecurity(middleware.MaybeBasicAuth(
 			middleware.RateLimit(func() (int, int) {
 				return config.Current.Rate.UploadPerMin, config.Current.Rate.Burst
-			})(handlers.Upload),
+			})(middleware.Idempotent(handlers.Upload)),
 		)),
 	)
 	mux.HandleFunc("/api/external-images", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.ExternalImages)))
+	mux.HandleFunc("/api/external-images/stream", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.ExternalImagesStream)))
 	mux.HandleFunc("/api/external-image-preview", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.ExternalImagePreview)))
 	mux.HandleFunc("/api/regenerate-previews",
 		middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.RegeneratePreviews)),
 	)
+	mux.HandleFunc("/api/preview/", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.StoredPreview)))
+	mux.HandleFunc("/api/gallery", middleware.WithSecurity(middleware.PublicRateLimit(handlers.Gallery)))
+	mux.HandleFunc("/api/config/effective", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.EffectiveConfig)))
+	mux.HandleFunc("/api/reload", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.Reload)))
+	mux.HandleFunc("/api/repair", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.Repair)))
+	mux.HandleFunc("/api/usage", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.Usage)))
+	mux.HandleFunc("/api/similar/", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.Similar)))
+	mux.HandleFunc("/api/recategorize", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.Recategorize)))
+	mux.HandleFunc("/api/categories", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.Categories)))
+	mux.HandleFunc("/api/categories/rename", middleware.WithSecurity(middleware.MaybeBasicAuth(handlers.RenameCategory)))
+	mux.HandleFunc("/robots.txt", middleware.WithSecurity(handlers.Robots))
+	mux.HandleFunc("/gallery", handleGallery)
 	mux.HandleFunc("/", handlers.Public)
 
 	port := config.Current.Port
@@ -86,14 +159,18 @@ func main() {
 
 	srv := &http.Server{
 		Addr:    port,
-		Handler: mux,
+		Handler: middleware.AccessLog(withBasePath(config.Current.BasePath, mux)),
 		// ReadTimeout covers headers + body; WriteTimeout must exceed the download context timeout.
 		ReadTimeout:  time.Duration(config.HTTPReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(config.HTTPWriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(config.HTTPIdleTimeout) * time.Second,
 	}
 
+	// ListenAndServe returns as soon as Shutdown starts, so main waits on
+	// stopped for in-flight requests and background work to drain.
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 		<-ch
@@ -103,28 +180,96 @@ func main() {
 		if err := srv.Shutdown(ctx); err != nil {
 			log.Printf("Shutdown error: %v", err)
 		}
+		// Handlers are done; let their background tasks (pruning) finish
+		// so a save isn't cut off halfway.
+		bgCtx, bgCancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+		defer bgCancel()
+		if err := handlers.WaitBackground(bgCtx); err != nil {
+			log.Printf("Shutdown: background tasks still running: %v", err)
+		}
+		handlers.CloseMQTT()
 	}()
 
 	log.Printf("Lanpaper %s on %s (max upload %d MB, compression: %d%% quality, %d%% scale)",
 		Version, port, config.Current.MaxUploadMB, config.Current.Compression.Quality, config.Current.Compression.Scale)
-	log.Printf("Admin: http://localhost%s/admin", port)
+	log.Printf("Admin: http://localhost%s%s/admin", port, config.Current.BasePath)
 
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
+	<-stopped
 	log.Println("Server stopped.")
 }
 
-// handleLinkRoutes routes /api/link/{name}/pin to TogglePin, everything else to Link
+// loadEnvFile loads variables from the --env-file flag, else ENV_FILE, else
+// ./.env. Variables already in the environment are never overridden. An
+// explicitly named file that can't be read is fatal; a missing ./.env is not.
+func loadEnvFile(flagPath string) {
+	path := flagPath
+	if path == "" {
+		path = os.Getenv("ENV_FILE")
+	}
+	if path == "" {
+		_ = godotenv.Load()
+		return
+	}
+	if err := godotenv.Load(path); err != nil {
+		log.Fatalf("Failed to load env file %s: %v", path, err)
+	}
+}
+
+// withBasePath serves h under prefix: the prefix is stripped before routing,
+// so handlers and the mux see the same paths as without one. The bare prefix
+// redirects to prefix + "/", and anything outside it is a 404.
+func withBasePath(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	stripped := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == prefix:
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, prefix+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// handleLinkRoutes routes /api/link/{name}/pin to TogglePin,
+// /api/link/{name}/touch to Touch, /api/link/{name}/qr to LinkQR,
+// everything else to Link. Routing goes by path alone so each handler
+// answers other methods with its own 405 and Allow header.
 func handleLinkRoutes(w http.ResponseWriter, r *http.Request) {
-	// Check if this is a pin toggle request (must be POST to /pin)
-	if strings.HasSuffix(r.URL.Path, "/pin") && r.Method == http.MethodPost {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/link/"), "/")
+	_, action, _ := strings.Cut(rest, "/")
+	switch action {
+	case "pin":
 		handlers.TogglePin(w, r)
-	} else {
+	case "touch":
+		handlers.Touch(w, r)
+	case "qr":
+		handlers.LinkQR(w, r)
+	default:
 		handlers.Link(w, r)
 	}
 }
 
+// galleryHTML is the secured handler handleGallery serves /gallery with.
+var galleryHTML = middleware.WithSecurity(handlers.GalleryHTML)
+
+// handleGallery serves the gallery page when PublicGallery is on, and
+// otherwise treats /gallery as a link like any other path.
+func handleGallery(w http.ResponseWriter, r *http.Request) {
+	if config.Current.PublicGallery {
+		galleryHTML(w, r)
+		return
+	}
+	handlers.Public(w, r)
+}
+
 func healthHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lanpaper/config"
+	"lanpaper/handlers"
+	"lanpaper/middleware"
+	"lanpaper/storage"
+)
+
+func TestEmbeddedAssets(t *testing.T) {
+	t.Chdir(t.TempDir()) // no admin.html or static/ on disk
+	config.Load()
+	t.Cleanup(func() { config.Current = config.Config{} })
+	handlers.Assets = embeddedAssets
+	t.Cleanup(func() { handlers.Assets = nil })
+
+	rec := httptest.NewRecorder()
+	middleware.WithSecurity(handlers.Admin)(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `src="/static/js/app.js"`) {
+		t.Fatalf("admin page: %d %.200s", rec.Code, rec.Body)
+	}
+
+	static := http.StripPrefix("/static/", staticHandler())
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/static/js/app.js", http.StatusOK},
+		{"/static/css/style.css", http.StatusOK},
+		{"/static/images/.gitkeep", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		static.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestStaticCacheControl(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := filepath.Join("static", "images", "previews")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.webp", "a.0123456789ab.webp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	static := http.StripPrefix("/static/", staticHandler())
+	for _, tt := range []struct{ path, want string }{
+		{"/static/images/previews/a.webp", "public, max-age=86400"},
+		{"/static/images/previews/a.0123456789ab.webp", "public, max-age=31536000, immutable"},
+	} {
+		rec := httptest.NewRecorder()
+		static.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := rec.Header().Get("Cache-Control"); rec.Code != http.StatusOK || got != tt.want {
+			t.Errorf("GET %s = %d, Cache-Control %q, want %q", tt.path, rec.Code, got, tt.want)
+		}
+	}
+}
+
+func TestFavicon(t *testing.T) {
+	t.Chdir(t.TempDir()) // served from the embedded copy
+	rec := httptest.NewRecorder()
+	faviconHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Fatalf("status %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", ct)
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age=604800") {
+		t.Errorf("Cache-Control = %q, want a week-long max-age", cc)
+	}
+}
+
+func TestGalleryRoute(t *testing.T) {
+	storage.Global.Set("gallery", &storage.Wallpaper{ID: "gallery", LinkName: "gallery", HasImage: true, MIMEType: "image/png"})
+	t.Cleanup(func() {
+		storage.Global.Delete("gallery")
+		config.Current = config.Config{}
+	})
+	get := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/gallery", nil)
+		r.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handleGallery(rec, r)
+		return rec
+	}
+
+	config.Current = config.Config{}
+	if rec := get(); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"gallery"`) {
+		t.Errorf("disabled gallery: status %d %s, want the link's metadata", rec.Code, rec.Body)
+	}
+	config.Current = config.Config{PublicGallery: true}
+	if rec := get(); !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("enabled gallery: Content-Type = %q, want the gallery page", rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"lanpaper/config"
+)
+
+// statusRecorder captures the status code and body size written by a handler.
+// It forwards Flush and ReadFrom so http.ServeContent keeps its sendfile and
+// range-request fast paths.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := s.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(s.ResponseWriter, r)
+	}
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+type accessEntry struct {
+	Time       string  `json:"time"`
+	ClientIP   string  `json:"clientIp"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"durationMs"`
+}
+
+// openAccessLog resolves the AccessLog setting to a logger.
+// "true"/"1"/"stdout" log to stdout; any other value is treated as a file path.
+func openAccessLog(target string) (*log.Logger, error) {
+	switch strings.ToLower(target) {
+	case "", "false", "0", "off":
+		return nil, nil
+	case "true", "1", "on", "stdout":
+		return log.New(os.Stdout, "", 0), nil
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(f, "", 0), nil
+}
+
+// AccessLog wraps h with per-request logging when config.Current.AccessLog is
+// set. It is applied once at startup as the outermost middleware; when the
+// setting is empty h is returned unchanged.
+func AccessLog(h http.Handler) http.Handler {
+	logger, err := openAccessLog(config.Current.AccessLog)
+	if err != nil {
+		log.Printf("Warning: cannot open access log %q: %v — access logging disabled", config.Current.AccessLog, err)
+		return h
+	}
+	if logger == nil {
+		return h
+	}
+	jsonFormat := config.Current.AccessLogFormat == "json"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+
+		if jsonFormat {
+			line, _ := json.Marshal(accessEntry{
+				Time:       start.Format(time.RFC3339),
+				ClientIP:   clientIP(r),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Proto:      r.Proto,
+				Status:     rec.status,
+				Bytes:      rec.bytes,
+				DurationMS: float64(elapsed.Microseconds()) / 1000,
+			})
+			logger.Println(string(line))
+			return
+		}
+		// Common Log Format, with the request duration appended. Like the
+		// JSON format it logs the path only: query strings can carry tokens.
+		logger.Printf("%s - - [%s] %s %d %d %s",
+			clientIP(r), start.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(r.Method+" "+r.URL.Path+" "+r.Proto),
+			rec.status, rec.bytes, elapsed.Round(time.Microsecond))
+	})
+}
@@ -8,11 +8,14 @@ import (
 	"lanpaper/config"
 )
 
-// MaybeBasicAuth applies Basic Auth only when auth is enabled.
+// MaybeBasicAuth applies Basic Auth only when auth is enabled, accepting a
+// session cookie from Login instead of credentials. Browser requests that
+// change state must also pass the CSRF check.
 // Checked per-request so runtime config changes take effect immediately.
 func MaybeBasicAuth(next http.HandlerFunc) http.HandlerFunc {
+	next = csrfProtect(next)
 	return func(w http.ResponseWriter, r *http.Request) {
-		if config.Current.DisableAuth {
+		if config.Current.DisableAuth || validSession(r) {
 			next(w, r)
 			return
 		}
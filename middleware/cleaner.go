@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"lanpaper/config"
+)
+
+// cleanable is a map registered with RegisterCleanable.
+type cleanable struct {
+	name  string
+	sweep func(now time.Time)
+}
+
+var (
+	muCleanables sync.Mutex
+	cleanables   []cleanable
+)
+
+// RegisterCleanable adds m, guarded by mu, to the maps StartCleaner sweeps,
+// so stateful middleware gets expiry without a goroutine of its own. On
+// every sweep, entries whose stamp is more than ttl old are deleted; stamp
+// returns false for entries that must stay regardless of age. Registering
+// a name again replaces the earlier registration.
+func RegisterCleanable[K comparable, V any](name string, ttl time.Duration, mu sync.Locker, m map[K]V, stamp func(V) (time.Time, bool)) {
+	c := cleanable{name: name, sweep: func(now time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		for k, v := range m {
+			if t, ok := stamp(v); ok && now.Sub(t) > ttl {
+				delete(m, k)
+			}
+		}
+	}}
+	muCleanables.Lock()
+	defer muCleanables.Unlock()
+	for i := range cleanables {
+		if cleanables[i].name == name {
+			cleanables[i] = c
+			return
+		}
+	}
+	cleanables = append(cleanables, c)
+}
+
+// sweepCleanables runs one sweep over every registered map.
+func sweepCleanables(now time.Time) {
+	muCleanables.Lock()
+	cs := append([]cleanable(nil), cleanables...)
+	muCleanables.Unlock()
+	for _, c := range cs {
+		c.sweep(now)
+	}
+}
+
+// StartCleaner sweeps the maps registered with RegisterCleanable every
+// RateLimitCleanerInterval, so a TTL shorter than that is only honoured to
+// the interval. Call once from main; runs until the process exits.
+func StartCleaner() {
+	ticker := time.NewTicker(time.Duration(config.RateLimitCleanerInterval) * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		sweepCleanables(now)
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegisterCleanable(t *testing.T) {
+	type entry struct {
+		at     time.Time
+		pinned bool
+	}
+	var mu sync.Mutex
+	m := map[string]entry{}
+	RegisterCleanable("test", 50*time.Millisecond, &mu, m, func(e entry) (time.Time, bool) {
+		return e.at, !e.pinned
+	})
+
+	now := time.Now()
+	m["old"] = entry{at: now.Add(-time.Second)}
+	m["fresh"] = entry{at: now}
+	m["pinned"] = entry{at: now.Add(-time.Second), pinned: true}
+
+	sweepCleanables(now)
+	if _, ok := m["old"]; ok {
+		t.Error("entry older than the TTL survived the sweep")
+	}
+	if _, ok := m["fresh"]; !ok {
+		t.Error("entry within the TTL was evicted")
+	}
+	if _, ok := m["pinned"]; !ok {
+		t.Error("entry whose stamp opted out was evicted")
+	}
+
+	sweepCleanables(now.Add(time.Second))
+	if _, ok := m["fresh"]; ok {
+		t.Error("entry survived once its TTL passed")
+	}
+}
+
+func TestCleanerSweepsRateLimits(t *testing.T) {
+	isOverLimitNS("cleaner-test", "192.0.2.9", 1, 0)
+	sweepCleanables(time.Now().Add(2 * time.Minute))
+	muCounts.Lock()
+	_, ok := counts["cleaner-test:192.0.2.9"]
+	muCounts.Unlock()
+	if ok {
+		t.Error("rate-limit counter outlived its window")
+	}
+}
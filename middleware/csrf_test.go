@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	t.Cleanup(func() { config.Current = config.Config{} })
+	config.Current = config.Config{AdminUser: "admin", AdminPass: "pass", SessionSecret: "s3cret"}
+
+	h := MaybeBasicAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	session := &http.Cookie{Name: sessionCookie, Value: newSession(time.Now().Add(time.Hour))}
+	basic := func(r *http.Request) { r.SetBasicAuth("admin", "pass") }
+	cookie := func(r *http.Request) { r.AddCookie(session) }
+	withToken := func(r *http.Request) { r.Header.Set(csrfHeader, CSRFToken(r)) }
+	header := func(k, v string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set(k, v) }
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		setup  []func(*http.Request)
+		code   int
+	}{
+		{"session get", http.MethodGet, []func(*http.Request){cookie}, http.StatusOK},
+		{"session post without token", http.MethodPost, []func(*http.Request){cookie}, http.StatusForbidden},
+		{"session post with token", http.MethodPost, []func(*http.Request){cookie, withToken}, http.StatusOK},
+		{"session delete wrong token", http.MethodDelete, []func(*http.Request){cookie, header(csrfHeader, "nope")}, http.StatusForbidden},
+		{"basic auth script", http.MethodPost, []func(*http.Request){basic}, http.StatusOK},
+		{"basic auth browser without token", http.MethodPost, []func(*http.Request){basic, header("Origin", "https://evil.example")}, http.StatusForbidden},
+		{"basic auth browser with token", http.MethodPost, []func(*http.Request){basic, header("Sec-Fetch-Site", "same-origin"), withToken}, http.StatusOK},
+		{"unauthenticated", http.MethodPost, nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/link", nil)
+			for _, f := range tt.setup {
+				f(req)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("status = %d, want %d", rec.Code, tt.code)
+			}
+		})
+	}
+
+	// A token is only good for the session it was issued to.
+	other := httptest.NewRequest(http.MethodPost, "/api/link", nil)
+	other.AddCookie(&http.Cookie{Name: sessionCookie, Value: newSession(time.Now().Add(2 * time.Hour))})
+	stolen := httptest.NewRequest(http.MethodPost, "/api/link", nil)
+	stolen.AddCookie(session)
+	if CSRFToken(other) == CSRFToken(stolen) {
+		t.Error("two sessions share a CSRF token")
+	}
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"lanpaper/config"
+)
+
+// maxIdempotencyKeyLen and maxIdempotentBody bound what a client can make
+// the server hold in memory per key.
+const (
+	maxIdempotencyKeyLen = 255
+	maxIdempotentBody    = 64 << 10
+)
+
+// idemEntry is a request seen with an Idempotency-Key. done is false while
+// the first request is still being handled.
+type idemEntry struct {
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	stored      time.Time
+}
+
+var (
+	muIdem   sync.Mutex
+	idemKeys = map[string]*idemEntry{}
+)
+
+// Results are dropped IdempotencyTTL after they were stored; requests still
+// in progress are kept however long they take.
+func init() {
+	RegisterCleanable("idempotency", time.Duration(config.IdempotencyTTL)*time.Second, &muIdem, idemKeys,
+		func(e *idemEntry) (time.Time, bool) { return e.stored, e.done })
+}
+
+// idemRecorder passes the response through while keeping a copy of it.
+type idemRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *idemRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idemRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > maxIdempotentBody {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotent makes retries of a request carrying an Idempotency-Key header
+// safe: the first response is cached for IdempotencyTTL and replayed for the
+// same key instead of running next again. A replay that arrives while the
+// original is still running gets 409. Server errors, rate-limit responses
+// and requests the handler left unanswered are not cached, so the client
+// can retry them for real.
+func Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+		// Scope keys to the client and route so one client can't replay
+		// another's response by guessing its key.
+		key = clientIP(r) + " " + r.URL.Path + " " + key
+
+		muIdem.Lock()
+		if e, ok := idemKeys[key]; ok {
+			muIdem.Unlock()
+			if !e.done {
+				http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+				return
+			}
+			// Only the content type is replayed; the other headers are
+			// per-response (security headers, CSP nonce) and already set.
+			if e.contentType != "" {
+				w.Header().Set("Content-Type", e.contentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			_, _ = w.Write(e.body)
+			return
+		}
+		idemKeys[key] = &idemEntry{}
+		muIdem.Unlock()
+
+		// If next panics, drop the placeholder so the key isn't stuck
+		// answering 409 until restart; the cleaner never prunes it.
+		completed := false
+		defer func() {
+			if !completed {
+				muIdem.Lock()
+				delete(idemKeys, key)
+				muIdem.Unlock()
+			}
+		}()
+
+		rec := &idemRecorder{ResponseWriter: w}
+		next(rec, r)
+		completed = true
+
+		// A handler that wrote nothing gave up, e.g. on a client that went
+		// away mid-upload; its retry must run for real, not replay an
+		// empty 200.
+		muIdem.Lock()
+		defer muIdem.Unlock()
+		if rec.status == 0 || rec.overflow || rec.status >= 500 || rec.status == http.StatusTooManyRequests {
+			delete(idemKeys, key)
+			return
+		}
+		idemKeys[key] = &idemEntry{
+			done:        true,
+			status:      rec.status,
+			contentType: w.Header().Get("Content-Type"),
+			body:        rec.body.Bytes(),
+			stored:      time.Now(),
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdempotentReplay(t *testing.T) {
+	calls := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"id":"first"}`))
+	})
+
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", nil)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	first := send("abc")
+	replay := send("abc")
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if replay.Code != first.Code || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay not marked with Idempotent-Replayed")
+	}
+	if ct := replay.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("replay Content-Type = %q", ct)
+	}
+
+	send("other")
+	send("")
+	send("")
+	if calls != 4 {
+		t.Errorf("handler ran %d times, want 4 (new key and keyless requests run)", calls)
+	}
+}
+
+func TestIdempotentSkipsServerErrors(t *testing.T) {
+	calls := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", nil)
+		req.Header.Set("Idempotency-Key", "retry-me")
+		h(httptest.NewRecorder(), req)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2 (5xx results are not cached)", calls)
+	}
+}
+
+func TestIdempotentReleasesKeyAfterPanic(t *testing.T) {
+	calls := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", nil)
+		req.Header.Set("Idempotency-Key", "panics-once")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	func() {
+		defer func() { _ = recover() }()
+		send()
+	}()
+	if rec := send(); rec.Code != http.StatusCreated {
+		t.Errorf("retry after panic: status %d, want 201", rec.Code)
+	}
+}
+
+func TestIdempotentSkipsEmptyResponses(t *testing.T) {
+	calls := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			return // gave up without answering, like a cancelled upload
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	var rec *httptest.ResponseRecorder
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", nil)
+		req.Header.Set("Idempotency-Key", "wrote-nothing")
+		rec = httptest.NewRecorder()
+		h(rec, req)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2 (empty responses are not cached)", calls)
+	}
+	if rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry: status %d, replayed %q; want a fresh 201", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+	}
+}
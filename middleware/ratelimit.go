@@ -21,22 +21,11 @@ var (
 	counts   = map[string]*counter{}
 )
 
-// StartCleaner removes stale per-IP counters periodically.
-// Call once from main; runs until the process exits.
-func StartCleaner() {
-	cleanerInterval := time.Duration(config.RateLimitCleanerInterval) * time.Second
-	ticker := time.NewTicker(cleanerInterval)
-	defer ticker.Stop()
-	for range ticker.C {
-		now := time.Now()
-		muCounts.Lock()
-		for key, c := range counts {
-			if now.Sub(c.windowFrom) > time.Minute {
-				delete(counts, key)
-			}
-		}
-		muCounts.Unlock()
-	}
+// Counters outlive their one-minute window only until the next sweep.
+func init() {
+	RegisterCleanable("ratelimit", time.Minute, &muCounts, counts, func(c *counter) (time.Time, bool) {
+		return c.windowFrom, true
+	})
 }
 
 func isOverLimitNS(ns, ip string, perMin, burst int) bool {
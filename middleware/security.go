@@ -110,3 +110,15 @@ func WithSecurity(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	}
 }
+
+// PublicRateLimit applies the public-endpoint rate limit to an /api/ route
+// that is served without auth. WithSecurity only limits non-API paths.
+func PublicRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isOverLimit(clientIP(r), config.Current.Rate.PublicPerMin, config.Current.Rate.Burst) {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lanpaper/config"
+	"lanpaper/utils"
+)
+
+// sessionCookie names the cookie POST /api/login sets.
+const sessionCookie = "lanpaper_session"
+
+// maxLoginBody caps the /api/login request body.
+const maxLoginBody = 4 << 10
+
+var (
+	generatedKeyOnce sync.Once
+	generatedKey     []byte
+)
+
+// sessionKey is the HMAC key session cookies are signed with: SessionSecret,
+// or a random key made on first use when none is configured. A generated
+// key lives only as long as the process, so a restart logs everyone out.
+func sessionKey() []byte {
+	if s := config.Current.SessionSecret; s != "" {
+		return []byte(s)
+	}
+	generatedKeyOnce.Do(func() {
+		generatedKey = make([]byte, 32)
+		if _, err := rand.Read(generatedKey); err != nil {
+			panic("session key: " + err.Error())
+		}
+	})
+	return generatedKey
+}
+
+// sessionMAC signs a session expiring at exp. The admin credentials are
+// part of the message, so changing them ends every session.
+func sessionMAC(exp string) []byte {
+	m := hmac.New(sha256.New, sessionKey())
+	m.Write([]byte(exp + "\x00" + config.Current.AdminUser + "\x00" + config.Current.AdminPass))
+	return m.Sum(nil)
+}
+
+// newSession returns a cookie value valid until exp: the Unix expiry and
+// its signature.
+func newSession(exp time.Time) string {
+	e := strconv.FormatInt(exp.Unix(), 10)
+	return e + "." + base64.RawURLEncoding.EncodeToString(sessionMAC(e))
+}
+
+// validSession reports whether r carries an unexpired, correctly signed
+// session cookie.
+func validSession(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	e, sig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(e, 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	return err == nil && hmac.Equal(mac, sessionMAC(e))
+}
+
+// setSessionCookie sends the session cookie; maxAge < 0 deletes it.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     config.URLPath("/"),
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		// Secure only over HTTPS, or the cookie would never come back on a
+		// plain-HTTP LAN.
+		Secure: utils.RequestScheme(r) == "https",
+		// Lax still sends the cookie when following a link to /admin but
+		// not with cross-site POSTs.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Login handles POST /api/login: {"username": "...", "password": "..."}
+// checked against the admin credentials sets a signed session cookie that
+// MaybeBasicAuth accepts in place of Basic Auth. Answers 204 on success
+// and 401, without a Basic challenge, on bad credentials.
+func Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := clientIP(r)
+	if isOverLimitNS("login", ip, config.Current.Rate.UploadPerMin, config.Current.Rate.Burst) {
+		log.Printf("Login rate limit exceeded for IP: %s", ip)
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBody)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if config.Current.DisableAuth {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// Both comparisons run so timing doesn't reveal a valid username.
+	userOK := secureCompare(req.Username, config.Current.AdminUser)
+	passOK := secureCompare(req.Password, config.Current.AdminPass)
+	if !userOK || !passOK {
+		log.Printf("Failed login attempt from %s", ip)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	setSessionCookie(w, r, newSession(time.Now().Add(config.SessionMaxAge*time.Second)), config.SessionMaxAge)
+	log.Printf("Admin logged in from %s", ip)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Logout handles POST /api/logout: deletes the session cookie. Basic Auth
+// credentials a browser has cached are not affected.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	setSessionCookie(w, r, "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
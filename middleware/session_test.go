@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lanpaper/config"
+)
+
+func TestSessionLogin(t *testing.T) {
+	t.Cleanup(func() { config.Current = config.Config{} })
+	config.Current = config.Config{AdminUser: "admin", AdminPass: "pass", SessionSecret: "s3cret"}
+
+	protected := MaybeBasicAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	get := func(c *http.Cookie) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/wallpapers", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		protected(rec, req)
+		return rec.Code
+	}
+	login := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		Login(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := login(`{"username":"admin","password":"wrong"}`); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("bad password: %d, WWW-Authenticate %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := login(`{`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad JSON: %d", rec.Code)
+	}
+
+	rec := login(`{"username":"admin","password":"pass"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("session cookie = %+v", cookies)
+	}
+	session := cookies[0]
+
+	if code := get(nil); code != http.StatusUnauthorized {
+		t.Errorf("no cookie: %d, want 401", code)
+	}
+	if code := get(session); code != http.StatusOK {
+		t.Errorf("session cookie: %d, want 200", code)
+	}
+
+	tampered := *session
+	tampered.Value = strings.Replace(session.Value, session.Value[:1], "9", 1)
+	if code := get(&tampered); code != http.StatusUnauthorized {
+		t.Errorf("tampered expiry: %d, want 401", code)
+	}
+	expired := &http.Cookie{Name: sessionCookie, Value: newSession(time.Now().Add(-time.Minute))}
+	if code := get(expired); code != http.StatusUnauthorized {
+		t.Errorf("expired session: %d, want 401", code)
+	}
+
+	config.Current.AdminPass = "changed"
+	if code := get(session); code != http.StatusUnauthorized {
+		t.Errorf("session after password change: %d, want 401", code)
+	}
+
+	rec = httptest.NewRecorder()
+	Logout(rec, httptest.NewRequest(http.MethodPost, "/api/logout", nil))
+	if c := rec.Result().Cookies(); rec.Code != http.StatusNoContent || len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("logout: %d, cookies %+v", rec.Code, c)
+	}
+}
+
+func TestSessionCookieSecure(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("TRUSTED_PROXY", "10.0.0.1")
+	t.Setenv("ADMIN_USER", "admin")
+	t.Setenv("ADMIN_PASS", "pass")
+	config.Load()
+	t.Cleanup(func() { config.Current = config.Config{} })
+
+	tests := []struct {
+		name   string
+		remote string
+		tls    bool
+		proto  string
+		secure bool
+	}{
+		{"plain http", "192.0.2.1:1234", false, "", false},
+		{"direct tls", "192.0.2.1:1234", true, "", true},
+		{"trusted proxy https", "10.0.0.1:1234", false, "https", true},
+		{"trusted proxy http", "10.0.0.1:1234", false, "http", false},
+		{"untrusted proxy https", "192.0.2.1:1234", false, "https", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"admin","password":"pass"}`))
+			req.RemoteAddr = tt.remote
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			rec := httptest.NewRecorder()
+			Login(rec, req)
+			cookies := rec.Result().Cookies()
+			if rec.Code != http.StatusNoContent || len(cookies) != 1 {
+				t.Fatalf("login: %d, cookies %+v", rec.Code, cookies)
+			}
+			if cookies[0].Secure != tt.secure {
+				t.Errorf("Secure = %v, want %v", cookies[0].Secure, tt.secure)
+			}
+		})
+	}
+}
@@ -0,0 +1,507 @@
+// Package qrcode encodes short byte strings, such as URLs, as QR codes
+// (ISO/IEC 18004) in byte mode at error correction level M and renders
+// them as PNG-ready images or SVG.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// MaxVersion is the largest symbol Encode produces: 57×57 modules, enough
+// for 213 bytes at level M.
+const MaxVersion = 10
+
+// QuietZone is the light border, in modules, that Image and SVG add around
+// the symbol; scanners need it to find the finder patterns.
+const QuietZone = 4
+
+// ErrTooLong is returned when the data does not fit in MaxVersion.
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// Level M error correction: codewords per block and number of blocks,
+// indexed by version.
+var (
+	eccPerBlock = [MaxVersion + 1]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	numBlocks   = [MaxVersion + 1]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// Code is an encoded QR symbol.
+type Code struct {
+	Size    int // modules per side
+	modules []bool
+	fixed   []bool // function patterns, excluded from data and masking
+}
+
+// Encode returns data as a QR code of the smallest version that holds it.
+func Encode(data []byte) (*Code, error) {
+	ver := 1
+	for ; ver <= MaxVersion; ver++ {
+		if 4+countBits(ver)+8*len(data) <= 8*dataCodewords(ver) {
+			break
+		}
+	}
+	if ver > MaxVersion {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLong, len(data))
+	}
+
+	c := newCode(ver)
+	c.drawCodewords(interleave(ver, dataBytes(ver, data)))
+
+	best, bestPenalty := 0, -1
+	for m := range 8 {
+		c.applyMask(m)
+		c.drawFormat(m)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = m, p
+		}
+		c.applyMask(m) // XOR again to undo
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return c, nil
+}
+
+// Dark reports whether the module at column x, row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y*c.Size+x]
+}
+
+// Image renders the code, quiet zone included, into a px×px image with
+// whole-pixel modules; leftover pixels widen the light border. The image
+// is larger than px if px can't fit one pixel per module.
+func (c *Code) Image(px int) *image.Paletted {
+	n := c.Size + 2*QuietZone
+	px = max(px, n)
+	scale := px / n
+	off := (px-scale*n)/2 + QuietZone*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, px, px), color.Palette{color.White, color.Black})
+	for y := range c.Size {
+		for x := range c.Size {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for dy := range scale {
+				row := img.Pix[(off+y*scale+dy)*img.Stride:]
+				for dx := range scale {
+					row[off+x*scale+dx] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+// SVG renders the code, quiet zone included, as a scalable SVG document
+// px wide; the drawing is one path in module units.
+func (c *Code) SVG(px int) []byte {
+	n := c.Size + 2*QuietZone
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, px, px, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y := range c.Size {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			run := 1
+			for x+run < c.Size && c.Dark(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", x+QuietZone, y+QuietZone, run, run)
+			x += run - 1
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
+
+// countBits is the width of the byte-mode character count field.
+func countBits(ver int) int {
+	if ver < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawModules is the number of modules of a version left for data and
+// error correction once the function patterns are drawn.
+func rawModules(ver int) int {
+	n := (16*ver+128)*ver + 64
+	if ver >= 2 {
+		align := ver/7 + 2
+		n -= (25*align-10)*align - 55
+		if ver >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func dataCodewords(ver int) int {
+	return rawModules(ver)/8 - eccPerBlock[ver]*numBlocks[ver]
+}
+
+// dataBytes builds the data codewords: mode, count, payload, terminator
+// and the alternating pad bytes.
+func dataBytes(ver int, data []byte) []byte {
+	capacity := dataCodewords(ver)
+	var bits []byte // one bit per element
+	put := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, byte(v>>i&1))
+		}
+	}
+	put(0b0100, 4)
+	put(len(data), countBits(ver))
+	for _, d := range data {
+		put(int(d), 8)
+	}
+	put(0, min(4, capacity*8-len(bits)))
+	put(0, (8-len(bits)%8)%8)
+
+	out := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var v byte
+		for _, bit := range bits[i : i+8] {
+			v = v<<1 | bit
+		}
+		out = append(out, v)
+	}
+	for pad := byte(0xec); len(out) < capacity; pad ^= 0xec ^ 0x11 {
+		out = append(out, pad)
+	}
+	return out
+}
+
+// interleave splits data into blocks, appends each block's error
+// correction and interleaves the result column by column.
+func interleave(ver int, data []byte) []byte {
+	blocks, ecc := numBlocks[ver], eccPerBlock[ver]
+	raw := rawModules(ver) / 8
+	short := blocks - raw%blocks
+	shortLen := raw / blocks
+	div := rsDivisor(ecc)
+
+	var all [][]byte
+	k := 0
+	for i := range blocks {
+		n := shortLen - ecc
+		if i >= short {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		if i < short {
+			block = append(block, 0) // placeholder, skipped below
+		}
+		all = append(all, append(block, rsRemainder(data[k-n:k], div)...))
+	}
+
+	out := make([]byte, 0, raw)
+	for i := range all[0] {
+		for j, block := range all {
+			if i != shortLen-ecc || j >= short {
+				out = append(out, block[i])
+			}
+		}
+	}
+	return out
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		hi := z >> 7
+		z = z<<1 ^ hi*0x1d
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given
+// degree, highest coefficient (always 1) omitted.
+func rsDivisor(degree int) []byte {
+	div := make([]byte, degree)
+	div[degree-1] = 1
+	root := byte(1)
+	for range degree {
+		for j := range div {
+			div[j] = gfMul(div[j], root)
+			if j+1 < len(div) {
+				div[j] ^= div[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return div
+}
+
+func rsRemainder(data, div []byte) []byte {
+	rem := make([]byte, len(div))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i := range rem {
+			rem[i] ^= gfMul(div[i], factor)
+		}
+	}
+	return rem
+}
+
+// newCode returns a blank symbol with the function patterns drawn; the
+// format area is reserved and filled in by drawFormat.
+func newCode(ver int) *Code {
+	size := ver*4 + 17
+	c := &Code{Size: size, modules: make([]bool, size*size), fixed: make([]bool, size*size)}
+
+	for i := range size {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(size-4, 3)
+	c.drawFinder(3, size-4)
+
+	pos := alignmentPositions(ver)
+	last := len(pos) - 1
+	for i, x := range pos {
+		for j, y := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue // overlaps a finder
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	c.drawFormat(0)
+	if ver >= 7 {
+		rem := ver
+		for range 12 {
+			rem = rem<<1 ^ (rem>>11)*0x1f25
+		}
+		bits := ver<<12 | rem
+		for i := range 18 {
+			dark := bits>>i&1 != 0
+			a, b := size-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+	return c
+}
+
+func alignmentPositions(ver int) []int {
+	if ver == 1 {
+		return nil
+	}
+	n := ver/7 + 2
+	step := (ver*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, ver*4+10; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// set draws a function module.
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.fixed[y*c.Size+x] = true
+}
+
+// drawFinder draws a finder pattern and its separator centred on (x, y).
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= c.Size || yy < 0 || yy >= c.Size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			c.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// formatBits is the 15-bit format word for level M and mask.
+func formatBits(mask int) int {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat writes both copies of the format word for mask.
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+	for i := range 6 {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+	for i := range 8 {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true) // the dark module
+}
+
+// zigzag calls fn for every data module in placement order: two-column
+// strips from the right edge, alternating upward and downward, skipping
+// the vertical timing column.
+func (c *Code) zigzag(fn func(x, y int)) {
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := range c.Size {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := range 2 {
+				if x := right - j; !c.fixed[y*c.Size+x] {
+					fn(x, y)
+				}
+			}
+		}
+	}
+}
+
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	c.zigzag(func(x, y int) {
+		if i < len(data)*8 {
+			c.modules[y*c.Size+x] = data[i>>3]>>(7-i&7)&1 != 0
+			i++
+		}
+	})
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask XORs mask into the data modules; applying it twice undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := range c.Size {
+		for x := range c.Size {
+			if i := y*c.Size + x; !c.fixed[i] && maskBit(mask, x, y) {
+				c.modules[i] = !c.modules[i]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol with the four mask evaluation rules; the
+// mask with the lowest score is used.
+func (c *Code) penalty() int {
+	n := c.Size
+	p := 0
+	line := make([]bool, n)
+	for _, vertical := range []bool{false, true} {
+		for a := range n {
+			for b := range n {
+				if vertical {
+					line[b] = c.Dark(a, b)
+				} else {
+					line[b] = c.Dark(b, a)
+				}
+			}
+			// Rule 1: runs of five or more same-coloured modules.
+			run := 1
+			for b := 1; b <= n; b++ {
+				if b < n && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += run - 2
+				}
+				run = 1
+			}
+			// Rule 3: finder-like 1:1:3:1:1 with four light modules on
+			// either side; outside the symbol counts as light.
+			at := func(i int) bool { return i >= 0 && i < n && line[i] }
+			for b := -4; b < n; b++ {
+				if !(at(b) && !at(b+1) && at(b+2) && at(b+3) && at(b+4) && !at(b+5) && at(b+6)) {
+					continue
+				}
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					before = before && !at(b-k)
+					after = after && !at(b+6+k)
+				}
+				if before || after {
+					p += 40
+				}
+			}
+		}
+	}
+	// Rule 2: 2×2 blocks of one colour.
+	for y := range n - 1 {
+		for x := range n - 1 {
+			d := c.Dark(x, y)
+			if d == c.Dark(x+1, y) && d == c.Dark(x, y+1) && d == c.Dark(x+1, y+1) {
+				p += 3
+			}
+		}
+	}
+	// Rule 4: 10 points per 5% the dark share strays from 50%.
+	dark := 0
+	for _, m := range c.modules {
+		if m {
+			dark++
+		}
+	}
+	total := n * n
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	p += max(k, 0) * 10
+	return p
+}
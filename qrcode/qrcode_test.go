@@ -0,0 +1,139 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the worked example in the standard.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("ecc = %v, want %v", got, want)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	for mask, want := range map[int]int{0: 0b101010000010010, 1: 0b101000100100101, 7: 0b100101010100000} {
+		if got := formatBits(mask); got != want {
+			t.Errorf("formatBits(%d) = %015b, want %015b", mask, got, want)
+		}
+	}
+	c := newCode(7)
+	// Version 7's information word is 000111110010010100, LSB first from
+	// the top left of the block next to the top-right finder.
+	want := 0b000111110010010100
+	for i := range 18 {
+		if got := c.Dark(c.Size-11+i%3, i/3); got != (want>>i&1 != 0) {
+			t.Fatalf("version bit %d = %v", i, got)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	// Total codewords per version, as tabulated in the standard.
+	total := []int{0, 26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+	for ver := 1; ver <= MaxVersion; ver++ {
+		if got := rawModules(ver) / 8; got != total[ver] {
+			t.Errorf("version %d: %d codewords, want %d", ver, got, total[ver])
+		}
+		c := newCode(ver)
+		n := 0
+		c.zigzag(func(int, int) { n++ })
+		if n != rawModules(ver) {
+			t.Errorf("version %d: zigzag visits %d modules, want %d", ver, n, rawModules(ver))
+		}
+	}
+	if _, err := Encode(make([]byte, 213)); err != nil {
+		t.Errorf("213 bytes: %v", err)
+	}
+	if _, err := Encode(make([]byte, 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("214 bytes: err = %v, want ErrTooLong", err)
+	}
+}
+
+// TestEncodeReadBack reads the symbol the way a scanner would once it has
+// located it: format word, unmask, codewords in placement order.
+func TestEncodeReadBack(t *testing.T) {
+	for _, s := range []string{"", "http://lan:8080/desk", "https://walls.example.com/lanpaper/" + strings.Repeat("x", 150)} {
+		c, err := Encode([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ver := (c.Size - 17) / 4
+
+		format := 0
+		for i := range 6 {
+			if c.Dark(8, i) {
+				format |= 1 << i
+			}
+		}
+		mask := -1
+		for m := range 8 {
+			if formatBits(m)&0x3f == format {
+				mask = m
+			}
+		}
+		if mask < 0 {
+			t.Fatalf("%q: unknown format bits %06b", s, format)
+		}
+
+		var got []byte
+		var cur byte
+		n := 0
+		c.zigzag(func(x, y int) {
+			bit := c.Dark(x, y) != maskBit(mask, x, y)
+			cur = cur<<1 | b2i(bit)
+			if n++; n%8 == 0 {
+				got = append(got, cur)
+			}
+		})
+		want := interleave(ver, dataBytes(ver, []byte(s)))
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%q: codewords read back differ", s)
+		}
+		// Version 1-9 byte mode: 0100, 8-bit count, payload.
+		if ver < 10 && numBlocks[ver] == 1 && (got[0]>>4 != 0b0100 || int(got[0]&0xf<<4|got[1]>>4) != len(s)) {
+			t.Errorf("%q: header %08b %08b", s, got[0], got[1])
+		}
+	}
+}
+
+func b2i(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode([]byte("http://lan/desk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := c.Image(256)
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		t.Fatalf("bounds = %v", b)
+	}
+	if err := png.Encode(&bytes.Buffer{}, img); err != nil {
+		t.Fatal(err)
+	}
+	scale := 256 / (c.Size + 2*QuietZone)
+	off := (256-scale*(c.Size+2*QuietZone))/2 + QuietZone*scale
+	// The top-left finder's corner is dark, the quiet zone light.
+	if img.ColorIndexAt(off, off) != 1 || img.ColorIndexAt(off-1, off-1) != 0 {
+		t.Error("finder not where expected")
+	}
+	if small := c.Image(10); small.Bounds().Dx() != c.Size+2*QuietZone {
+		t.Errorf("undersized image = %v", small.Bounds())
+	}
+
+	svg := string(c.SVG(256))
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `width="256"`) || !strings.Contains(svg, "M4 4h7") {
+		t.Errorf("svg = %.200s", svg)
+	}
+}
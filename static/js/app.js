@@ -5,6 +5,10 @@
 
 
 // STATE & CONFIG
+// URL prefix when served under a base path (see BASE_PATH); empty at the root.
+const BASE = document.querySelector('meta[name="lanpaper-base"]')?.content || '';
+const CSRF_TOKEN = document.querySelector('meta[name="lanpaper-csrf"]')?.content || '';
+
 const STATE = {
     translations: {},
     lang: localStorage.getItem('lang') || navigator.language.slice(0, 2) || 'en',
@@ -102,14 +106,14 @@ document.addEventListener('DOMContentLoaded', async () => {
 
 function initPWA() {
     if ('serviceWorker' in navigator) {
-        navigator.serviceWorker.register('/static/sw.js').catch(() => {});
+        navigator.serviceWorker.register(BASE + '/static/sw.js').catch(() => {});
     }
 }
 
 
 async function loadCompressionConfig() {
     try {
-        const res = await fetch('/api/compression-config');
+        const res = await fetch(BASE + '/api/compression-config');
         if (res.ok) STATE.compressionConfig = await res.json();
     } catch (_) {}
 }
@@ -203,7 +207,7 @@ function showDragDropHint() {
 
 async function loadAppVersion() {
     try {
-        const res = await fetch('/health');
+        const res = await fetch(BASE + '/health');
         if (!res.ok) return;
         const data = await res.json();
         if (data.version && DOM.appVersion) DOM.appVersion.textContent = `v${data.version}`;
@@ -277,7 +281,7 @@ function applyTheme() {
     });
 
     const logo = document.querySelector('.logo');
-    if (logo) logo.src = STATE.isDark ? '/static/logo-dark.svg' : '/static/logo.svg';
+    if (logo) logo.src = BASE + (STATE.isDark ? '/static/logo-dark.svg' : '/static/logo.svg');
 
     const icons = DOM.themeBtn.querySelectorAll('.theme-icon');
     icons.forEach(icon => icon.classList.remove('active'));
@@ -343,7 +347,7 @@ async function setLanguage(lang) {
     document.documentElement.lang = lang;
 
     try {
-        const res = await fetch(`/static/i18n/${lang}.json`);
+        const res = await fetch(`${BASE}/static/i18n/${lang}.json`);
         STATE.translations = res.ok ? await res.json() : {};
     } catch (_) {
         STATE.translations = {};
@@ -802,7 +806,12 @@ function confirmModal() {
 async function loadExternalImages() {
     DOM.modalList.innerHTML = `<div class="modal-list-msg">${t('loading', 'Loading...')}</div>`;
     try {
-        const res = await fetch('/api/external-images');
+        const res = await fetch(BASE + '/api/external-images');
+        // 404: the server runs with DISABLE_EXTERNAL_IMAGES.
+        if (res.status === 404) {
+            DOM.modalList.innerHTML = `<div class="modal-list-msg muted">${t('server_empty', 'No images found')}</div>`;
+            return;
+        }
         if (!res.ok) throw new Error('Failed');
         const files = await res.json();
 
@@ -817,7 +826,7 @@ async function loadExternalImages() {
             const div = document.createElement('div');
             div.className = 'image-option';
             div.dataset.value = file;
-            const previewUrl = `/api/external-image-preview?path=${encodeURIComponent(file)}`;
+            const previewUrl = `${BASE}/api/external-image-preview?path=${encodeURIComponent(file)}`;
             const nameEl = document.createElement('div');
             nameEl.className = 'image-name';
             nameEl.textContent = file;
@@ -850,13 +859,12 @@ async function loadExternalImages() {
 
 // API
 async function apiCall(url, method = 'GET', body = null, isFormData = false) {
-    const options = {
-        method,
-        headers: isFormData ? {} : { 'Content-Type': 'application/json' }
-    };
+    const headers = isFormData ? {} : { 'Content-Type': 'application/json' };
+    if (method !== 'GET') headers['X-CSRF-Token'] = CSRF_TOKEN;
+    const options = { method, headers };
     if (body) options.body = isFormData ? body : JSON.stringify(body);
     try {
-        const res = await fetch(url, options);
+        const res = await fetch(BASE + url, options);
         if (!res.ok) {
             const text = await res.text();
             throw new Error(text || `HTTP ${res.status}`);
@@ -1122,7 +1130,7 @@ function updateCard(card, link) {
     }
     card.dataset.linkName = linkName;
 
-    const fullUrl = `${window.location.origin}/${linkName}`;
+    const fullUrl = `${window.location.origin}${BASE}/${linkName}`;
 
     const previewLink = card.querySelector('.preview-link');
     previewLink.href = fullUrl;
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupCount is how many timestamped copies of previous saves to keep in
+// addition to the single .bak. Zero keeps only the .bak.
+var backupCount int
+
+// InitBackups sets how many timestamped backups Save keeps.
+func InitBackups(n int) {
+	if n < 0 {
+		n = 0
+	}
+	backupCount = n
+}
+
+const backupTimeFormat = "20060102-150405.000"
+
+// snapshotBackup copies the freshly rotated .bak of path to a timestamped
+// file and prunes the oldest ones beyond backupCount. The .bak alone only
+// reaches one save back, which is not enough to recover from a bug that
+// writes a valid but empty store and is followed by further saves.
+// A .bak identical to the newest snapshot is skipped, so saves that
+// change nothing don't push real history out.
+func snapshotBackup(path string) {
+	if backupCount <= 0 {
+		return
+	}
+	data, err := os.ReadFile(backupPath(path))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read backup: %v", err)
+		}
+		return
+	}
+	if snaps := backupSnapshots(path); len(snaps) > 0 {
+		if last, err := os.ReadFile(snaps[len(snaps)-1]); err == nil && bytes.Equal(last, data) {
+			return
+		}
+	}
+	// Written via a temp file like the data file itself, so a crash never
+	// leaves a truncated snapshot that looks like the newest one.
+	name := fmt.Sprintf("%s.%s.bak", path, time.Now().Format(backupTimeFormat))
+	tmpName, err := writeTemp(path, data)
+	if err != nil {
+		log.Printf("Warning: failed to write backup %s: %v", name, err)
+		return
+	}
+	if err := os.Rename(tmpName, name); err != nil {
+		os.Remove(tmpName)
+		log.Printf("Warning: failed to write backup %s: %v", name, err)
+		return
+	}
+	pruneBackups(path, backupCount)
+}
+
+// backupSnapshots returns the timestamped backups of path, oldest first.
+// The plain .bak doesn't match the glob; timestamps sort lexically.
+func backupSnapshots(path string) []string {
+	matches, err := filepath.Glob(path + ".*.bak")
+	if err != nil {
+		return nil
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// pruneBackups removes all but the newest keep timestamped backups of path.
+func pruneBackups(path string, keep int) {
+	matches := backupSnapshots(path)
+	for len(matches) > keep {
+		if err := os.Remove(matches[0]); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to prune backup %s: %v", matches[0], err)
+		}
+		matches = matches[1:]
+	}
+}
@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -17,6 +21,7 @@ type Wallpaper struct {
 	Category  string `json:"category"`
 	ImageURL  string `json:"imageUrl"`
 	Preview   string `json:"preview"`
+	Poster    string `json:"poster,omitempty"` // still frame for videos
 	HasImage  bool   `json:"hasImage"`
 	MIMEType  string `json:"mimeType"`
 	SizeBytes int64  `json:"sizeBytes"`
@@ -24,16 +29,78 @@ type Wallpaper struct {
 	CreatedAt int64  `json:"createdAt"`
 	IsPinned  bool   `json:"isPinned"`
 	PinnedAt  int64  `json:"pinnedAt,omitempty"`
+	Width     int    `json:"width,omitempty"` // stored image or video size; 0 for older entries and unprobed videos
+	Height    int    `json:"height,omitempty"`
+	// DurationSeconds is a video's length as reported by ffprobe; 0 for
+	// images and when ffprobe wasn't run.
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	// Hash is the SHA-256 of the stored image when it was uploaded with
+	// HashedStorage; its files are then named after the hash instead of
+	// the link. Empty for link-named entries.
+	Hash string `json:"hash,omitempty"`
+	// PHash is the perceptual hash of the main image (see
+	// imageproc.PHash), set when PerceptualHash is enabled. 0 means none.
+	PHash uint64 `json:"phash,omitempty"`
+	// UploadSeq increases with every upload or touch of the main image and
+	// orders entries whose ModTime falls in the same second. 0 for older
+	// entries, which fall back to the ID.
+	UploadSeq int64 `json:"uploadSeq,omitempty"`
+
+	// Variants holds alternative images keyed by device class ("mobile",
+	// "desktop"). Public serves the matching variant when the client hints
+	// for one, falling back to the main image.
+	Variants map[string]*Variant `json:"variants,omitempty"`
+
+	// Album holds further images appended after the main one, which is
+	// always the album's first image. Public rotates through them or
+	// serves one by index.
+	Album []*Variant `json:"album,omitempty"`
 
 	// Not persisted; derived from MIMEType on Load.
 	ImagePath   string `json:"-"`
 	PreviewPath string `json:"-"`
+	PosterPath  string `json:"-"`
+}
+
+// Variant is an alternative image stored alongside a wallpaper's main image,
+// either as a device variant or as an album entry.
+type Variant struct {
+	ImageURL  string `json:"imageUrl"`
+	MIMEType  string `json:"mimeType"`
+	SizeBytes int64  `json:"sizeBytes"`
+	ModTime   int64  `json:"modTime"`
+
+	ImagePath string `json:"-"` // derived from ImageURL on Load
+}
+
+// Clone returns a copy of wp whose Variants map and Album can be modified
+// without affecting the original.
+func (wp *Wallpaper) Clone() *Wallpaper {
+	clone := *wp
+	if wp.Variants != nil {
+		clone.Variants = make(map[string]*Variant, len(wp.Variants))
+		for k, v := range wp.Variants {
+			vc := *v
+			clone.Variants[k] = &vc
+		}
+	}
+	if wp.Album != nil {
+		clone.Album = make([]*Variant, len(wp.Album))
+		for i, v := range wp.Album {
+			vc := *v
+			clone.Album[i] = &vc
+		}
+	}
+	return &clone
 }
 
 // Store is a thread-safe in-memory store backed by a JSON file.
 // sortedSnap caches the sorted slice and is invalidated on any mutation.
 type Store struct {
 	sync.RWMutex
+	// saveMu serializes Save: the read lock alone lets two saves rotate
+	// and rename the same files at once.
+	saveMu     sync.Mutex
 	wallpapers map[string]*Wallpaper
 	sortedSnap []*Wallpaper
 }
@@ -50,18 +117,43 @@ func (s *Store) Get(id string) (*Wallpaper, bool) {
 	return wp, ok
 }
 
+// Set stores wp under id. A cached snapshot is patched in O(n) rather than
+// being discarded, so interleaved writes and reads don't re-sort every time.
 func (s *Store) Set(id string, wp *Wallpaper) {
 	s.Lock()
 	defer s.Unlock()
+	old := s.wallpapers[id]
 	s.wallpapers[id] = wp
-	s.sortedSnap = nil
+	if s.sortedSnap != nil {
+		s.sortedSnap = snapReplace(s.sortedSnap, old, wp)
+	}
 }
 
 func (s *Store) Delete(id string) {
 	s.Lock()
 	defer s.Unlock()
+	old, ok := s.wallpapers[id]
 	delete(s.wallpapers, id)
-	s.sortedSnap = nil
+	if ok && s.sortedSnap != nil {
+		s.sortedSnap = snapReplace(s.sortedSnap, old, nil)
+	}
+}
+
+// SharedPath reports whether an entry other than exceptID still uses p as
+// its image, preview or poster. With hashed storage, links holding the same
+// image share one file, which must outlive all but the last of them.
+func (s *Store) SharedPath(p, exceptID string) bool {
+	if p == "" {
+		return false
+	}
+	s.RLock()
+	defer s.RUnlock()
+	for id, wp := range s.wallpapers {
+		if id != exceptID && wp.HasImage && (wp.ImagePath == p || wp.PreviewPath == p || wp.PosterPath == p) {
+			return true
+		}
+	}
+	return false
 }
 
 // Rename atomically renames oldName -> newName in the store.
@@ -84,26 +176,62 @@ func (s *Store) Rename(oldName, newName string) (*Wallpaper, bool) {
 	return wp, true
 }
 
-func sortSnap(snap []*Wallpaper) {
-	sort.Slice(snap, func(i, j int) bool {
-		// Pinned links always come first
-		if snap[i].IsPinned != snap[j].IsPinned {
-			return snap[i].IsPinned
-		}
-		// Among pinned, sort by PinnedAt (most recent first)
-		if snap[i].IsPinned && snap[j].IsPinned {
-			return snap[i].PinnedAt > snap[j].PinnedAt
-		}
-		// Then by image presence
-		if snap[i].HasImage != snap[j].HasImage {
-			return snap[i].HasImage
+// snapLess reports whether a sorts before b in the default listing order.
+func snapLess(a, b *Wallpaper) bool {
+	// Pinned links always come first
+	if a.IsPinned != b.IsPinned {
+		return a.IsPinned
+	}
+	// Among pinned, sort by PinnedAt (most recent first)
+	if a.IsPinned && b.IsPinned {
+		if a.PinnedAt != b.PinnedAt {
+			return a.PinnedAt > b.PinnedAt
 		}
-		// Then by modification/creation time
-		if snap[i].HasImage {
-			return snap[i].ModTime > snap[j].ModTime
+		return a.ID < b.ID
+	}
+	// Then by image presence
+	if a.HasImage != b.HasImage {
+		return a.HasImage
+	}
+	// Then by modification/creation time
+	if a.HasImage && a.ModTime != b.ModTime {
+		return a.ModTime > b.ModTime
+	}
+	if a.HasImage && a.UploadSeq != b.UploadSeq {
+		return a.UploadSeq > b.UploadSeq
+	}
+	if !a.HasImage && a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt > b.CreatedAt
+	}
+	// Timestamps have one-second resolution; break ties by ID so entries
+	// from the same second keep a fixed order between listings.
+	return a.ID < b.ID
+}
+
+func sortSnap(snap []*Wallpaper) {
+	sort.Slice(snap, func(i, j int) bool { return snapLess(snap[i], snap[j]) })
+}
+
+// snapReplace returns a new sorted snapshot with old removed and wp (if
+// non-nil) inserted at its sorted position. The input slice may be held by
+// GetAll callers, so it is never modified. old is matched by pointer because
+// handlers mutate entries in place before calling Set, so its sort keys may
+// already have changed.
+func snapReplace(snap []*Wallpaper, old, wp *Wallpaper) []*Wallpaper {
+	out := make([]*Wallpaper, 0, len(snap)+1)
+	for _, e := range snap {
+		if e != old {
+			out = append(out, e)
 		}
-		return snap[i].CreatedAt > snap[j].CreatedAt
-	})
+	}
+	if wp == nil {
+		return out
+	}
+	i := sort.Search(len(out), func(i int) bool { return !snapLess(out[i], wp) })
+	out = append(out, nil)
+	copy(out[i+1:], out[i:])
+	out[i] = wp
+	return out
 }
 
 // GetAll returns a sorted snapshot: pinned first, then images (newest ModTime),
@@ -140,70 +268,180 @@ func (s *Store) GetAllCopy() []*Wallpaper {
 	original := s.GetAll()
 	snap := make([]*Wallpaper, len(original))
 	for i, wp := range original {
-		clone := *wp
-		snap[i] = &clone
+		snap[i] = wp.Clone()
 	}
 	return snap
 }
 
+// backupPath and checksumPath name the sidecar files kept next to the data
+// file: the previous successful save, and a SHA-256 of each file's contents.
+func backupPath(path string) string   { return path + ".bak" }
+func checksumPath(path string) string { return path + ".sha256" }
+
+// writeTemp writes body to a new temp file next to path and returns its name.
+func writeTemp(path string, body []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".wallpapers-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("create temp: %w", err)
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return "", fmt.Errorf("write temp: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return "", fmt.Errorf("close temp: %w", err)
+	}
+	return tmpName, nil
+}
+
+// copyFile replaces dst with a copy of src, via a temp file and rename.
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	tmpName, err := writeTemp(dst, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, dst); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
 // atomicWrite marshals data to a temp file and renames it atomically,
-// so a crash mid-write never produces a truncated JSON file.
+// so a crash mid-write never produces a truncated JSON file. The previous
+// file is kept as a .bak and a .sha256 checksum is written alongside each,
+// so Load can detect corruption and fall back to the last good save.
 func atomicWrite(path string, data map[string]*Wallpaper) error {
 	body, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
-	tmp, err := os.CreateTemp(filepath.Dir(path), ".wallpapers-*.json")
+	sum := sha256.Sum256(body)
+	tmpName, err := writeTemp(path, body)
 	if err != nil {
-		return fmt.Errorf("create temp: %w", err)
+		return err
 	}
-	tmpName := tmp.Name()
-	if _, err := tmp.Write(body); err != nil {
-		tmp.Close()
+	sumTmp, err := writeTemp(path, []byte(hex.EncodeToString(sum[:])+"\n"))
+	if err != nil {
 		os.Remove(tmpName)
-		return fmt.Errorf("write temp: %w", err)
+		return err
 	}
-	if err := tmp.Close(); err != nil {
-		os.Remove(tmpName)
-		return fmt.Errorf("close temp: %w", err)
+
+	// Copy the current file to .bak rather than moving it, so the data file
+	// never goes missing. A crash before the final rename leaves it as is.
+	if err := copyFile(path, backupPath(path)); err == nil {
+		// Drop the stale checksum first: a .bak without one is still loaded,
+		// one with a mismatched checksum is not.
+		os.Remove(checksumPath(backupPath(path)))
+		if err := copyFile(checksumPath(path), checksumPath(backupPath(path))); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to rotate checksum: %v", err)
+		}
+		snapshotBackup(path)
+	} else if !os.IsNotExist(err) {
+		log.Printf("Warning: failed to keep backup of %s: %v", path, err)
 	}
+
 	if err := os.Rename(tmpName, path); err != nil {
 		os.Remove(tmpName)
+		os.Remove(sumTmp)
 		return fmt.Errorf("rename temp: %w", err)
 	}
+	if err := os.Rename(sumTmp, checksumPath(path)); err != nil {
+		os.Remove(sumTmp)
+		return fmt.Errorf("rename checksum: %w", err)
+	}
 	return nil
 }
 
+// readVerified reads and decodes a data file, checking it against its
+// .sha256 sidecar when one exists (files from older versions have none).
+func readVerified(path string) (map[string]*Wallpaper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if want, err := os.ReadFile(checksumPath(path)); err == nil {
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != strings.TrimSpace(string(want)) {
+			return nil, fmt.Errorf("%s: checksum mismatch", path)
+		}
+	}
+	m := make(map[string]*Wallpaper)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
 // Save persists the current state to disk atomically.
 func (s *Store) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
 	s.RLock()
 	defer s.RUnlock()
 	return atomicWrite(dataFile, s.wallpapers)
 }
 
-// derivePaths fills runtime-only ImagePath/PreviewPath from persisted fields.
+// derivePaths fills runtime-only ImagePath/PreviewPath/PosterPath from persisted fields.
 func derivePaths(wp *Wallpaper) {
+	for _, v := range wp.Variants {
+		if v != nil && v.ImageURL != "" {
+			v.ImagePath = filepath.Join("static", "images", path.Base(v.ImageURL))
+		}
+	}
+	for _, v := range wp.Album {
+		if v != nil && v.ImageURL != "" {
+			v.ImagePath = filepath.Join("static", "images", path.Base(v.ImageURL))
+		}
+	}
 	if !wp.HasImage || wp.MIMEType == "" {
 		return
 	}
-	wp.ImagePath = filepath.Join("static", "images", wp.LinkName+"."+wp.MIMEType)
-	if wp.MIMEType != "mp4" && wp.MIMEType != "webm" {
-		wp.PreviewPath = filepath.Join("static", "images", "previews", wp.LinkName+".webp")
+	base := wp.LinkName
+	if wp.Hash != "" {
+		base = wp.Hash
 	}
+	wp.ImagePath = filepath.Join("static", "images", base+"."+wp.MIMEType)
+	if wp.MIMEType == "mp4" || wp.MIMEType == "webm" {
+		if wp.Poster != "" {
+			wp.PosterPath = filepath.Join("static", "images", "previews", path.Base(wp.Poster))
+		}
+		return
+	}
+	// The preview's name can carry a content key and its format is
+	// configurable, so take it from the stored URL; entries saved before
+	// the URL was recorded have a link-named WebP.
+	name := base + ".webp"
+	if wp.Preview != "" {
+		name = path.Base(wp.Preview)
+	}
+	wp.PreviewPath = filepath.Join("static", "images", "previews", name)
 }
 
 // Load reads wallpapers from disk. A missing file is treated as first run.
+// If the file is missing or corrupt but a backup from the previous save is
+// intact, the backup is loaded instead. When neither can be read the current
+// in-memory state is left unchanged.
 func (s *Store) Load() error {
-	data, err := os.ReadFile(dataFile)
+	m, err := readVerified(dataFile)
 	if err != nil {
-		if os.IsNotExist(err) {
+		bak, bakErr := readVerified(backupPath(dataFile))
+		switch {
+		case bakErr == nil:
+			log.Printf("Warning: %v — loaded previous save from %s", err, backupPath(dataFile))
+			m = bak
+		case os.IsNotExist(err) && os.IsNotExist(bakErr):
 			return nil
+		default:
+			return err
 		}
-		return err
-	}
-	m := make(map[string]*Wallpaper)
-	if err := json.Unmarshal(data, &m); err != nil {
-		return err
 	}
 	for key, wp := range m {
 		if wp == nil {
@@ -227,9 +465,9 @@ func (s *Store) Load() error {
 }
 
 // PruneOldImages removes the oldest non-pinned images when count exceeds max,
-// preserving empty slots and pinned entries.
+// preserving empty slots and pinned entries, and returns the IDs it pruned.
 // File I/O is performed outside the lock to avoid blocking Get/Set during disk operations.
-func PruneOldImages(max int) {
+func PruneOldImages(max int) []string {
 	Global.Lock()
 	var candidates []*Wallpaper
 	for _, wp := range Global.wallpapers {
@@ -242,21 +480,34 @@ func PruneOldImages(max int) {
 	Global.Unlock()
 
 	if len(candidates) <= max {
-		return
+		return nil
 	}
 
 	sort.Slice(candidates, func(i, j int) bool {
 		return candidates[i].ModTime < candidates[j].ModTime
 	})
 
+	var pruned []string
 	for _, wp := range candidates[:len(candidates)-max] {
 		log.Printf("Pruning old image: %s", wp.ID)
-		if err := os.Remove(wp.ImagePath); err != nil && !os.IsNotExist(err) {
-			log.Printf("Error pruning image %s: %v", wp.ImagePath, err)
+		pruned = append(pruned, wp.ID)
+		if !Global.SharedPath(wp.ImagePath, wp.ID) {
+			if err := os.Remove(wp.ImagePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error pruning image %s: %v", wp.ImagePath, err)
+			}
+		}
+		for _, p := range []string{wp.PreviewPath, wp.PosterPath} {
+			if p == "" || Global.SharedPath(p, wp.ID) {
+				continue
+			}
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error pruning preview %s: %v", p, err)
+			}
 		}
-		if wp.PreviewPath != "" {
-			if err := os.Remove(wp.PreviewPath); err != nil && !os.IsNotExist(err) {
-				log.Printf("Error pruning preview %s: %v", wp.PreviewPath, err)
+		// An album goes with its first image.
+		for _, v := range wp.Album {
+			if err := os.Remove(v.ImagePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error pruning album image %s: %v", v.ImagePath, err)
 			}
 		}
 		Global.Set(wp.ID, &Wallpaper{
@@ -266,10 +517,12 @@ func PruneOldImages(max int) {
 			CreatedAt: wp.CreatedAt,
 			IsPinned:  wp.IsPinned,
 			PinnedAt:  wp.PinnedAt,
+			Variants:  wp.Variants,
 		})
 	}
 
 	if err := Global.Save(); err != nil {
 		log.Printf("Error saving after pruning: %v", err)
 	}
+	return pruned
 }
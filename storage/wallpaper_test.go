@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(n int) *Store {
+	s := &Store{wallpapers: make(map[string]*Wallpaper, n)}
+	for i := range n {
+		id := fmt.Sprintf("wp%d", i)
+		s.wallpapers[id] = &Wallpaper{
+			ID: id, LinkName: id,
+			HasImage:  i%3 != 0,
+			IsPinned:  i%50 == 0,
+			PinnedAt:  int64(i),
+			ModTime:   int64(i * 7 % 1000),
+			CreatedAt: int64(i),
+		}
+	}
+	return s
+}
+
+// TestSetKeepsSnapshotSorted checks the incrementally maintained snapshot
+// matches what a full sort would produce after arbitrary mutations.
+func TestSetKeepsSnapshotSorted(t *testing.T) {
+	s := newTestStore(200)
+	s.GetAll() // populate the cache
+	rng := rand.New(rand.NewSource(1))
+
+	for i := range 500 {
+		id := fmt.Sprintf("wp%d", rng.Intn(250))
+		switch rng.Intn(4) {
+		case 0:
+			s.Delete(id)
+		case 1:
+			// In-place mutation followed by Set, as the handlers do.
+			if wp, ok := s.Get(id); ok {
+				wp.IsPinned = !wp.IsPinned
+				wp.PinnedAt = int64(i)
+				s.Set(id, wp)
+			}
+		default:
+			s.Set(id, &Wallpaper{ID: id, LinkName: id, HasImage: rng.Intn(2) == 0, ModTime: rng.Int63n(1000), CreatedAt: rng.Int63n(1000)})
+		}
+	}
+
+	snap := s.GetAll()
+	if len(snap) != len(s.wallpapers) {
+		t.Fatalf("snapshot has %d entries, store has %d", len(snap), len(s.wallpapers))
+	}
+	seen := make(map[*Wallpaper]bool, len(snap))
+	for i, wp := range snap {
+		if s.wallpapers[wp.ID] != wp || seen[wp] {
+			t.Fatalf("snapshot entry %d (%s) is stale or duplicated", i, wp.ID)
+		}
+		seen[wp] = true
+		if i > 0 && snapLess(wp, snap[i-1]) {
+			t.Fatalf("snapshot out of order at %d: %s before %s", i, snap[i-1].ID, wp.ID)
+		}
+	}
+}
+
+func TestSortSnapBreaksTiesByID(t *testing.T) {
+	var snap []*Wallpaper
+	for _, id := range []string{"d", "b", "e", "a", "c"} {
+		snap = append(snap, &Wallpaper{ID: id, LinkName: id, HasImage: true, ModTime: 100})
+	}
+	for _, id := range []string{"y", "x"} {
+		snap = append(snap, &Wallpaper{ID: id, LinkName: id, CreatedAt: 50})
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(snap), func(i, j int) { snap[i], snap[j] = snap[j], snap[i] })
+	sortSnap(snap)
+
+	var got string
+	for _, wp := range snap {
+		got += wp.ID
+	}
+	if want := "abcdexy"; got != want {
+		t.Errorf("order = %s, want %s", got, want)
+	}
+}
+
+// BenchmarkGetAllInterleaved models a bulk upload: every write is followed
+// by a listing read.
+func BenchmarkGetAllInterleaved(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			s := newTestStore(n)
+			s.GetAll()
+			b.ResetTimer()
+			for i := range b.N {
+				id := fmt.Sprintf("wp%d", i%n)
+				s.Set(id, &Wallpaper{ID: id, LinkName: id, HasImage: true, ModTime: int64(1000 + i)})
+				_ = s.GetAll()
+			}
+		})
+	}
+}
+
+func TestLoadFallsBackToBackup(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Store{wallpapers: map[string]*Wallpaper{"first": {ID: "first", LinkName: "first"}}}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("second", &Wallpaper{ID: "second", LinkName: "second"})
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Valid JSON that no longer matches its checksum, as a disk error might leave.
+	if err := os.WriteFile(dataFile, []byte(`{"other":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Store{wallpapers: make(map[string]*Wallpaper)}
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, ok := loaded.Get("first"); !ok {
+		t.Error("backup not loaded")
+	}
+	if _, ok := loaded.Get("other"); ok {
+		t.Error("corrupt file was loaded")
+	}
+
+	// With the backup gone too, Load fails and keeps the current state.
+	if err := os.Remove(backupPath(dataFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(); err == nil {
+		t.Error("Load of corrupt file without backup succeeded")
+	}
+	if _, ok := loaded.Get("first"); !ok {
+		t.Error("failed Load discarded in-memory state")
+	}
+}
+
+func TestConcurrentSave(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Store{wallpapers: make(map[string]*Wallpaper)}
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("wp%d", i)
+			s.Set(id, &Wallpaper{ID: id, LinkName: id})
+			errs <- s.Save()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Save: %v", err)
+		}
+	}
+
+	if _, err := readVerified(dataFile); err != nil {
+		t.Fatalf("data file: %v", err)
+	}
+	if _, err := readVerified(backupPath(dataFile)); err != nil {
+		t.Errorf("backup file: %v", err)
+	}
+	loaded := &Store{wallpapers: make(map[string]*Wallpaper)}
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := len(loaded.GetAll()); got != n {
+		t.Errorf("loaded %d wallpapers, want %d", got, n)
+	}
+}
+
+func TestSaveKeepsTimestampedBackups(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	InitBackups(2)
+	t.Cleanup(func() { InitBackups(0) })
+
+	s := &Store{wallpapers: make(map[string]*Wallpaper)}
+	for i := range 5 {
+		id := fmt.Sprintf("wp%d", i)
+		s.Set(id, &Wallpaper{ID: id, LinkName: id})
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // distinct backup timestamps
+	}
+
+	backups, err := filepath.Glob(dataFile + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(backups)
+	if len(backups) != 2 {
+		t.Fatalf("got %d timestamped backups, want 2: %v", len(backups), backups)
+	}
+	// The newest backup is the state before the last save: four entries.
+	m, err := readVerified(backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 4 {
+		t.Errorf("newest backup has %d entries, want 4", len(m))
+	}
+}
+
+func TestSaveSkipsUnchangedBackups(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	InitBackups(5)
+	t.Cleanup(func() { InitBackups(0) })
+
+	s := &Store{wallpapers: make(map[string]*Wallpaper)}
+	s.Set("a", &Wallpaper{ID: "a", LinkName: "a"})
+	// The first save has nothing to back up; the next three rotate the
+	// same content into .bak and should snapshot it once.
+	for range 4 {
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if got := backupSnapshots(dataFile); len(got) != 1 {
+		t.Fatalf("got %d snapshots of unchanged data, want 1: %v", len(got), got)
+	}
+
+	s.Set("b", &Wallpaper{ID: "b", LinkName: "b"})
+	for range 2 {
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if got := backupSnapshots(dataFile); len(got) != 2 {
+		t.Errorf("got %d snapshots after a change, want 2: %v", len(got), got)
+	}
+	if tmps, _ := filepath.Glob("data/.wallpapers-*.tmp"); len(tmps) > 0 {
+		t.Errorf("temp files left: %v", tmps)
+	}
+}
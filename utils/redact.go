@@ -0,0 +1,17 @@
+package utils
+
+import "net/url"
+
+// RedactURL returns raw with its userinfo, query string and fragment
+// removed, for logging URLs that may carry credentials or tokens. Only
+// scheme, host and path are kept; an unparseable URL is not logged at all.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[invalid URL]"
+	}
+	u.User = nil
+	u.RawQuery, u.ForceQuery = "", false
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+
+	"lanpaper/config"
+)
+
+// RequestScheme returns "https" when the client reached the server over
+// TLS, directly or through the TrustedProxy as told by X-Forwarded-Proto,
+// and "http" otherwise. The header is ignored from any other peer, since a
+// client could claim anything.
+func RequestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if config.IsTrustedProxy(r.RemoteAddr) {
+		// The first value is the one set by the proxy closest to the client.
+		p, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if p = strings.ToLower(strings.TrimSpace(p)); p == "http" || p == "https" {
+			return p
+		}
+	}
+	return "http"
+}